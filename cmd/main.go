@@ -55,4 +55,8 @@ func Shutdown() {
 	log.Println("[Main] Awaiting signal")
 	<-done
 	log.Println("[Main] Stopping consumer")
+
+	if err := srv.Shutdown(); err != nil {
+		log.Error("[Main] Failed shutdown: ", err)
+	}
 }
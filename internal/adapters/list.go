@@ -0,0 +1,71 @@
+package adapters
+
+import (
+	"sort"
+	"strings"
+)
+
+// S3ListQuery selects a page of objects like ListObjectsV2
+type S3ListQuery struct {
+	Bucket string
+	Prefix string
+	// Delimiter groups keys sharing part after prefix up to it into Prefixes
+	Delimiter string
+	// Cursor is continuation token of previous page
+	Cursor string
+	Limit  int64
+}
+
+// S3List is a page of objects, Cursor is empty on the last one
+type S3List struct {
+	Objects  []S3Obj
+	Prefixes []string
+	Cursor   string
+}
+
+// listPage pages objects of drivers without native listing,
+// cursor is the last key of previous page
+func listPage(objs []S3Obj, query *S3ListQuery) *S3List {
+	sort.Slice(objs, func(i, j int) bool {
+		return objs[i].Key < objs[j].Key
+	})
+
+	after := query.Cursor
+
+	list := &S3List{}
+	last := ""
+	for _, obj := range objs {
+		if !strings.HasPrefix(obj.Key, query.Prefix) || obj.Key <= after {
+			continue
+		}
+
+		prefix := ""
+		if query.Delimiter != "" {
+			rest := strings.TrimPrefix(obj.Key, query.Prefix)
+			if i := strings.Index(rest, query.Delimiter); i >= 0 {
+				prefix = query.Prefix + rest[:i+len(query.Delimiter)]
+			}
+		}
+
+		// keys of the same common prefix are one item
+		if prefix != "" && len(list.Prefixes) > 0 && list.Prefixes[len(list.Prefixes)-1] == prefix {
+			last = obj.Key
+			continue
+		}
+
+		if int64(len(list.Objects)+len(list.Prefixes)) >= query.Limit {
+			list.Cursor = last
+			break
+		}
+
+		last = obj.Key
+		if prefix != "" {
+			list.Prefixes = append(list.Prefixes, prefix)
+			continue
+		}
+
+		list.Objects = append(list.Objects, obj)
+	}
+
+	return list
+}
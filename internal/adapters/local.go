@@ -0,0 +1,487 @@
+package adapters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// LocalAdapter stores objects on local disk as <root>/<bucket>/<key>
+type LocalAdapter struct {
+	root   string
+	config *configs.S3Config
+}
+
+func NewLocalAdapter(
+	storageConfig *configs.StorageConfig,
+	config *configs.S3Config,
+) *LocalAdapter {
+	if err := os.MkdirAll(storageConfig.LocalRoot, 0o755); err != nil {
+		log.Error(err)
+		log.Fatal("[LocalAdapter] Failed init root")
+	}
+
+	return &LocalAdapter{
+		root:   storageConfig.LocalRoot,
+		config: config,
+	}
+}
+
+func (m *LocalAdapter) PutObj(ctx context.Context, obj *S3Obj) error {
+	if obj.ContentType == "" {
+		return errors.New("[LocalAdapter] PutObj empty content-type not allowed")
+	}
+
+	if err := m.write(obj); err != nil {
+		log.Errorf("[LocalAdapter] Failed %v", err.Error())
+		return errors.New("[LocalAdapter] PutObj failed to put")
+	}
+
+	return nil
+}
+
+func (m *LocalAdapter) SessionUpload(
+	ctx context.Context,
+	obj *S3Obj,
+) (*string, error) {
+	if obj.ContentType == "" {
+		return nil, errors.New("[LocalAdapter] Empty content-type not allowed")
+	}
+
+	if err := m.write(obj); err != nil {
+		log.Errorf("[LocalAdapter] Failed %v", err.Error())
+		return nil, err
+	}
+
+	location := m.location(obj)
+	log.Debugf("[LocalAdapter] Successfully uploaded file: %s\n", location)
+
+	return &location, nil
+}
+
+// GetPresign returns imgproxy local:// source, because files on disk need no signing
+func (m *LocalAdapter) GetPresign(
+	ctx context.Context,
+	obj *S3Obj,
+) (*string, error) {
+	if _, err := os.Stat(m.path(obj)); err != nil {
+		return nil, err
+	}
+
+	location := m.location(obj)
+
+	return &location, nil
+}
+
+// PutPresign isn't possible, there is no bucket to upload to directly
+func (m *LocalAdapter) PutPresign(
+	ctx context.Context,
+	obj *S3Obj,
+) (*string, http.Header, error) {
+	return nil, nil, ErrNotSupported
+}
+
+func (m *LocalAdapter) Stat(ctx context.Context, obj *S3Obj) (*S3Obj, error) {
+	f, err := os.Open(m.path(obj))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, ErrNotFound
+	}
+
+	// Disk doesn't keep content type, sniff it like uploads do
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	// like nginx, files on disk are versioned by mtime and size
+	etag := `"` + strconv.FormatInt(fi.ModTime().Unix(), 16) + "-" + strconv.FormatInt(fi.Size(), 16) + `"`
+
+	return &S3Obj{
+		Bucket:        m.bucket(obj),
+		Key:           obj.Key,
+		ContentLength: fi.Size(),
+		ContentType:   http.DetectContentType(head[:n]),
+		ETag:          etag,
+		LastModified:  fi.ModTime(),
+	}, nil
+}
+
+func (m *LocalAdapter) GetObj(ctx context.Context, obj *S3Obj) (io.ReadCloser, error) {
+	f, err := os.Open(m.path(obj))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	return f, err
+}
+
+func (m *LocalAdapter) GetRange(ctx context.Context, obj *S3Obj, offset, length int64) (io.ReadCloser, error) {
+	f, err := os.Open(m.path(obj))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(f, offset, length), f}, nil
+}
+
+func (m *LocalAdapter) DeleteObj(ctx context.Context, obj *S3Obj) error {
+	p := m.path(obj)
+
+	err := os.Remove(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	m.prune(obj, p)
+
+	return nil
+}
+
+func (m *LocalAdapter) MoveObj(ctx context.Context, obj *S3Obj, dst string) error {
+	target := m.path(&S3Obj{Bucket: obj.Bucket, Key: dst})
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+
+	p := m.path(obj)
+
+	err := os.Rename(p, target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	m.prune(obj, p)
+
+	return nil
+}
+
+func (m *LocalAdapter) DeleteObjs(ctx context.Context, objs []*S3Obj) error {
+	for _, obj := range objs {
+		if err := m.DeleteObj(ctx, obj); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *LocalAdapter) List(ctx context.Context, query *S3ListQuery) (*S3List, error) {
+	bucket := m.bucket(&S3Obj{Bucket: query.Bucket})
+	root := filepath.Join(m.root, bucket)
+
+	var objs []S3Obj
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		objs = append(objs, S3Obj{
+			Bucket:        bucket,
+			Key:           filepath.ToSlash(rel),
+			ContentLength: fi.Size(),
+			LastModified:  fi.ModTime(),
+		})
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return &S3List{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return listPage(objs, query), nil
+}
+
+// Multipart uploads are kept in <root>/.multipart/<uploadID> until completed
+
+func (m *LocalAdapter) CreateMultipart(ctx context.Context, obj *S3Obj) (string, error) {
+	if obj.ContentType == "" {
+		return "", errors.New("[LocalAdapter] Empty content-type not allowed")
+	}
+
+	uploadID := uuid.New().String()
+	if err := os.MkdirAll(m.uploadDir(uploadID), 0o755); err != nil {
+		return "", err
+	}
+
+	info, err := json.Marshal(localUpload{
+		Bucket:      m.bucket(obj),
+		Key:         obj.Key,
+		ContentType: obj.ContentType,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(filepath.Join(m.uploadDir(uploadID), "upload.json"), info, 0o644); err != nil {
+		return "", err
+	}
+
+	return uploadID, nil
+}
+
+func (m *LocalAdapter) UploadPart(ctx context.Context, obj *S3Obj) error {
+	if _, err := m.upload(obj); err != nil {
+		return err
+	}
+
+	return m.writeFile(filepath.Join(m.uploadDir(obj.UploadID), strconv.FormatInt(obj.PartNumber, 10)), obj.Reader())
+}
+
+func (m *LocalAdapter) ListParts(ctx context.Context, obj *S3Obj) ([]S3Part, error) {
+	if _, err := m.upload(obj); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(m.uploadDir(obj.UploadID))
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []S3Part
+	for _, e := range entries {
+		number, err := strconv.ParseInt(e.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		fi, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		parts = append(parts, S3Part{PartNumber: number, Size: fi.Size()})
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+
+	return parts, nil
+}
+
+func (m *LocalAdapter) CompleteMultipart(ctx context.Context, obj *S3Obj) (*string, error) {
+	upload, err := m.upload(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	parts, err := m.ListParts(ctx, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	readers := make([]io.Reader, 0, len(parts))
+	for _, p := range parts {
+		f, err := os.Open(filepath.Join(m.uploadDir(obj.UploadID), strconv.FormatInt(p.PartNumber, 10)))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		readers = append(readers, f)
+	}
+
+	upload.Body = io.MultiReader(readers...)
+	if err := m.write(upload); err != nil {
+		return nil, err
+	}
+
+	location := m.location(upload)
+
+	return &location, os.RemoveAll(m.uploadDir(obj.UploadID))
+}
+
+func (m *LocalAdapter) AbortMultipart(ctx context.Context, obj *S3Obj) error {
+	if _, err := m.upload(obj); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(m.uploadDir(obj.UploadID))
+}
+
+func (m *LocalAdapter) ListMultipart(ctx context.Context, obj *S3Obj) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(m.root, ".multipart"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var uploadIDs []string
+	for _, e := range entries {
+		upload, err := m.upload(&S3Obj{UploadID: e.Name()})
+		if err != nil {
+			continue
+		}
+
+		if upload.Bucket == m.bucket(obj) && upload.Key == obj.Key {
+			uploadIDs = append(uploadIDs, e.Name())
+		}
+	}
+
+	return uploadIDs, nil
+}
+
+// upload reads object info saved by CreateMultipart
+func (m *LocalAdapter) upload(obj *S3Obj) (*S3Obj, error) {
+	b, err := os.ReadFile(filepath.Join(m.uploadDir(obj.UploadID), "upload.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var upload localUpload
+	if err := json.Unmarshal(b, &upload); err != nil {
+		return nil, err
+	}
+
+	return &S3Obj{
+		Bucket:      upload.Bucket,
+		Key:         upload.Key,
+		ContentType: upload.ContentType,
+	}, nil
+}
+
+type localUpload struct {
+	Bucket      string `json:"bucket"`
+	Key         string `json:"key"`
+	ContentType string `json:"contentType"`
+}
+
+func (m *LocalAdapter) uploadDir(uploadID string) string {
+	return filepath.Join(m.root, ".multipart", filepath.Base(filepath.Clean("/"+uploadID)))
+}
+
+func (m *LocalAdapter) write(obj *S3Obj) error {
+	return m.writeFile(m.path(obj), obj.Reader())
+}
+
+// writeFile writes r to a temp file in <root>/.tmp renamed to p, so readers
+// never see partial files and a failed write keeps the previous file
+func (m *LocalAdapter) writeFile(p string, r io.Reader) error {
+	dir := filepath.Join(m.root, ".tmp")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	// temp files are private, stored ones are read by imgproxy too
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(p), 0o755)
+	if err == nil {
+		err = os.Rename(tmp.Name(), p)
+	}
+	// prune of a concurrent delete may remove the directory, create it again
+	if errors.Is(err, fs.ErrNotExist) {
+		if err = os.MkdirAll(filepath.Dir(p), 0o755); err == nil {
+			err = os.Rename(tmp.Name(), p)
+		}
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+// prune removes directories of deleted p left empty, up to the bucket,
+// like S3 has no empty prefixes
+func (m *LocalAdapter) prune(obj *S3Obj, p string) {
+	bucket := filepath.Join(m.root, m.bucket(obj))
+	for dir := filepath.Dir(p); strings.HasPrefix(dir, bucket+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		// fails on directories with files
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+func (m *LocalAdapter) bucket(obj *S3Obj) string {
+	if obj.Bucket == "" {
+		return m.config.Bucket
+	}
+
+	return obj.Bucket
+}
+
+// path resolves object on disk, cleaning key so it can't escape root
+func (m *LocalAdapter) path(obj *S3Obj) string {
+	return filepath.Join(m.root, m.bucket(obj), filepath.FromSlash(path.Clean("/"+obj.Key)))
+}
+
+func (m *LocalAdapter) location(obj *S3Obj) string {
+	return "local:///" + m.bucket(obj) + path.Clean("/"+obj.Key)
+}
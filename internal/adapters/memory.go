@@ -0,0 +1,385 @@
+package adapters
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/google/uuid"
+)
+
+// MemoryAdapter keeps objects in memory and records every call,
+// useful for running handlers without any storage
+type MemoryAdapter struct {
+	mu       sync.RWMutex
+	config   *configs.S3Config
+	objects  map[string]*S3Obj
+	uploads  map[string]*memoryUpload
+	puts     []S3Obj
+	sessions []S3Obj
+	presigns []S3Obj
+}
+
+func NewMemoryAdapter(
+	config *configs.S3Config,
+) *MemoryAdapter {
+	return &MemoryAdapter{
+		config:  config,
+		objects: make(map[string]*S3Obj),
+		uploads: make(map[string]*memoryUpload),
+	}
+}
+
+func (m *MemoryAdapter) PutObj(ctx context.Context, obj *S3Obj) error {
+	if obj.ContentType == "" {
+		return errors.New("[MemoryAdapter] PutObj empty content-type not allowed")
+	}
+
+	data, err := m.store(obj)
+	if err != nil {
+		return errors.New("[MemoryAdapter] PutObj failed to put")
+	}
+
+	m.mu.Lock()
+	m.puts = append(m.puts, *data)
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *MemoryAdapter) PutPresign(
+	ctx context.Context,
+	obj *S3Obj,
+) (*string, http.Header, error) {
+	if obj.ContentType == "" {
+		return nil, nil, errors.New("[MemoryAdapter] Empty content-type not allowed")
+	}
+
+	data := S3Obj(*obj)
+	data.Bucket = m.bucket(obj)
+
+	m.mu.Lock()
+	m.presigns = append(m.presigns, data)
+	m.mu.Unlock()
+
+	link := "memory://" + data.Bucket + "/" + data.Key
+	headers := http.Header{}
+	headers.Set("Content-Type", data.ContentType)
+
+	return &link, headers, nil
+}
+
+func (m *MemoryAdapter) Stat(ctx context.Context, obj *S3Obj) (*S3Obj, error) {
+	data := m.Object(obj.Bucket, obj.Key)
+	if data == nil {
+		return nil, ErrNotFound
+	}
+
+	return &S3Obj{
+		Bucket:        data.Bucket,
+		Key:           data.Key,
+		ContentLength: data.ContentLength,
+		ContentType:   data.ContentType,
+		Metadata:      data.Metadata,
+		ETag:          data.ETag,
+		LastModified:  data.LastModified,
+	}, nil
+}
+
+func (m *MemoryAdapter) GetObj(ctx context.Context, obj *S3Obj) (io.ReadCloser, error) {
+	data := m.Object(obj.Bucket, obj.Key)
+	if data == nil {
+		return nil, ErrNotFound
+	}
+
+	return io.NopCloser(bytes.NewReader(data.Bytes)), nil
+}
+
+func (m *MemoryAdapter) GetRange(ctx context.Context, obj *S3Obj, offset, length int64) (io.ReadCloser, error) {
+	data := m.Object(obj.Bucket, obj.Key)
+	if data == nil {
+		return nil, ErrNotFound
+	}
+
+	return io.NopCloser(io.NewSectionReader(bytes.NewReader(data.Bytes), offset, length)), nil
+}
+
+func (m *MemoryAdapter) DeleteObj(ctx context.Context, obj *S3Obj) error {
+	m.mu.Lock()
+	delete(m.objects, m.bucket(obj)+"/"+obj.Key)
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *MemoryAdapter) MoveObj(ctx context.Context, obj *S3Obj, dst string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bucket := m.bucket(obj)
+	data, ok := m.objects[bucket+"/"+obj.Key]
+	if !ok {
+		return ErrNotFound
+	}
+
+	moved := S3Obj(*data)
+	moved.Key = dst
+	m.objects[bucket+"/"+dst] = &moved
+	delete(m.objects, bucket+"/"+obj.Key)
+
+	return nil
+}
+
+func (m *MemoryAdapter) DeleteObjs(ctx context.Context, objs []*S3Obj) error {
+	for _, obj := range objs {
+		if err := m.DeleteObj(ctx, obj); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryAdapter) SessionUpload(
+	ctx context.Context,
+	obj *S3Obj,
+) (*string, error) {
+	if obj.ContentType == "" {
+		return nil, errors.New("[MemoryAdapter] Empty content-type not allowed")
+	}
+
+	data, err := m.store(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.sessions = append(m.sessions, *data)
+	m.mu.Unlock()
+
+	location := "memory://" + data.Bucket + "/" + data.Key
+
+	return &location, nil
+}
+
+func (m *MemoryAdapter) GetPresign(
+	ctx context.Context,
+	obj *S3Obj,
+) (*string, error) {
+	data := S3Obj(*obj)
+	data.Bucket = m.bucket(obj)
+
+	m.mu.Lock()
+	m.presigns = append(m.presigns, data)
+	m.mu.Unlock()
+
+	link := "memory://" + data.Bucket + "/" + data.Key
+
+	return &link, nil
+}
+
+type memoryUpload struct {
+	obj   S3Obj
+	parts map[int64][]byte
+}
+
+func (m *MemoryAdapter) CreateMultipart(ctx context.Context, obj *S3Obj) (string, error) {
+	if obj.ContentType == "" {
+		return "", errors.New("[MemoryAdapter] Empty content-type not allowed")
+	}
+
+	data := S3Obj(*obj)
+	data.Bucket = m.bucket(obj)
+	data.Body = nil
+	data.Bytes = nil
+
+	uploadID := uuid.New().String()
+
+	m.mu.Lock()
+	m.uploads[uploadID] = &memoryUpload{obj: data, parts: make(map[int64][]byte)}
+	m.mu.Unlock()
+
+	return uploadID, nil
+}
+
+func (m *MemoryAdapter) UploadPart(ctx context.Context, obj *S3Obj) error {
+	b, err := io.ReadAll(obj.Reader())
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, ok := m.uploads[obj.UploadID]
+	if !ok {
+		return ErrNotFound
+	}
+	upload.parts[obj.PartNumber] = b
+
+	return nil
+}
+
+func (m *MemoryAdapter) ListParts(ctx context.Context, obj *S3Obj) ([]S3Part, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	upload, ok := m.uploads[obj.UploadID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	parts := make([]S3Part, 0, len(upload.parts))
+	for number, b := range upload.parts {
+		parts = append(parts, S3Part{PartNumber: number, Size: int64(len(b))})
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+
+	return parts, nil
+}
+
+func (m *MemoryAdapter) CompleteMultipart(ctx context.Context, obj *S3Obj) (*string, error) {
+	parts, err := m.ListParts(ctx, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	upload := m.uploads[obj.UploadID]
+	delete(m.uploads, obj.UploadID)
+	m.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, p := range parts {
+		buf.Write(upload.parts[p.PartNumber])
+	}
+
+	data := upload.obj
+	data.Bytes = buf.Bytes()
+
+	return m.SessionUpload(ctx, &data)
+}
+
+func (m *MemoryAdapter) AbortMultipart(ctx context.Context, obj *S3Obj) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.uploads[obj.UploadID]; !ok {
+		return ErrNotFound
+	}
+	delete(m.uploads, obj.UploadID)
+
+	return nil
+}
+
+func (m *MemoryAdapter) ListMultipart(ctx context.Context, obj *S3Obj) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var uploadIDs []string
+	for uploadID, upload := range m.uploads {
+		if upload.obj.Bucket == m.bucket(obj) && upload.obj.Key == obj.Key {
+			uploadIDs = append(uploadIDs, uploadID)
+		}
+	}
+
+	return uploadIDs, nil
+}
+
+func (m *MemoryAdapter) List(ctx context.Context, query *S3ListQuery) (*S3List, error) {
+	bucket := m.bucket(&S3Obj{Bucket: query.Bucket})
+
+	m.mu.RLock()
+	objs := make([]S3Obj, 0, len(m.objects))
+	for _, obj := range m.objects {
+		if obj.Bucket == bucket {
+			objs = append(objs, S3Obj{
+				Bucket:        obj.Bucket,
+				Key:           obj.Key,
+				ContentLength: obj.ContentLength,
+				ContentType:   obj.ContentType,
+				LastModified:  obj.LastModified,
+			})
+		}
+	}
+	m.mu.RUnlock()
+
+	return listPage(objs, query), nil
+}
+
+// Object returns stored object or nil
+func (m *MemoryAdapter) Object(bucket, key string) *S3Obj {
+	if bucket == "" {
+		bucket = m.config.Bucket
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.objects[bucket+"/"+key]
+}
+
+// Puts returns objects passed to PutObj in call order
+func (m *MemoryAdapter) Puts() []S3Obj {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]S3Obj(nil), m.puts...)
+}
+
+// Sessions returns objects passed to SessionUpload in call order
+func (m *MemoryAdapter) Sessions() []S3Obj {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]S3Obj(nil), m.sessions...)
+}
+
+// Presigns returns objects passed to GetPresign in call order
+func (m *MemoryAdapter) Presigns() []S3Obj {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]S3Obj(nil), m.presigns...)
+}
+
+// store reads object body and saves a detached copy
+func (m *MemoryAdapter) store(obj *S3Obj) (*S3Obj, error) {
+	data := S3Obj(*obj)
+	data.Bucket = m.bucket(obj)
+
+	b, err := io.ReadAll(obj.Reader())
+	if err != nil {
+		return nil, err
+	}
+	data.Bytes = b
+	data.Body = nil
+	data.ContentLength = int64(len(data.Bytes))
+	data.LastModified = time.Now()
+	data.ETag = fmt.Sprintf(`"%x"`, md5.Sum(b))
+
+	m.mu.Lock()
+	m.objects[data.Bucket+"/"+data.Key] = &data
+	m.mu.Unlock()
+
+	return &data, nil
+}
+
+func (m *MemoryAdapter) bucket(obj *S3Obj) string {
+	if obj.Bucket == "" {
+		return m.config.Bucket
+	}
+
+	return obj.Bucket
+}
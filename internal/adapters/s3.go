@@ -4,7 +4,13 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/WildEgor/gImageResizer/internal/configs"
@@ -16,22 +22,92 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+var (
+	ErrNotFound     = errors.New("[Storage] Object not found")
+	ErrNotSupported = errors.New("[Storage] Operation not supported")
+)
+
 type S3Obj struct {
 	Bucket        string
 	Key           string
 	ContentLength int64
 	ContentType   string
-	Body          *io.ReadSeeker
+	Body          io.Reader
 	Bytes         []byte
 	PartNumber    int64
+	UploadID      string
+	// Metadata is user metadata of the object (x-amz-meta-*), keys are lower case.
+	// S3 limits it to 2KB, local driver doesn't keep it
+	Metadata map[string]string
+	// ETag and LastModified are set by Stat, List sets only LastModified
+	ETag         string
+	LastModified time.Time
+	// PresignTTL is lifetime of GetPresign URL, S3_DOWNLOAD_PRESIGN_TTL when zero.
+	// ContentType and ContentDisposition override response headers of the URL
+	PresignTTL         time.Duration
+	ContentDisposition string
+}
+
+// S3Part is an uploaded part of unfinished multipart upload
+type S3Part struct {
+	PartNumber int64
+	Size       int64
+	ETag       string
+}
+
+// Reader returns Body, falling back to Bytes when Body isn't set
+func (o *S3Obj) Reader() io.Reader {
+	if o.Body != nil {
+		return o.Body
+	}
+
+	return bytes.NewReader(o.Bytes)
 }
 
 type IS3Adapter interface {
 	PutObj(ctx context.Context, obj *S3Obj) error
+	GetObj(ctx context.Context, obj *S3Obj) (io.ReadCloser, error)
+	// GetRange reads length bytes of the object from offset
+	GetRange(ctx context.Context, obj *S3Obj, offset, length int64) (io.ReadCloser, error)
+	DeleteObj(ctx context.Context, obj *S3Obj) error
+	// DeleteObjs removes objects of one bucket in batches, missing objects are ignored
+	DeleteObjs(ctx context.Context, objs []*S3Obj) error
+	// MoveObj moves object to dst key of the same bucket with its content type and metadata
+	MoveObj(ctx context.Context, obj *S3Obj, dst string) error
 	SessionUpload(ctx context.Context, obj *S3Obj) (*string, error)
 	GetPresign(ctx context.Context, obj *S3Obj) (*string, error)
+	// PutPresign returns URL for direct upload and headers the upload must be sent with
+	PutPresign(ctx context.Context, obj *S3Obj) (*string, http.Header, error)
+	Stat(ctx context.Context, obj *S3Obj) (*S3Obj, error)
+	// Multipart upload steps, obj.UploadID identifies the upload
+	CreateMultipart(ctx context.Context, obj *S3Obj) (string, error)
+	UploadPart(ctx context.Context, obj *S3Obj) error
+	ListParts(ctx context.Context, obj *S3Obj) ([]S3Part, error)
+	CompleteMultipart(ctx context.Context, obj *S3Obj) (*string, error)
+	AbortMultipart(ctx context.Context, obj *S3Obj) error
+	// ListMultipart returns IDs of unfinished multipart uploads of obj.Key
+	ListMultipart(ctx context.Context, obj *S3Obj) ([]string, error)
+	// List returns a page of objects without content type, it needs Stat
+	List(ctx context.Context, query *S3ListQuery) (*S3List, error)
 }
 
+// S3 deletes at most 1000 objects per request
+const maxDeleteObjects = 1000
+
+// MaxParts is how many parts S3 multipart upload may have
+const MaxParts = 10000
+
+// S3 copies at most 5GB by one request, larger objects are copied by parts
+const maxCopySize = 5 << 30
+
+// copyPartSize is size of copied parts, grown for objects not fitting in MaxParts
+const copyPartSize = 512 << 20
+
+// abortTimeout bounds abort of failed multipart upload
+const abortTimeout = 30 * time.Second
+
+var errTooManyParts = errors.New("[S3Adapter] Upload exceeds 10000 parts")
+
 type S3Adapter struct {
 	client *s3.S3
 	config *configs.S3Config
@@ -49,6 +125,16 @@ func NewS3Adapter(
 	}
 
 	cfg := aws.NewConfig().WithRegion(config.Region).WithCredentials(creds)
+
+	// S3-compatible stores (MinIO, etc.) are served from a custom endpoint
+	// and usually don't support virtual-hosted bucket addressing
+	if config.Endpoint != "" {
+		cfg = cfg.
+			WithEndpoint(endpointURL(config.Endpoint, config.UseSSL)).
+			WithDisableSSL(!config.UseSSL).
+			WithS3ForcePathStyle(true)
+	}
+
 	ss, err := session.NewSession(cfg)
 
 	if err != nil {
@@ -63,6 +149,19 @@ func NewS3Adapter(
 	}
 }
 
+// endpointURL makes endpoint scheme follow UseSSL flag
+func endpointURL(endpoint string, useSSL bool) string {
+	if i := strings.Index(endpoint, "://"); i >= 0 {
+		endpoint = endpoint[i+3:]
+	}
+
+	if useSSL {
+		return "https://" + endpoint
+	}
+
+	return "http://" + endpoint
+}
+
 func (m *S3Adapter) PutObj(ctx context.Context, obj *S3Obj) error {
 	data := S3Obj(*obj)
 
@@ -74,12 +173,24 @@ func (m *S3Adapter) PutObj(ctx context.Context, obj *S3Obj) error {
 		return errors.New("[S3Adapter] PutObj empty content-type not allowed")
 	}
 
-	_, err := m.client.PutObject(&s3.PutObjectInput{
-		Body:          *data.Body,
+	// signed requests need a seekable body, PutObj is meant for small objects
+	body, ok := data.Reader().(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(data.Reader())
+		if err != nil {
+			return errors.New("[S3Adapter] PutObj failed to read body")
+		}
+		body = bytes.NewReader(b)
+		data.ContentLength = int64(len(b))
+	}
+
+	_, err := m.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Body:          body,
 		Key:           &data.Key,
 		ContentType:   &data.ContentType,
 		ContentLength: &data.ContentLength,
 		Bucket:        &data.Bucket,
+		Metadata:      aws.StringMap(data.Metadata),
 	})
 
 	if err != nil {
@@ -89,6 +200,186 @@ func (m *S3Adapter) PutObj(ctx context.Context, obj *S3Obj) error {
 	return nil
 }
 
+func (m *S3Adapter) GetObj(ctx context.Context, obj *S3Obj) (io.ReadCloser, error) {
+	data := S3Obj(*obj)
+
+	if obj.Bucket == "" {
+		data.Bucket = m.config.Bucket
+	}
+
+	resp, err := m.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: &data.Bucket,
+		Key:    &data.Key,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (m *S3Adapter) GetRange(ctx context.Context, obj *S3Obj, offset, length int64) (io.ReadCloser, error) {
+	data := S3Obj(*obj)
+
+	if obj.Bucket == "" {
+		data.Bucket = m.config.Bucket
+	}
+
+	resp, err := m.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: &data.Bucket,
+		Key:    &data.Key,
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (m *S3Adapter) DeleteObj(ctx context.Context, obj *S3Obj) error {
+	data := S3Obj(*obj)
+
+	if obj.Bucket == "" {
+		data.Bucket = m.config.Bucket
+	}
+
+	_, err := m.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: &data.Bucket,
+		Key:    &data.Key,
+	})
+
+	return err
+}
+
+// MoveObj copies the object server-side and deletes the source,
+// S3 has no rename. Objects over 5GB are copied by parts
+func (m *S3Adapter) MoveObj(ctx context.Context, obj *S3Obj, dst string) error {
+	data := S3Obj(*obj)
+
+	if obj.Bucket == "" {
+		data.Bucket = m.config.Bucket
+	}
+
+	src, err := m.Stat(ctx, &data)
+	if err != nil {
+		return err
+	}
+
+	source := (&url.URL{Path: data.Bucket + "/" + data.Key}).EscapedPath()
+	if src.ContentLength > maxCopySize {
+		err = m.copyParts(ctx, src, source, dst)
+	} else {
+		_, err = m.client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+			Bucket:     &data.Bucket,
+			Key:        &dst,
+			CopySource: &source,
+		})
+	}
+	if err != nil {
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	return m.DeleteObj(ctx, &data)
+}
+
+// copyParts copies src to dst with multipart upload of ranges of src,
+// content type and metadata are set on the upload as parts don't carry them
+func (m *S3Adapter) copyParts(ctx context.Context, src *S3Obj, source, dst string) error {
+	resp, err := m.client.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      &src.Bucket,
+		Key:         &dst,
+		ContentType: aws.String(src.ContentType),
+		Metadata:    aws.StringMap(src.Metadata),
+	})
+	if err != nil {
+		return err
+	}
+
+	size := partSize(copyPartSize, src.ContentLength)
+	completedParts := make([]*s3.CompletedPart, 0, (src.ContentLength+size-1)/size)
+	for offset, partNumber := int64(0), int64(1); offset < src.ContentLength; offset, partNumber = offset+size, partNumber+1 {
+		end := offset + size
+		if end > src.ContentLength {
+			end = src.ContentLength
+		}
+
+		part, err := m.client.UploadPartCopyWithContext(ctx, &s3.UploadPartCopyInput{
+			Bucket:          resp.Bucket,
+			Key:             resp.Key,
+			UploadId:        resp.UploadId,
+			PartNumber:      aws.Int64(partNumber),
+			CopySource:      &source,
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, end-1)),
+		})
+		if err != nil {
+			m.abortFailed(resp)
+			return err
+		}
+
+		completedParts = append(completedParts, &s3.CompletedPart{
+			ETag:       part.CopyPartResult.ETag,
+			PartNumber: aws.Int64(partNumber),
+		})
+	}
+
+	_, err = m.completeMultipartUpload(ctx, resp, completedParts)
+
+	return err
+}
+
+func (m *S3Adapter) DeleteObjs(ctx context.Context, objs []*S3Obj) error {
+	if len(objs) == 0 {
+		return nil
+	}
+
+	bucket := objs[0].Bucket
+	if bucket == "" {
+		bucket = m.config.Bucket
+	}
+
+	for start := 0; start < len(objs); start += maxDeleteObjects {
+		end := start + maxDeleteObjects
+		if end > len(objs) {
+			end = len(objs)
+		}
+
+		ids := make([]*s3.ObjectIdentifier, 0, end-start)
+		for _, obj := range objs[start:end] {
+			ids = append(ids, &s3.ObjectIdentifier{Key: aws.String(obj.Key)})
+		}
+
+		resp, err := m.client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: &bucket,
+			Delete: &s3.Delete{
+				Objects: ids,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		// quiet mode reports only failed keys
+		if len(resp.Errors) > 0 {
+			e := resp.Errors[0]
+			return fmt.Errorf("[S3Adapter] Failed delete %v: %v %v",
+				aws.StringValue(e.Key), aws.StringValue(e.Code), aws.StringValue(e.Message))
+		}
+	}
+
+	return nil
+}
+
 func (m *S3Adapter) GetPresign(
 	ctx context.Context,
 	obj *S3Obj,
@@ -99,12 +390,25 @@ func (m *S3Adapter) GetPresign(
 		data.Bucket = m.config.Bucket
 	}
 
-	req, _ := m.client.GetObjectRequest(&s3.GetObjectInput{
+	input := &s3.GetObjectInput{
 		Bucket: &data.Bucket,
 		Key:    &data.Key,
-	})
+	}
+	if data.ContentType != "" {
+		input.ResponseContentType = aws.String(data.ContentType)
+	}
+	if data.ContentDisposition != "" {
+		input.ResponseContentDisposition = aws.String(data.ContentDisposition)
+	}
 
-	link, err := req.Presign(time.Duration(168) * time.Hour)
+	ttl := data.PresignTTL
+	if ttl <= 0 {
+		ttl = m.config.DownloadPresignTTL
+	}
+
+	req, _ := m.client.GetObjectRequest(input)
+
+	link, err := req.Presign(m.config.ClampPresignTTL(ttl))
 
 	if err != nil {
 		return nil, err
@@ -113,6 +417,126 @@ func (m *S3Adapter) GetPresign(
 	return &link, nil
 }
 
+func (m *S3Adapter) PutPresign(
+	ctx context.Context,
+	obj *S3Obj,
+) (*string, http.Header, error) {
+	data := S3Obj(*obj)
+
+	if obj.Bucket == "" {
+		data.Bucket = m.config.Bucket
+	}
+
+	if obj.ContentType == "" {
+		return nil, nil, errors.New("[S3Adapter] Empty content-type not allowed")
+	}
+
+	// Content type and length are signed, so client can't upload anything else
+	req, _ := m.client.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:        &data.Bucket,
+		Key:           &data.Key,
+		ContentType:   &data.ContentType,
+		ContentLength: &data.ContentLength,
+	})
+
+	link, headers, err := req.PresignRequest(m.config.UploadPresignTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+	headers.Del("Host")
+
+	return &link, headers, nil
+}
+
+func (m *S3Adapter) Stat(ctx context.Context, obj *S3Obj) (*S3Obj, error) {
+	data := S3Obj(*obj)
+
+	if obj.Bucket == "" {
+		data.Bucket = m.config.Bucket
+	}
+
+	resp, err := m.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: &data.Bucket,
+		Key:    &data.Key,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &S3Obj{
+		Bucket:        data.Bucket,
+		Key:           data.Key,
+		ContentLength: aws.Int64Value(resp.ContentLength),
+		ContentType:   aws.StringValue(resp.ContentType),
+		Metadata:      metadata(resp.Metadata),
+		ETag:          aws.StringValue(resp.ETag),
+		LastModified:  aws.TimeValue(resp.LastModified),
+	}, nil
+}
+
+func (m *S3Adapter) List(ctx context.Context, query *S3ListQuery) (*S3List, error) {
+	bucket := query.Bucket
+	if bucket == "" {
+		bucket = m.config.Bucket
+	}
+
+	input := &s3.ListObjectsV2Input{
+		Bucket:  &bucket,
+		MaxKeys: aws.Int64(query.Limit),
+	}
+	if query.Prefix != "" {
+		input.Prefix = aws.String(query.Prefix)
+	}
+	if query.Delimiter != "" {
+		input.Delimiter = aws.String(query.Delimiter)
+	}
+	if query.Cursor != "" {
+		input.ContinuationToken = aws.String(query.Cursor)
+	}
+
+	resp, err := m.client.ListObjectsV2WithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &S3List{
+		Objects: make([]S3Obj, 0, len(resp.Contents)),
+	}
+	for _, o := range resp.Contents {
+		list.Objects = append(list.Objects, S3Obj{
+			Bucket:        bucket,
+			Key:           aws.StringValue(o.Key),
+			ContentLength: aws.Int64Value(o.Size),
+			LastModified:  aws.TimeValue(o.LastModified),
+		})
+	}
+	for _, p := range resp.CommonPrefixes {
+		list.Prefixes = append(list.Prefixes, aws.StringValue(p.Prefix))
+	}
+	if aws.BoolValue(resp.IsTruncated) {
+		list.Cursor = aws.StringValue(resp.NextContinuationToken)
+	}
+
+	return list, nil
+}
+
+// metadata lower cases keys, SDK returns them canonicalized as HTTP headers
+func metadata(m map[string]*string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+
+	md := make(map[string]string, len(m))
+	for k, v := range m {
+		md[strings.ToLower(k)] = aws.StringValue(v)
+	}
+
+	return md
+}
+
 func (m *S3Adapter) SessionUpload(
 	ctx context.Context,
 	obj *S3Obj,
@@ -127,10 +551,11 @@ func (m *S3Adapter) SessionUpload(
 		return nil, errors.New("[S3Adapter] Empty content-type not allowed")
 	}
 
-	resp, err := m.client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+	resp, err := m.client.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
 		Bucket:      &data.Bucket,
 		Key:         &data.Key,
 		ContentType: &data.ContentType,
+		Metadata:    aws.StringMap(data.Metadata),
 	})
 	if err != nil {
 		log.Errorf("[S3Adapter] Failed %v", err.Error())
@@ -138,52 +563,308 @@ func (m *S3Adapter) SessionUpload(
 	}
 	log.Debug("[S3Adapter] Created multipart upload request...")
 
-	var curr, partLength int64
-	var remaining = data.ContentLength
-	var completedParts []*s3.CompletedPart
-	partNumber := 1
-	maxPartSize := int64(5 * 1024 * 1024)
+	completedParts, err := m.uploadParts(ctx, resp, data.Reader(), data.ContentLength)
+	if err != nil {
+		log.Errorf("[S3Adapter] Failed %v", err.Error())
+		m.abortFailed(resp)
+		return nil, err
+	}
+
+	completeResponse, err := m.completeMultipartUpload(ctx, resp, completedParts)
+	if err != nil {
+		log.Errorf("[S3Adapter] Failed %v", err.Error())
+		return nil, err
+	}
 
-	log.Debug(remaining)
+	log.Debugf("[S3Adapter] Successfully uploaded file: %s\n", completeResponse.String())
 
-	for curr = 0; remaining != 0; curr += partLength {
-		if remaining < maxPartSize {
-			partLength = remaining
-		} else {
-			partLength = maxPartSize
+	return completeResponse.Location, nil
+}
+
+// uploadParts reads body part by part and uploads parts with a bounded
+// worker pool. At most UploadConcurrency parts are kept in memory per upload,
+// first failure cancels in-flight parts
+func (m *S3Adapter) uploadParts(
+	parent context.Context,
+	resp *s3.CreateMultipartUploadOutput,
+	body io.Reader,
+	contentLength int64,
+) ([]*s3.CompletedPart, error) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	// Small files don't need a whole part buffer, large ones get parts
+	// big enough to fit in MaxParts
+	bufSize := partSize(m.config.PartSize, contentLength)
+
+	var (
+		wg             sync.WaitGroup
+		mu             sync.Mutex
+		uploadErr      error
+		completedParts []*s3.CompletedPart
+	)
+
+	fail := func(err error) {
+		mu.Lock()
+		if uploadErr == nil {
+			uploadErr = err
+			cancel()
 		}
-		log.Debug(partLength)
-		// Upload binaries part
-		completedPart, err := m.uploadPart(resp, data.Bytes[curr:curr+partLength], partNumber)
+		mu.Unlock()
+	}
 
-		// If upload this part fail
-		// Make an abort upload error and exit
-		if err != nil {
-			log.Errorf("[S3Adapter] Failed %v", err.Error())
-			err := m.abortMultipartUpload(resp)
+	// Free buffers are reused, so memory is bounded by concurrency
+	free := make(chan []byte, m.config.UploadConcurrency)
+	sem := make(chan struct{}, m.config.UploadConcurrency)
+
+	for partNumber := 1; ; partNumber++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		if partNumber > MaxParts {
+			fail(errTooManyParts)
+			<-sem
+			break
+		}
+
+		var buf []byte
+		select {
+		case buf = <-free:
+		default:
+			buf = make([]byte, bufSize)
+		}
+
+		n, rerr := io.ReadFull(body, buf)
+		last := rerr == io.EOF || rerr == io.ErrUnexpectedEOF
+		if rerr != nil && !last {
+			fail(rerr)
+			<-sem
+			break
+		}
+
+		// Empty tail after a full part, nothing left to upload
+		if n == 0 && partNumber > 1 {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(partNumber int, buf []byte, n int) {
+			defer wg.Done()
+			defer func() {
+				free <- buf
+				<-sem
+			}()
+
+			// Upload binaries part
+			completedPart, err := m.uploadPart(ctx, resp, buf[:n], partNumber)
 			if err != nil {
-				log.Errorf("[S3Adapter] Failed %v", err.Error())
+				fail(err)
+				return
 			}
-			return nil, err
+
+			mu.Lock()
+			completedParts = append(completedParts, completedPart)
+			mu.Unlock()
+		}(partNumber, buf, n)
+
+		if last {
+			break
 		}
-		// else append completed part to a whole
-		remaining -= partLength
-		partNumber++
-		completedParts = append(completedParts, completedPart)
 	}
 
-	completeResponse, err := m.completeMultipartUpload(resp, completedParts)
+	wg.Wait()
+
+	// cancelled before reading whole body, parts are only its beginning.
+	// Parts fail with SDK errors then, the cause is reported instead
+	if err := parent.Err(); err != nil {
+		return nil, err
+	}
+
+	if uploadErr != nil {
+		return nil, uploadErr
+	}
+
+	// Parts finish in any order, but must be completed in ascending order
+	sort.Slice(completedParts, func(i, j int) bool {
+		return *completedParts[i].PartNumber < *completedParts[j].PartNumber
+	})
+
+	return completedParts, nil
+}
+
+// partSize is configured part size, grown for contentLength to fit in
+// MaxParts, or contentLength itself when it's smaller
+func partSize(size, contentLength int64) int64 {
+	if contentLength > 0 && contentLength < size {
+		return contentLength
+	}
+
+	if minSize := (contentLength + MaxParts - 1) / MaxParts; minSize > size {
+		return minSize
+	}
+
+	return size
+}
+
+func (m *S3Adapter) CreateMultipart(ctx context.Context, obj *S3Obj) (string, error) {
+	data := S3Obj(*obj)
+
+	if obj.Bucket == "" {
+		data.Bucket = m.config.Bucket
+	}
+
+	if obj.ContentType == "" {
+		return "", errors.New("[S3Adapter] Empty content-type not allowed")
+	}
+
+	resp, err := m.client.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      &data.Bucket,
+		Key:         &data.Key,
+		ContentType: &data.ContentType,
+		Metadata:    aws.StringMap(data.Metadata),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return *resp.UploadId, nil
+}
+
+func (m *S3Adapter) UploadPart(ctx context.Context, obj *S3Obj) error {
+	b, err := io.ReadAll(obj.Reader())
+	if err != nil {
+		return err
+	}
+
+	_, err = m.uploadPart(ctx, m.multipart(obj), b, int(obj.PartNumber))
+	if isNotFound(err) {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (m *S3Adapter) ListParts(ctx context.Context, obj *S3Obj) ([]S3Part, error) {
+	resp := m.multipart(obj)
+
+	var parts []S3Part
+	err := m.client.ListPartsPagesWithContext(ctx, &s3.ListPartsInput{
+		Bucket:   resp.Bucket,
+		Key:      resp.Key,
+		UploadId: resp.UploadId,
+	}, func(page *s3.ListPartsOutput, _ bool) bool {
+		for _, p := range page.Parts {
+			parts = append(parts, S3Part{
+				PartNumber: aws.Int64Value(p.PartNumber),
+				Size:       aws.Int64Value(p.Size),
+				ETag:       aws.StringValue(p.ETag),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return parts, nil
+}
+
+func (m *S3Adapter) CompleteMultipart(ctx context.Context, obj *S3Obj) (*string, error) {
+	parts, err := m.ListParts(ctx, obj)
 	if err != nil {
-		log.Errorf("[S3Adapter] Failed %v", err.Error())
 		return nil, err
 	}
 
-	log.Debug("[S3Adapter] Successfully uploaded file: %s\n", completeResponse.String())
+	completedParts := make([]*s3.CompletedPart, 0, len(parts))
+	for _, p := range parts {
+		completedParts = append(completedParts, &s3.CompletedPart{
+			ETag:       aws.String(p.ETag),
+			PartNumber: aws.Int64(p.PartNumber),
+		})
+	}
+
+	completeResponse, err := m.completeMultipartUpload(ctx, m.multipart(obj), completedParts)
+	if err != nil {
+		return nil, err
+	}
 
 	return completeResponse.Location, nil
 }
 
+func (m *S3Adapter) AbortMultipart(ctx context.Context, obj *S3Obj) error {
+	err := m.abortMultipartUpload(ctx, m.multipart(obj))
+	if isNotFound(err) {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (m *S3Adapter) ListMultipart(ctx context.Context, obj *S3Obj) ([]string, error) {
+	data := S3Obj(*obj)
+
+	if obj.Bucket == "" {
+		data.Bucket = m.config.Bucket
+	}
+
+	var uploadIDs []string
+	err := m.client.ListMultipartUploadsPagesWithContext(ctx, &s3.ListMultipartUploadsInput{
+		Bucket: &data.Bucket,
+		Prefix: &data.Key,
+	}, func(page *s3.ListMultipartUploadsOutput, _ bool) bool {
+		for _, u := range page.Uploads {
+			// prefix matches longer keys too
+			if aws.StringValue(u.Key) == data.Key {
+				uploadIDs = append(uploadIDs, aws.StringValue(u.UploadId))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return uploadIDs, nil
+}
+
+// multipart describes existing multipart upload of obj
+func (m *S3Adapter) multipart(obj *S3Obj) *s3.CreateMultipartUploadOutput {
+	data := S3Obj(*obj)
+
+	if obj.Bucket == "" {
+		data.Bucket = m.config.Bucket
+	}
+
+	return &s3.CreateMultipartUploadOutput{
+		Bucket:   &data.Bucket,
+		Key:      &data.Key,
+		UploadId: &data.UploadID,
+	}
+}
+
+func isNotFound(err error) bool {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, s3.ErrCodeNoSuchUpload, "NotFound":
+			return true
+		}
+	}
+
+	return false
+}
+
 func (m *S3Adapter) completeMultipartUpload(
+	ctx context.Context,
 	resp *s3.CreateMultipartUploadOutput,
 	completedParts []*s3.CompletedPart,
 ) (*s3.CompleteMultipartUploadOutput, error) {
@@ -195,21 +876,33 @@ func (m *S3Adapter) completeMultipartUpload(
 			Parts: completedParts,
 		},
 	}
-	return m.client.CompleteMultipartUpload(completeInput)
+	return m.client.CompleteMultipartUploadWithContext(ctx, completeInput)
+}
+
+// abortFailed aborts upload of a failed request. Request context may be
+// cancelled already, parts must be deleted anyway, so abort has its own
+func (m *S3Adapter) abortFailed(resp *s3.CreateMultipartUploadOutput) {
+	ctx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+
+	if err := m.abortMultipartUpload(ctx, resp); err != nil {
+		log.Errorf("[S3Adapter] Failed %v", err.Error())
+	}
 }
 
-func (m *S3Adapter) abortMultipartUpload(resp *s3.CreateMultipartUploadOutput) error {
+func (m *S3Adapter) abortMultipartUpload(ctx context.Context, resp *s3.CreateMultipartUploadOutput) error {
 	log.Debug("[S3Adapter] Aborting multipart upload for UploadId#" + *resp.UploadId)
 	abortInput := &s3.AbortMultipartUploadInput{
 		Bucket:   resp.Bucket,
 		Key:      resp.Key,
 		UploadId: resp.UploadId,
 	}
-	_, err := m.client.AbortMultipartUpload(abortInput)
+	_, err := m.client.AbortMultipartUploadWithContext(ctx, abortInput)
 	return err
 }
 
 func (m *S3Adapter) uploadPart(
+	ctx context.Context,
 	resp *s3.CreateMultipartUploadOutput,
 	fileBytes []byte,
 	partNumber int,
@@ -225,9 +918,9 @@ func (m *S3Adapter) uploadPart(
 	}
 
 	for tryNum <= 3 {
-		uploadResult, err := m.client.UploadPart(partInput)
+		uploadResult, err := m.client.UploadPartWithContext(ctx, partInput)
 		if err != nil {
-			if tryNum == 3 {
+			if tryNum == 3 || ctx.Err() != nil {
 				if aerr, ok := err.(awserr.Error); ok {
 					return nil, aerr
 				}
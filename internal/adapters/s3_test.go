@@ -0,0 +1,424 @@
+package adapters
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/WildEgor/gImageResizer/internal/configs"
+)
+
+// s3Request is a request received by s3Stub
+type s3Request struct {
+	Method string
+	Host   string
+	Path   string
+	Query  string
+	TLS    bool
+	// CopySource and CopyRange are x-amz-copy-source headers of CopyObject and UploadPartCopy
+	CopySource string
+	CopyRange  string
+	// Body of uploaded parts and completions, they are small in tests
+	Body string
+}
+
+// hugeSize is size of stub objects under huge prefix, over single copy limit
+const hugeSize = 6<<30 + 1
+
+// s3Stub answers S3 API requests of tests and records them
+type s3Stub struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []s3Request
+	// part answers UploadPart with status, 0 is success. Set it with SetPart
+	part func(r *http.Request, partNumber int) int
+}
+
+func (s *s3Stub) SetPart(part func(r *http.Request, partNumber int) int) {
+	s.mu.Lock()
+	s.part = part
+	s.mu.Unlock()
+}
+
+func newS3Stub(t *testing.T, tls bool) *s3Stub {
+	stub := &s3Stub{}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		stub.mu.Lock()
+		part := stub.part
+		stub.requests = append(stub.requests, s3Request{
+			Method:     r.Method,
+			Host:       r.Host,
+			Path:       r.URL.Path,
+			Query:      r.URL.RawQuery,
+			TLS:        r.TLS != nil,
+			CopySource: r.Header.Get("X-Amz-Copy-Source"),
+			CopyRange:  r.Header.Get("X-Amz-Copy-Source-Range"),
+			Body:       string(body),
+		})
+		stub.mu.Unlock()
+
+		if number, err := strconv.Atoi(r.URL.Query().Get("partNumber")); err == nil && r.Method == http.MethodPut && part != nil {
+			if status := part(r, number); status != 0 {
+				w.WriteHeader(status)
+				w.Write([]byte(`<Error><Code>InvalidPart</Code><Message>part failed</Message></Error>`))
+				return
+			}
+		}
+
+		w.Header().Set("ETag", `"etag"`)
+		switch {
+		case r.Method == http.MethodPost && r.URL.Query().Has("uploads"):
+			w.Write([]byte(`<InitiateMultipartUploadResult><Bucket>test</Bucket><Key>` +
+				strings.TrimPrefix(r.URL.Path, "/test/") + `</Key><UploadId>upload</UploadId></InitiateMultipartUploadResult>`))
+		case r.Method == http.MethodPost:
+			w.Write([]byte(`<CompleteMultipartUploadResult><Location>` + r.URL.Path + `</Location></CompleteMultipartUploadResult>`))
+		case r.Method == http.MethodHead && strings.HasPrefix(r.URL.Path, "/test/huge"):
+			w.Header().Set("Content-Length", strconv.FormatInt(hugeSize, 10))
+		case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "" && r.URL.Query().Has("partNumber"):
+			w.Write([]byte(`<CopyPartResult><ETag>"etag"</ETag></CopyPartResult>`))
+		case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+			w.Write([]byte(`<CopyObjectResult><ETag>"etag"</ETag></CopyObjectResult>`))
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	if tls {
+		stub.Server = httptest.NewTLSServer(handler)
+		// session trusts the stub certificate from CA bundle
+		bundle := filepath.Join(t.TempDir(), "ca.pem")
+		cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: stub.Certificate().Raw})
+		if err := os.WriteFile(bundle, cert, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("AWS_CA_BUNDLE", bundle)
+	} else {
+		stub.Server = httptest.NewServer(handler)
+	}
+	t.Cleanup(stub.Close)
+
+	return stub
+}
+
+func (s *s3Stub) Requests() []s3Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]s3Request(nil), s.requests...)
+}
+
+func newStubAdapter(stub *s3Stub, useSSL bool) *S3Adapter {
+	return NewS3Adapter(&configs.S3Config{
+		Region:            "us-east-1",
+		Bucket:            "test",
+		Endpoint:          strings.TrimPrefix(strings.TrimPrefix(stub.URL, "https://"), "http://"),
+		AccessKey:         "key",
+		SecretKey:         "secret",
+		UseSSL:            useSSL,
+		PartSize:          5 * 1024 * 1024,
+		UploadConcurrency: 2,
+	})
+}
+
+func TestEndpointURL(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		useSSL   bool
+		want     string
+	}{
+		{"minio:9000", false, "http://minio:9000"},
+		{"minio:9000", true, "https://minio:9000"},
+		{"https://minio:9000", false, "http://minio:9000"},
+		{"http://minio:9000", true, "https://minio:9000"},
+	}
+
+	for _, tt := range tests {
+		if got := endpointURL(tt.endpoint, tt.useSSL); got != tt.want {
+			t.Errorf("endpointURL(%q, %v) = %q, want %q", tt.endpoint, tt.useSSL, got, tt.want)
+		}
+	}
+}
+
+func TestS3AdapterEndpoint(t *testing.T) {
+	for _, useSSL := range []bool{false, true} {
+		stub := newS3Stub(t, useSSL)
+		adapter := newStubAdapter(stub, useSSL)
+
+		err := adapter.PutObj(context.Background(), &S3Obj{
+			Key:         "dir/a.txt",
+			Bytes:       []byte("hello"),
+			ContentType: "text/plain",
+		})
+		if err != nil {
+			t.Fatalf("useSSL=%v: PutObj: %v", useSSL, err)
+		}
+
+		requests := stub.Requests()
+		if len(requests) != 1 {
+			t.Fatalf("useSSL=%v: got %d requests, want 1", useSSL, len(requests))
+		}
+
+		// path-style: bucket is the first path segment, not a subdomain
+		req := requests[0]
+		if req.Method != http.MethodPut || req.Path != "/test/dir/a.txt" {
+			t.Errorf("useSSL=%v: got %s %s, want PUT /test/dir/a.txt", useSSL, req.Method, req.Path)
+		}
+		if host := stub.Listener.Addr().String(); req.Host != host {
+			t.Errorf("useSSL=%v: got host %q, want %q", useSSL, req.Host, host)
+		}
+		if req.TLS != useSSL {
+			t.Errorf("useSSL=%v: request TLS = %v", useSSL, req.TLS)
+		}
+	}
+}
+
+func TestSessionUploadCancelled(t *testing.T) {
+	stub := newS3Stub(t, false)
+	adapter := newStubAdapter(stub, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := adapter.SessionUpload(ctx, &S3Obj{
+		Key:         "a.bin",
+		Body:        strings.NewReader("hello"),
+		ContentType: "application/octet-stream",
+	})
+	if err == nil {
+		t.Fatal("cancelled upload succeeded")
+	}
+
+	// nothing is created for cancelled request
+	if requests := stub.Requests(); len(requests) != 0 {
+		t.Errorf("got requests %+v", requests)
+	}
+}
+
+func TestSessionUploadCancelledMidway(t *testing.T) {
+	stub := newS3Stub(t, false)
+	adapter := newStubAdapter(stub, false)
+	adapter.config.PartSize = 4
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the request is cancelled while the first part is uploaded
+	stub.SetPart(func(r *http.Request, partNumber int) int {
+		cancel()
+		<-r.Context().Done()
+		return 0
+	})
+
+	_, err := adapter.SessionUpload(ctx, &S3Obj{
+		Key:         "a.bin",
+		Body:        strings.NewReader("hello world"),
+		ContentType: "application/octet-stream",
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+
+	// truncated object must not be completed, abort doesn't use cancelled context
+	requests := stub.Requests()
+	last := requests[len(requests)-1]
+	for _, req := range requests[1 : len(requests)-1] {
+		if req.Method == http.MethodPost {
+			t.Errorf("upload was completed: %+v", req)
+		}
+	}
+	if last.Method != http.MethodDelete || !strings.Contains(last.Query, "uploadId=upload") {
+		t.Errorf("upload wasn't aborted, last request %+v", last)
+	}
+}
+
+var partNumberRe = regexp.MustCompile(`<PartNumber>(\d+)</PartNumber>`)
+
+func TestSessionUploadCompletesPartsInOrder(t *testing.T) {
+	stub := newS3Stub(t, false)
+	adapter := newStubAdapter(stub, false)
+	adapter.config.PartSize = 4
+	adapter.config.UploadConcurrency = 4
+
+	// earlier parts finish later
+	stub.SetPart(func(r *http.Request, partNumber int) int {
+		time.Sleep(time.Duration(5-partNumber) * 20 * time.Millisecond)
+		return 0
+	})
+
+	_, err := adapter.SessionUpload(context.Background(), &S3Obj{
+		Key:         "a.bin",
+		Body:        strings.NewReader("aaaabbbbccccd"),
+		ContentType: "application/octet-stream",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := make(map[string]string)
+	var completion []string
+	for _, req := range stub.Requests() {
+		query, _ := url.ParseQuery(req.Query)
+		switch {
+		case req.Method == http.MethodPut && query.Has("partNumber"):
+			parts[query.Get("partNumber")] = req.Body
+		case req.Method == http.MethodPost && query.Has("uploadId"):
+			for _, m := range partNumberRe.FindAllStringSubmatch(req.Body, -1) {
+				completion = append(completion, m[1])
+			}
+		}
+	}
+
+	want := map[string]string{"1": "aaaa", "2": "bbbb", "3": "cccc", "4": "d"}
+	if !reflect.DeepEqual(parts, want) {
+		t.Errorf("got parts %v, want %v", parts, want)
+	}
+	if !reflect.DeepEqual(completion, []string{"1", "2", "3", "4"}) {
+		t.Errorf("got parts completed in order %v", completion)
+	}
+}
+
+func TestSessionUploadPartFailure(t *testing.T) {
+	stub := newS3Stub(t, false)
+	adapter := newStubAdapter(stub, false)
+	adapter.config.PartSize = 4
+	adapter.config.UploadConcurrency = 4
+
+	// the second part fails, others wait to be cancelled
+	var mu sync.Mutex
+	waited := 0
+	stub.SetPart(func(r *http.Request, partNumber int) int {
+		if partNumber == 2 {
+			return http.StatusBadRequest
+		}
+
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+			mu.Lock()
+			waited++
+			mu.Unlock()
+		}
+		return 0
+	})
+
+	_, err := adapter.SessionUpload(context.Background(), &S3Obj{
+		Key:         "a.bin",
+		Body:        strings.NewReader("aaaabbbbccccdddd"),
+		ContentType: "application/octet-stream",
+	})
+	if err == nil {
+		t.Fatal("failed upload succeeded")
+	}
+
+	mu.Lock()
+	if waited != 0 {
+		t.Errorf("%d parts weren't cancelled", waited)
+	}
+	mu.Unlock()
+
+	requests := stub.Requests()
+	for _, req := range requests {
+		if req.Method == http.MethodPost && strings.Contains(req.Query, "uploadId") {
+			t.Errorf("upload was completed: %+v", req)
+		}
+	}
+	if last := requests[len(requests)-1]; last.Method != http.MethodDelete || !strings.Contains(last.Query, "uploadId=upload") {
+		t.Errorf("upload wasn't aborted, last request %+v", last)
+	}
+}
+
+func TestPartSize(t *testing.T) {
+	const mb = 1024 * 1024
+
+	tests := []struct {
+		size, contentLength, want int64
+	}{
+		{5 * mb, 0, 5 * mb},
+		{5 * mb, 1 * mb, 1 * mb},
+		{5 * mb, 100 * mb, 5 * mb},
+		{5 * mb, 50000 * mb, 5 * mb},
+		{5 * mb, 100000 * mb, 10 * mb},
+		{5 * mb, 100000*mb + 1, 10*mb + 1},
+	}
+
+	for _, tt := range tests {
+		got := partSize(tt.size, tt.contentLength)
+		if got != tt.want {
+			t.Errorf("partSize(%d, %d) = %d, want %d", tt.size, tt.contentLength, got, tt.want)
+		}
+		if tt.contentLength > 0 && (tt.contentLength+got-1)/got > MaxParts {
+			t.Errorf("partSize(%d, %d) needs more than %d parts", tt.size, tt.contentLength, MaxParts)
+		}
+	}
+}
+
+func TestMoveObj(t *testing.T) {
+	stub := newS3Stub(t, false)
+	adapter := newStubAdapter(stub, false)
+
+	err := adapter.MoveObj(context.Background(), &S3Obj{Key: ".cas/a b"}, "sha256/ab/cd/abcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := stub.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("got %d requests, want stat, copy and delete", len(requests))
+	}
+	if copy := requests[1]; copy.Method != http.MethodPut || copy.Path != "/test/sha256/ab/cd/abcd" || copy.CopySource != "test/.cas/a%20b" {
+		t.Errorf("got copy %+v", copy)
+	}
+	if del := requests[2]; del.Method != http.MethodDelete || del.Path != "/test/.cas/a b" {
+		t.Errorf("got delete %+v", del)
+	}
+}
+
+func TestMoveObjCopiesLargeObjectByParts(t *testing.T) {
+	stub := newS3Stub(t, false)
+	adapter := newStubAdapter(stub, false)
+
+	err := adapter.MoveObj(context.Background(), &S3Obj{Key: "huge.bin"}, "sha256/ab/cd/abcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ranges []string
+	completed, deleted := false, false
+	for _, req := range stub.Requests() {
+		switch {
+		case req.Method == http.MethodPut && req.CopySource != "":
+			if req.CopyRange == "" || req.Path != "/test/sha256/ab/cd/abcd" {
+				t.Errorf("got single copy %+v", req)
+			}
+			ranges = append(ranges, req.CopyRange)
+		case req.Method == http.MethodPost && strings.Contains(req.Query, "uploadId=upload"):
+			completed = true
+		case req.Method == http.MethodDelete:
+			deleted = completed && req.Path == "/test/huge.bin"
+		}
+	}
+
+	// 512MB parts, the last one is the rest
+	if len(ranges) != 13 || ranges[0] != "bytes=0-536870911" || ranges[12] != "bytes=6442450944-6442450944" {
+		t.Errorf("got %d ranges %v", len(ranges), ranges)
+	}
+	if !completed || !deleted {
+		t.Errorf("upload completed %v, source deleted after it %v", completed, deleted)
+	}
+}
@@ -0,0 +1,24 @@
+package adapters
+
+import (
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	log "github.com/sirupsen/logrus"
+)
+
+// NewStorageAdapter picks IS3Adapter implementation by STORAGE_DRIVER
+func NewStorageAdapter(
+	storageConfig *configs.StorageConfig,
+	s3Config *configs.S3Config,
+) IS3Adapter {
+	switch storageConfig.Driver {
+	case "s3":
+		return NewS3Adapter(s3Config)
+	case "local":
+		return NewLocalAdapter(storageConfig, s3Config)
+	case "memory":
+		return NewMemoryAdapter(s3Config)
+	}
+
+	log.Fatalf("[Storage] Unknown driver %v", storageConfig.Driver)
+	return nil
+}
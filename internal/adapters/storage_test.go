@@ -0,0 +1,312 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/WildEgor/gImageResizer/internal/configs"
+)
+
+const textType = "text/plain; charset=utf-8"
+
+func newTestLocalAdapter(t *testing.T) *LocalAdapter {
+	t.Helper()
+
+	return NewLocalAdapter(&configs.StorageConfig{LocalRoot: t.TempDir()}, &configs.S3Config{Bucket: "test"})
+}
+
+// testAdapters are drivers running without S3
+func testAdapters(t *testing.T) map[string]IS3Adapter {
+	return map[string]IS3Adapter{
+		"memory": NewMemoryAdapter(&configs.S3Config{Bucket: "test"}),
+		"local":  newTestLocalAdapter(t),
+	}
+}
+
+func readObj(t *testing.T, name string, storage IS3Adapter, key string) string {
+	t.Helper()
+
+	body, err := storage.GetObj(context.Background(), &S3Obj{Key: key})
+	if err != nil {
+		t.Fatalf("%s: %s: %v", name, key, err)
+	}
+	defer body.Close()
+
+	b, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("%s: %s: %v", name, key, err)
+	}
+
+	return string(b)
+}
+
+func listKeys(t *testing.T, name string, storage IS3Adapter, query *S3ListQuery) ([]string, []string) {
+	t.Helper()
+
+	if query.Limit == 0 {
+		query.Limit = 100
+	}
+	list, err := storage.List(context.Background(), query)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+
+	var keys []string
+	for _, obj := range list.Objects {
+		keys = append(keys, obj.Key)
+	}
+
+	return keys, list.Prefixes
+}
+
+func TestAdapterObjects(t *testing.T) {
+	ctx := context.Background()
+
+	for name, storage := range testAdapters(t) {
+		if err := storage.PutObj(ctx, &S3Obj{Key: "a/b/c.txt", Bytes: []byte("hello")}); err == nil {
+			t.Errorf("%s: put without content type succeeded", name)
+		}
+
+		for _, body := range []string{"hello world", "hello again"} {
+			if err := storage.PutObj(ctx, &S3Obj{Key: "a/b/c.txt", ContentType: textType, Body: strings.NewReader(body)}); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		if _, err := storage.SessionUpload(ctx, &S3Obj{Key: "a/d.txt", ContentType: textType, Bytes: []byte("d")}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		// the last put wins
+		if got := readObj(t, name, storage, "a/b/c.txt"); got != "hello again" {
+			t.Errorf("%s: got %q", name, got)
+		}
+
+		stat, err := storage.Stat(ctx, &S3Obj{Key: "a/b/c.txt"})
+		if err != nil || stat.ContentLength != 11 || stat.ContentType != textType {
+			t.Errorf("%s: got stat %+v, %v", name, stat, err)
+		}
+
+		body, err := storage.GetRange(ctx, &S3Obj{Key: "a/b/c.txt"}, 6, 5)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if b, _ := io.ReadAll(body); string(b) != "again" {
+			t.Errorf("%s: got range %q", name, b)
+		}
+		body.Close()
+
+		keys, prefixes := listKeys(t, name, storage, &S3ListQuery{Prefix: "a/", Delimiter: "/"})
+		if !reflect.DeepEqual(keys, []string{"a/d.txt"}) || !reflect.DeepEqual(prefixes, []string{"a/b/"}) {
+			t.Errorf("%s: got %v and prefixes %v", name, keys, prefixes)
+		}
+
+		if err := storage.MoveObj(ctx, &S3Obj{Key: "a/b/c.txt"}, "e/c.txt"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := readObj(t, name, storage, "e/c.txt"); got != "hello again" {
+			t.Errorf("%s: got moved %q", name, got)
+		}
+
+		for _, key := range []string{"a/b/c.txt", "missing"} {
+			if _, err := storage.Stat(ctx, &S3Obj{Key: key}); !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: stat of %s: got %v, want %v", name, key, err, ErrNotFound)
+			}
+			if _, err := storage.GetObj(ctx, &S3Obj{Key: key}); !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: get of %s: got %v, want %v", name, key, err, ErrNotFound)
+			}
+			if err := storage.MoveObj(ctx, &S3Obj{Key: key}, "f.txt"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: move of %s: got %v, want %v", name, key, err, ErrNotFound)
+			}
+		}
+
+		// missing objects are ignored
+		err = storage.DeleteObjs(ctx, []*S3Obj{{Key: "a/d.txt"}, {Key: "e/c.txt"}, {Key: "missing"}})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if keys, prefixes := listKeys(t, name, storage, &S3ListQuery{}); len(keys) != 0 || len(prefixes) != 0 {
+			t.Errorf("%s: got %v left", name, keys)
+		}
+	}
+}
+
+func TestAdapterMultipart(t *testing.T) {
+	ctx := context.Background()
+
+	for name, storage := range testAdapters(t) {
+		if _, err := storage.CreateMultipart(ctx, &S3Obj{Key: "m.txt"}); err == nil {
+			t.Errorf("%s: multipart without content type is created", name)
+		}
+
+		uploadID, err := storage.CreateMultipart(ctx, &S3Obj{Key: "m.txt", ContentType: textType})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// parts are joined by number, not by upload order
+		for _, part := range []S3Obj{
+			{PartNumber: 2, Bytes: []byte("world")},
+			{PartNumber: 1, Bytes: []byte("hello ")},
+			{PartNumber: 2, Bytes: []byte("there")},
+		} {
+			part.UploadID = uploadID
+			if err := storage.UploadPart(ctx, &part); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+
+		parts, err := storage.ListParts(ctx, &S3Obj{UploadID: uploadID})
+		if err != nil || len(parts) != 2 || parts[0].PartNumber != 1 || parts[0].Size != 6 || parts[1].PartNumber != 2 || parts[1].Size != 5 {
+			t.Errorf("%s: got parts %+v, %v", name, parts, err)
+		}
+
+		ids, err := storage.ListMultipart(ctx, &S3Obj{Key: "m.txt"})
+		if err != nil || !reflect.DeepEqual(ids, []string{uploadID}) {
+			t.Errorf("%s: got uploads %v, %v", name, ids, err)
+		}
+
+		if _, err := storage.CompleteMultipart(ctx, &S3Obj{UploadID: uploadID}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := readObj(t, name, storage, "m.txt"); got != "hello there" {
+			t.Errorf("%s: got %q", name, got)
+		}
+
+		if ids, _ := storage.ListMultipart(ctx, &S3Obj{Key: "m.txt"}); len(ids) != 0 {
+			t.Errorf("%s: got uploads %v after completion", name, ids)
+		}
+
+		aborted, err := storage.CreateMultipart(ctx, &S3Obj{Key: "m.txt", ContentType: textType})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.UploadPart(ctx, &S3Obj{UploadID: aborted, PartNumber: 1, Bytes: []byte("gone")}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := storage.AbortMultipart(ctx, &S3Obj{UploadID: aborted}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		for _, id := range []string{uploadID, aborted, "missing"} {
+			if _, err := storage.ListParts(ctx, &S3Obj{UploadID: id}); !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: parts of %s: got %v, want %v", name, id, err, ErrNotFound)
+			}
+			if err := storage.AbortMultipart(ctx, &S3Obj{UploadID: id}); !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: abort of %s: got %v, want %v", name, id, err, ErrNotFound)
+			}
+		}
+
+		// the completed object stays
+		if got := readObj(t, name, storage, "m.txt"); got != "hello there" {
+			t.Errorf("%s: got %q after abort", name, got)
+		}
+	}
+}
+
+// failingReader returns data, then err
+type failingReader struct {
+	data string
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, r.err
+	}
+
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+
+	return n, nil
+}
+
+func TestLocalAdapterFailedWrite(t *testing.T) {
+	ctx := context.Background()
+	storage := newTestLocalAdapter(t)
+
+	if err := storage.PutObj(ctx, &S3Obj{Key: "a/b.txt", ContentType: textType, Bytes: []byte("hello world")}); err != nil {
+		t.Fatal(err)
+	}
+
+	body := &failingReader{data: "half of", err: errors.New("connection reset")}
+	if err := storage.PutObj(ctx, &S3Obj{Key: "a/b.txt", ContentType: textType, Body: body}); err == nil {
+		t.Fatal("failed write succeeded")
+	}
+
+	// the previous file is kept whole, temp file is removed
+	if got := readObj(t, "local", storage, "a/b.txt"); got != "hello world" {
+		t.Errorf("got %q", got)
+	}
+	if entries, err := os.ReadDir(filepath.Join(storage.root, ".tmp")); err != nil || len(entries) != 0 {
+		t.Errorf("got temp files %v, %v", entries, err)
+	}
+
+	body = &failingReader{data: "half of", err: errors.New("connection reset")}
+	if err := storage.PutObj(ctx, &S3Obj{Key: "c.txt", ContentType: textType, Body: body}); err == nil {
+		t.Fatal("failed write succeeded")
+	}
+	if keys, _ := listKeys(t, "local", storage, &S3ListQuery{}); !reflect.DeepEqual(keys, []string{"a/b.txt"}) {
+		t.Errorf("got %v, want only a/b.txt", keys)
+	}
+
+	info, err := os.Stat(storage.path(&S3Obj{Key: "a/b.txt"}))
+	if err != nil || info.Mode().Perm() != 0o644 {
+		t.Errorf("got %v, %v, want readable file", info, err)
+	}
+}
+
+func TestLocalAdapterRemovesEmptyDirs(t *testing.T) {
+	ctx := context.Background()
+	storage := newTestLocalAdapter(t)
+
+	for _, key := range []string{"a/b/c/d.txt", "a/b/e.txt", "f/g.txt"} {
+		if err := storage.PutObj(ctx, &S3Obj{Key: key, ContentType: textType, Bytes: []byte(key)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dirs := func() []string {
+		var dirs []string
+		bucket := filepath.Join(storage.root, "test")
+		filepath.WalkDir(bucket, func(p string, d os.DirEntry, err error) error {
+			if err == nil && d.IsDir() && p != bucket {
+				rel, _ := filepath.Rel(bucket, p)
+				dirs = append(dirs, filepath.ToSlash(rel))
+			}
+			return err
+		})
+		return dirs
+	}
+
+	tests := []struct {
+		name string
+		do   func() error
+		want []string
+	}{
+		{"delete", func() error { return storage.DeleteObj(ctx, &S3Obj{Key: "a/b/c/d.txt"}) }, []string{"a", "a/b", "f"}},
+		{"move", func() error { return storage.MoveObj(ctx, &S3Obj{Key: "f/g.txt"}, "a/g.txt") }, []string{"a", "a/b"}},
+		{"delete many", func() error {
+			return storage.DeleteObjs(ctx, []*S3Obj{{Key: "a/b/e.txt"}, {Key: "a/g.txt"}})
+		}, nil},
+	}
+
+	for _, tt := range tests {
+		if err := tt.do(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := dirs(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got directories %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// the bucket itself stays
+	if _, err := os.Stat(filepath.Join(storage.root, "test")); err != nil {
+		t.Errorf("bucket is removed: %v", err)
+	}
+}
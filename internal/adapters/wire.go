@@ -5,6 +5,5 @@ import (
 )
 
 var AdaptersSet = wire.NewSet(
-	NewS3Adapter,
-	wire.Bind(new(IS3Adapter), new(*S3Adapter)),
+	NewStorageAdapter,
 )
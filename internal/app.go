@@ -4,8 +4,13 @@ import (
 	"fmt"
 
 	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/cas"
 	"github.com/WildEgor/gImageResizer/internal/configs"
 	handlers_http "github.com/WildEgor/gImageResizer/internal/handlers/http"
+	"github.com/WildEgor/gImageResizer/internal/imgproxy"
+	"github.com/WildEgor/gImageResizer/internal/jobs"
+	"github.com/WildEgor/gImageResizer/internal/policy"
+	"github.com/WildEgor/gImageResizer/internal/resizer"
 	"github.com/WildEgor/gImageResizer/internal/routers"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -18,25 +23,43 @@ var AppSet = wire.NewSet(
 	NewApp,
 	adapters.AdaptersSet,
 	configs.ConfigsSet,
+	imgproxy.ImgProxySet,
+	resizer.ResizerSet,
+	jobs.JobsSet,
+	policy.PolicySet,
+	cas.CasSet,
 	routers.RoutersSet,
 )
 
 func NewApp(
 	appConfig *configs.AppConfig,
 	httpRouter *routers.HTTPRouter,
+	queue *jobs.Queue,
+	purger *handlers_http.Purger,
 ) *fiber.App {
 	app := fiber.New(fiber.Config{
 		EnablePrintRoutes: true,
-		ErrorHandler:      handlers_http.ErrorHandler,
+		// bodies over BodyLimit are streamed to handlers, uploads measure them
+		// to report sizes of files and LimitBody rejects them on other routes
+		BodyLimit:                    appConfig.BodyLimit(),
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
+		ErrorHandler:                 handlers_http.ErrorHandler,
 	})
 
 	app.Use(cors.New(cors.Config{
-		AllowHeaders:     "Origin, Content-Type, Accept, Content-Length, Accept-Language, Accept-Encoding, Connection, Access-Control-Allow-Origin",
+		// tus clients send OPTIONS without preflight headers to discover the server
+		Next: func(c *fiber.Ctx) bool {
+			return c.Method() == fiber.MethodOptions && c.Get(fiber.HeaderAccessControlRequestMethod) == ""
+		},
+		AllowHeaders:     "Origin, Content-Type, Accept, Content-Length, Accept-Language, Accept-Encoding, Connection, Access-Control-Allow-Origin, Tus-Resumable, Upload-Length, Upload-Metadata, Upload-Offset, If-None-Match, If-Modified-Since",
+		ExposeHeaders:    "ETag, Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Length, Upload-Metadata, Upload-Offset",
 		AllowOrigins:     "*",
 		AllowCredentials: true,
 		AllowMethods:     "GET,POST,HEAD,PUT,DELETE,PATCH,OPTIONS",
 	}))
 	app.Use(recover.New())
+	app.Use(handlers_http.LimitBody(appConfig.BodyLimit()))
 
 	if !appConfig.IsProduction() {
 		httpRouter.SwaggerRoute(app, "localhost:8888/docs")
@@ -48,6 +71,10 @@ func NewApp(
 
 	httpRouter.SetupRoutes(app)
 
+	// running jobs are cancelled and resume after restart
+	app.Hooks().OnShutdown(queue.Close)
+	app.Hooks().OnShutdown(purger.Close)
+
 	log.Info(fmt.Sprintf("Application is running on %v port...", appConfig.Port))
 	log.Info(fmt.Sprintf("Swagger served at %v", "http://localhost:8888/swagger"))
 
@@ -0,0 +1,191 @@
+package cas
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/WildEgor/gImageResizer/internal/locks"
+	"github.com/google/uuid"
+)
+
+// Prefix of content addressed keys, sha256/ab/cd/abcd...
+const Prefix = "sha256/"
+
+// AliasPrefix of alias records pointing upload keys to content addresses,
+// internal keys start with "."
+const AliasPrefix = ".alias/"
+
+// RefsPrefix of reference markers, one empty object per alias of the file
+const RefsPrefix = ".refs/"
+
+// TempPrefix of files being uploaded before their hash is known
+const TempPrefix = ".cas/"
+
+// Alias is record of upload key pointing to the file it shares
+type Alias struct {
+	Key string `json:"key"`
+}
+
+// Store links upload keys (aliases) to content addressed files, so a file
+// uploaded by many users is stored once and removed with the last alias.
+// Every alias has own reference marker, so deleting one alias twice releases
+// nothing and concurrent instances never lose a reference. Locks are held by
+// this instance only: an instance deleting the last alias while another one
+// uploads the same content may delete the file the new alias points to
+type Store struct {
+	config    *configs.UploadConfig
+	s3Adapter adapters.IS3Adapter
+	locks     locks.Keyed
+}
+
+func NewStore(
+	config *configs.UploadConfig,
+	s3Adapter adapters.IS3Adapter,
+) *Store {
+	return &Store{
+		config:    config,
+		s3Adapter: s3Adapter,
+	}
+}
+
+// Key is content address of file with SHA-256 sum, first bytes of the hash
+// are directories to keep listings of a prefix short
+func Key(sum []byte) string {
+	h := hex.EncodeToString(sum)
+	return Prefix + h[:2] + "/" + h[2:4] + "/" + h
+}
+
+// TempKey is unique key to upload file to while it's hashed
+func TempKey() string {
+	return TempPrefix + uuid.New().String()
+}
+
+// IsKey tells if key is content address
+func IsKey(key string) bool {
+	return strings.HasPrefix(key, Prefix)
+}
+
+// AliasKey is storage key of alias record
+func AliasKey(alias string) string {
+	return AliasPrefix + alias + ".json"
+}
+
+// RefKey is storage key of reference marker of alias to the file
+func RefKey(key, alias string) string {
+	return refsPrefix(key) + alias
+}
+
+func refsPrefix(key string) string {
+	return RefsPrefix + key + "/"
+}
+
+// Lock serializes uploads and deletes of key, Link and Unlink
+// must be called under it. Returns unlock
+func (s *Store) Lock(key string) func() {
+	return s.locks.Lock(key)
+}
+
+// Exists tells if file of key is stored
+func (s *Store) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.s3Adapter.Stat(ctx, &adapters.S3Obj{Key: key})
+	if errors.Is(err, adapters.ErrNotFound) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Link adds alias of stored file, reference marker goes first,
+// so the file isn't deleted while alias is being written
+func (s *Store) Link(ctx context.Context, alias, key string) error {
+	err := s.s3Adapter.PutObj(ctx, &adapters.S3Obj{
+		Key:         RefKey(key, alias),
+		Bytes:       []byte{},
+		ContentType: "application/octet-stream",
+	})
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(Alias{Key: key})
+	if err != nil {
+		return err
+	}
+
+	return s.s3Adapter.PutObj(ctx, &adapters.S3Obj{
+		Key:           AliasKey(alias),
+		Bytes:         b,
+		ContentType:   "application/json",
+		ContentLength: int64(len(b)),
+	})
+}
+
+// Unlink removes aliases, mapped to their files, with their references.
+// Missing aliases are ignored, so an alias removed twice releases nothing
+func (s *Store) Unlink(ctx context.Context, aliases map[string]string) error {
+	objs := make([]*adapters.S3Obj, 0, 2*len(aliases))
+	for alias, key := range aliases {
+		objs = append(objs, &adapters.S3Obj{Key: AliasKey(alias)}, &adapters.S3Obj{Key: RefKey(key, alias)})
+	}
+
+	return s.s3Adapter.DeleteObjs(ctx, objs)
+}
+
+// Target returns content address alias points to, ErrNotFound if key isn't alias
+func (s *Store) Target(ctx context.Context, alias string) (string, error) {
+	if !s.config.ContentAddressed {
+		return "", adapters.ErrNotFound
+	}
+
+	body, err := s.s3Adapter.GetObj(ctx, &adapters.S3Obj{Key: AliasKey(alias)})
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	var record Alias
+	if err := json.NewDecoder(body).Decode(&record); err != nil {
+		return "", err
+	}
+
+	return record.Key, nil
+}
+
+// Resolve returns key of the stored file, content address for aliases
+// and the key itself for others
+func (s *Store) Resolve(ctx context.Context, key string) (string, error) {
+	target, err := s.Target(ctx, key)
+	if errors.Is(err, adapters.ErrNotFound) {
+		return key, nil
+	}
+
+	return target, err
+}
+
+// Aliases returns aliases referring to the file
+func (s *Store) Aliases(ctx context.Context, key string) ([]string, error) {
+	prefix := refsPrefix(key)
+
+	var aliases []string
+	query := &adapters.S3ListQuery{Prefix: prefix, Limit: 1000}
+	for {
+		list, err := s.s3Adapter.List(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range list.Objects {
+			aliases = append(aliases, strings.TrimPrefix(obj.Key, prefix))
+		}
+
+		if list.Cursor == "" {
+			return aliases, nil
+		}
+		query.Cursor = list.Cursor
+	}
+}
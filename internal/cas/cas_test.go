@@ -0,0 +1,76 @@
+package cas
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/configs"
+)
+
+func newTestStore() *Store {
+	return NewStore(&configs.UploadConfig{ContentAddressed: true}, adapters.NewMemoryAdapter(&configs.S3Config{Bucket: "test"}))
+}
+
+func TestStoreLocksAreRemoved(t *testing.T) {
+	s := newTestStore()
+
+	for i := 0; i < 100; i++ {
+		unlock := s.Lock(Key([]byte{byte(i), 0, 0}))
+		unlock()
+	}
+
+	if n := s.locks.Len(); n != 0 {
+		t.Errorf("%d locks left after unlock", n)
+	}
+}
+
+func TestStoreAliases(t *testing.T) {
+	s := newTestStore()
+	ctx := context.Background()
+	key := Key([]byte{1, 2, 3})
+
+	for _, alias := range []string{"a", "b"} {
+		if err := s.Link(ctx, alias, key); err != nil {
+			t.Fatal(err)
+		}
+		if target, err := s.Resolve(ctx, alias); err != nil || target != key {
+			t.Errorf("%s resolves to %q %v", alias, target, err)
+		}
+	}
+	if target, err := s.Resolve(ctx, "plain"); err != nil || target != "plain" {
+		t.Errorf("plain key resolves to %q %v", target, err)
+	}
+
+	// the same alias removed twice leaves the other one
+	for i := 0; i < 2; i++ {
+		if err := s.Unlink(ctx, map[string]string{"a": key}); err != nil {
+			t.Fatal(err)
+		}
+		refs, err := s.Aliases(ctx, key)
+		sort.Strings(refs)
+		if err != nil || !reflect.DeepEqual(refs, []string{"b"}) {
+			t.Fatalf("got aliases %v %v, want [b]", refs, err)
+		}
+	}
+
+	if _, err := s.Target(ctx, "a"); !errors.Is(err, adapters.ErrNotFound) {
+		t.Errorf("removed alias got %v", err)
+	}
+}
+
+func TestStoreIgnoresAliasesWhenDisabled(t *testing.T) {
+	storage := adapters.NewMemoryAdapter(&configs.S3Config{Bucket: "test"})
+	ctx := context.Background()
+
+	if err := NewStore(&configs.UploadConfig{ContentAddressed: true}, storage).Link(ctx, "a", Key([]byte{1, 2, 3})); err != nil {
+		t.Fatal(err)
+	}
+
+	if target, err := NewStore(&configs.UploadConfig{}, storage).Resolve(ctx, "a"); err != nil || target != "a" {
+		t.Errorf("got %q %v, want the key itself", target, err)
+	}
+}
@@ -0,0 +1,9 @@
+package cas
+
+import (
+	"github.com/google/wire"
+)
+
+var CasSet = wire.NewSet(
+	NewStore,
+)
@@ -12,8 +12,16 @@ type AppConfig struct {
 	Mode    string `env:"APP_MODE"`
 	GoEnv   string `env:"GO_ENV"`
 	Version string `env:"VERSION"`
+	// MaxFileSize and MaxRequestSize in bytes and MaxFiles limit POST /api/v1/upload,
+	// MaxFileSize is the only file size limit, images of any upload are checked against it too
+	MaxFileSize    int64 `env:"APP_MAX_FILE_SIZE"`
+	MaxRequestSize int64 `env:"APP_MAX_REQUEST_SIZE"`
+	MaxFiles       int   `env:"APP_MAX_FILES"`
 }
 
+// multipart boundaries, headers and form fields on top of files
+const bodyOverhead = 1024 * 1024
+
 func NewAppConfig() *AppConfig {
 	cfg := AppConfig{}
 
@@ -31,9 +39,27 @@ func NewAppConfig() *AppConfig {
 		}
 	}
 
+	if cfg.MaxFileSize <= 0 {
+		cfg.MaxFileSize = 50 * 1024 * 1024
+	}
+
+	if cfg.MaxRequestSize <= 0 {
+		cfg.MaxRequestSize = 100 * 1024 * 1024
+	}
+
+	if cfg.MaxFiles <= 0 {
+		cfg.MaxFiles = 20
+	}
+
 	return &cfg
 }
 
+// BodyLimit of request bodies read into memory, uploads over it
+// are rejected with sizes of their files
+func (ac *AppConfig) BodyLimit() int {
+	return int(ac.MaxRequestSize + bodyOverhead)
+}
+
 func (ac *AppConfig) IsProduction() bool {
 	if ac.Mode == "develop" {
 		return false
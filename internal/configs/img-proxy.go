@@ -8,6 +8,15 @@ import (
 
 type ImgProxyConfig struct {
 	BaseURL string `env:"IMG_PROXY_BASE_URL"`
+	// Key and Salt sign imgproxy URLs, hex encoded like IMGPROXY_KEY and IMGPROXY_SALT
+	Key  string `env:"IMG_PROXY_KEY"`
+	Salt string `env:"IMG_PROXY_SALT"`
+	// EncodeSource makes base64 encoded source URLs instead of plain ones
+	EncodeSource bool `env:"IMG_PROXY_ENCODE_SOURCE"`
+	// Presets is json object of named processing options, PresetsFile is path to such json
+	Presets       string `env:"IMG_PROXY_PRESETS"`
+	PresetsFile   string `env:"IMG_PROXY_PRESETS_FILE"`
+	DefaultPreset string `env:"IMG_PROXY_DEFAULT_PRESET"`
 }
 
 func NewImgProxyConfig() *ImgProxyConfig {
@@ -19,5 +28,9 @@ func NewImgProxyConfig() *ImgProxyConfig {
 		}
 	}
 
+	if cfg.DefaultPreset == "" {
+		cfg.DefaultPreset = "medium"
+	}
+
 	return &cfg
 }
@@ -0,0 +1,69 @@
+package configs
+
+import (
+	"time"
+
+	"github.com/caarlos0/env/v7"
+	"github.com/joho/godotenv"
+	log "github.com/sirupsen/logrus"
+)
+
+type JobsConfig struct {
+	// Store is file or memory, file store keeps jobs in Dir so they survive restarts
+	Store string `env:"JOBS_STORE"`
+	Dir   string `env:"JOBS_DIR"`
+	// Workers is how many jobs run in parallel
+	Workers int `env:"JOBS_WORKERS"`
+	// MaxAttempts after which failed job goes to dead letters
+	MaxAttempts int `env:"JOBS_MAX_ATTEMPTS"`
+	// Backoff is delay before first retry, it doubles on every attempt up to MaxBackoff
+	Backoff    time.Duration `env:"JOBS_BACKOFF"`
+	MaxBackoff time.Duration `env:"JOBS_MAX_BACKOFF"`
+	// Retention is how long done jobs are kept, DeadRetention is the same for dead letters
+	Retention     time.Duration `env:"JOBS_RETENTION"`
+	DeadRetention time.Duration `env:"JOBS_DEAD_RETENTION"`
+}
+
+func NewJobsConfig() *JobsConfig {
+	cfg := JobsConfig{}
+
+	if err := godotenv.Load(".env", ".env.local"); err == nil {
+		if err := env.Parse(&cfg); err != nil {
+			log.Printf("%+v\n", err)
+		}
+	}
+
+	if cfg.Store == "" {
+		cfg.Store = "file"
+	}
+
+	if cfg.Dir == "" {
+		cfg.Dir = ".jobs"
+	}
+
+	if cfg.Workers < 1 {
+		cfg.Workers = 2
+	}
+
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 5
+	}
+
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = 2 * time.Second
+	}
+
+	if cfg.MaxBackoff < cfg.Backoff {
+		cfg.MaxBackoff = 5 * time.Minute
+	}
+
+	if cfg.Retention <= 0 {
+		cfg.Retention = 24 * time.Hour
+	}
+
+	if cfg.DeadRetention <= 0 {
+		cfg.DeadRetention = 7 * 24 * time.Hour
+	}
+
+	return &cfg
+}
@@ -0,0 +1,46 @@
+package configs
+
+import (
+	"github.com/caarlos0/env/v7"
+	"github.com/joho/godotenv"
+	log "github.com/sirupsen/logrus"
+)
+
+type ResizerConfig struct {
+	// Engine is imgproxy to redirect downloads to imgproxy,
+	// or native to process images in-process
+	Engine string `env:"RESIZER_ENGINE"`
+	// Filter of native engine: lanczos, catmullrom, bilinear or nearest
+	Filter string `env:"RESIZER_FILTER"`
+	// Variants are presets rendered and stored at upload time, e.g. small,medium,blurry
+	Variants []string `env:"RESIZER_VARIANTS" envSeparator:","`
+}
+
+func NewResizerConfig() *ResizerConfig {
+	cfg := ResizerConfig{}
+
+	if err := godotenv.Load(".env", ".env.local"); err == nil {
+		if err := env.Parse(&cfg); err != nil {
+			log.Printf("%+v\n", err)
+		}
+	}
+
+	if cfg.Engine == "" {
+		cfg.Engine = "imgproxy"
+	}
+
+	if cfg.Filter == "" {
+		cfg.Filter = "lanczos"
+	}
+
+	return &cfg
+}
+
+// HasVariants tells if uploaded images get eager variants
+func (rc *ResizerConfig) HasVariants() bool {
+	return len(rc.Variants) != 0
+}
+
+func (rc *ResizerConfig) IsNative() bool {
+	return rc.Engine == "native"
+}
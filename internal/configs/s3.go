@@ -1,6 +1,10 @@
 package configs
 
 import (
+	"net/url"
+	"strings"
+	"time"
+
 	"github.com/caarlos0/env/v7"
 	"github.com/joho/godotenv"
 	log "github.com/sirupsen/logrus"
@@ -13,8 +17,25 @@ type S3Config struct {
 	AccessKey string `env:"S3_AKEY"`
 	SecretKey string `env:"S3_SKEY"`
 	UseSSL    bool   `env:"S3_USE_SSL"`
+	// PartSize of multipart uploads in bytes, S3 requires at least 5MB
+	PartSize int64 `env:"S3_PART_SIZE"`
+	// UploadConcurrency is how many parts of one upload are sent in parallel
+	UploadConcurrency int `env:"S3_UPLOAD_CONCURRENCY"`
+	// UploadPresignTTL is how long presigned upload URLs are valid
+	UploadPresignTTL time.Duration `env:"S3_UPLOAD_PRESIGN_TTL"`
+	// DownloadPresignTTL is default lifetime of presigned download URLs,
+	// requested ones are clamped to DownloadPresignMaxTTL
+	DownloadPresignTTL    time.Duration `env:"S3_DOWNLOAD_PRESIGN_TTL"`
+	DownloadPresignMaxTTL time.Duration `env:"S3_DOWNLOAD_PRESIGN_MAX_TTL"`
+	// PublicURL is bucket URL objects are publicly served from, e.g. CDN
+	PublicURL string `env:"S3_PUBLIC_URL"`
 }
 
+const minPartSize = 5 * 1024 * 1024
+
+// SigV4 presigned URLs can't live longer than a week
+const maxPresignTTL = 7 * 24 * time.Hour
+
 func NewS3Config() *S3Config {
 	cfg := S3Config{}
 
@@ -32,5 +53,66 @@ func NewS3Config() *S3Config {
 		}
 	}
 
+	if cfg.PartSize < minPartSize {
+		cfg.PartSize = minPartSize
+	}
+
+	if cfg.UploadConcurrency < 1 {
+		cfg.UploadConcurrency = 4
+	}
+
+	if cfg.UploadPresignTTL <= 0 {
+		cfg.UploadPresignTTL = 15 * time.Minute
+	}
+
+	if cfg.DownloadPresignMaxTTL <= 0 || cfg.DownloadPresignMaxTTL > maxPresignTTL {
+		cfg.DownloadPresignMaxTTL = maxPresignTTL
+	}
+
+	if cfg.DownloadPresignTTL <= 0 {
+		cfg.DownloadPresignTTL = maxPresignTTL
+	}
+
+	cfg.DownloadPresignTTL = cfg.ClampPresignTTL(cfg.DownloadPresignTTL)
+
 	return &cfg
 }
+
+// ClampPresignTTL limits lifetime of presigned download URL
+func (sc *S3Config) ClampPresignTTL(ttl time.Duration) time.Duration {
+	if ttl > sc.DownloadPresignMaxTTL {
+		return sc.DownloadPresignMaxTTL
+	}
+
+	return ttl
+}
+
+// ObjectURL is public URL of the object, bucket URL is derived
+// from endpoint or AWS region when PublicURL isn't set
+func (sc *S3Config) ObjectURL(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	path := strings.Join(segments, "/")
+
+	if sc.PublicURL != "" {
+		return strings.TrimSuffix(sc.PublicURL, "/") + "/" + path
+	}
+
+	if sc.Endpoint != "" {
+		endpoint := sc.Endpoint
+		if i := strings.Index(endpoint, "://"); i >= 0 {
+			endpoint = endpoint[i+3:]
+		}
+
+		scheme := "http://"
+		if sc.UseSSL {
+			scheme = "https://"
+		}
+
+		return scheme + endpoint + "/" + sc.Bucket + "/" + path
+	}
+
+	return "https://" + sc.Bucket + ".s3." + sc.Region + ".amazonaws.com/" + path
+}
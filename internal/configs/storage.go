@@ -0,0 +1,48 @@
+package configs
+
+import (
+	"github.com/caarlos0/env/v7"
+	"github.com/joho/godotenv"
+	log "github.com/sirupsen/logrus"
+)
+
+type StorageConfig struct {
+	Driver    string `env:"STORAGE_DRIVER"`
+	LocalRoot string `env:"STORAGE_LOCAL_ROOT"`
+	// DownloadMode of files that aren't images: presign redirects to the bucket,
+	// stream sends them through the service with Range support
+	DownloadMode string `env:"STORAGE_DOWNLOAD_MODE"`
+}
+
+func NewStorageConfig() *StorageConfig {
+	cfg := StorageConfig{}
+
+	if err := godotenv.Load(".env", ".env.local"); err == nil {
+		if err := env.Parse(&cfg); err != nil {
+			log.Printf("%+v\n", err)
+		}
+	}
+
+	if cfg.Driver == "" {
+		cfg.Driver = "s3"
+	}
+
+	if cfg.LocalRoot == "" {
+		cfg.LocalRoot = "www"
+	}
+
+	if cfg.DownloadMode == "" {
+		cfg.DownloadMode = "presign"
+	}
+
+	return &cfg
+}
+
+func (sc *StorageConfig) IsLocal() bool {
+	return sc.Driver == "local"
+}
+
+// IsStream tells if files are streamed, only S3 has URLs clients can download from
+func (sc *StorageConfig) IsStream() bool {
+	return sc.DownloadMode == "stream" || sc.Driver != "s3"
+}
@@ -0,0 +1,64 @@
+package configs
+
+import (
+	"time"
+
+	"github.com/caarlos0/env/v7"
+	"github.com/joho/godotenv"
+	log "github.com/sirupsen/logrus"
+)
+
+type UploadConfig struct {
+	// StripMetadata removes EXIF, XMP and IPTC from uploaded JPEG, PNG and WebP
+	// and bakes EXIF orientation in, callers may opt out with keepMetadata form field
+	StripMetadata bool `env:"UPLOAD_STRIP_METADATA"`
+	// AllowedTypes are MIME types detected by magic bytes, like image/png or image/*
+	AllowedTypes []string `env:"UPLOAD_ALLOWED_TYPES" envSeparator:","`
+	// AllowedExtensions are file extensions without dot
+	AllowedExtensions []string `env:"UPLOAD_ALLOWED_EXTENSIONS" envSeparator:","`
+	// PoliciesFile is json with default, per route and per tenant allowlists
+	PoliciesFile string `env:"UPLOAD_POLICIES_FILE"`
+	// TenantHeader identifies tenant of a request
+	TenantHeader string `env:"UPLOAD_TENANT_HEADER"`
+	// MaxResolution (megapixels) and MaxAnimationFrames are read from imgproxy settings,
+	// so it accepts whatever is uploaded. Negative values disable limits. File size is
+	// limited by APP_MAX_FILE_SIZE, imgproxy gets it as IMGPROXY_MAX_SRC_FILE_SIZE
+	MaxResolution      float64 `env:"IMGPROXY_MAX_SRC_RESOLUTION"`
+	MaxAnimationFrames int     `env:"IMGPROXY_MAX_ANIMATION_FRAMES"`
+	// MaxWidth and MaxHeight of images in pixels, no limits by default
+	MaxWidth  int `env:"UPLOAD_MAX_WIDTH"`
+	MaxHeight int `env:"UPLOAD_MAX_HEIGHT"`
+	// ContentAddressed stores uploads under SHA-256 of their content, so the same
+	// file uploaded again isn't stored twice. Uploads get own keys referring to it
+	ContentAddressed bool `env:"UPLOAD_CONTENT_ADDRESSED"`
+	// TusExpiry is how long tus uploads may take, unfinished ones are deleted then
+	TusExpiry time.Duration `env:"UPLOAD_TUS_EXPIRY"`
+}
+
+func NewUploadConfig() *UploadConfig {
+	cfg := UploadConfig{}
+
+	if err := godotenv.Load(".env", ".env.local"); err == nil {
+		if err := env.Parse(&cfg); err != nil {
+			log.Printf("%+v\n", err)
+		}
+	}
+
+	if cfg.TenantHeader == "" {
+		cfg.TenantHeader = "X-Tenant-ID"
+	}
+
+	if cfg.MaxResolution == 0 {
+		cfg.MaxResolution = 50
+	}
+
+	if cfg.MaxAnimationFrames == 0 {
+		cfg.MaxAnimationFrames = 64
+	}
+
+	if cfg.TusExpiry <= 0 {
+		cfg.TusExpiry = 24 * time.Hour
+	}
+
+	return &cfg
+}
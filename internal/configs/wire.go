@@ -8,4 +8,8 @@ var ConfigsSet = wire.NewSet(
 	NewAppConfig,
 	NewS3Config,
 	NewImgProxyConfig,
+	NewStorageConfig,
+	NewResizerConfig,
+	NewJobsConfig,
+	NewUploadConfig,
 )
@@ -1,9 +1,53 @@
 package dtos
 
+import "time"
+
 type DownloadFileQuery struct {
-	Size string `query:"size" default:"_small"`
+	Size string `query:"size"`
+	// Format is jpg, png, gif, webp or avif. Native engine can't encode
+	// webp and avif, it returns jpg or png for images with transparency
+	Format string `query:"format"`
 }
 
 type DownloadFileResponse struct {
 	Url string `json:"url"`
 }
+
+type PresignDownloadQuery struct {
+	TTL                string `query:"ttl"`
+	ContentDisposition string `query:"response-content-disposition"`
+	ContentType        string `query:"response-content-type"`
+}
+
+type PresignDownloadResponse struct {
+	Url       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type ListFilesQuery struct {
+	Prefix    string `query:"prefix"`
+	Delimiter string `query:"delimiter"`
+	Cursor    string `query:"cursor"`
+	Limit     int64  `query:"limit"`
+	// Stat fills content types S3 listing doesn't return, one request per file
+	Stat bool `query:"stat"`
+}
+
+type ListFilesResponse struct {
+	Files []FileInfo `json:"files"`
+	// Prefixes are common prefixes of keys when delimiter is set
+	Prefixes []string `json:"prefixes,omitempty"`
+	// Cursor of the next page, empty on the last one
+	Cursor string `json:"cursor,omitempty"`
+}
+
+type FileInfo struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	// ContentType is empty if listing doesn't return it and stat isn't asked
+	ContentType  string    `json:"contentType"`
+	LastModified time.Time `json:"lastModified"`
+	// Url is imgproxy URL GET /api/v1/upload/<key> of an image redirects to,
+	// or URL of GET itself
+	Url string `json:"url"`
+}
@@ -21,3 +21,10 @@ func ErrResponse(msg string) GenericResponse {
 	resp.Message = msg
 	return resp
 }
+
+// ErrDataResponse is ErrResponse with details of the error
+func ErrDataResponse(msg string, data interface{}) GenericResponse {
+	resp := ErrResponse(msg)
+	resp.Data = data
+	return resp
+}
@@ -0,0 +1,36 @@
+package dtos
+
+import "time"
+
+type PresignUploadFile struct {
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+}
+
+type PresignUploadRequest struct {
+	Files []PresignUploadFile `json:"files"`
+}
+
+type PresignUploadResponse struct {
+	Name      string            `json:"name"`
+	Key       string            `json:"key"`
+	Url       string            `json:"url"`
+	Method    string            `json:"method"`
+	Headers   map[string]string `json:"headers"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+}
+
+type FinalizeUploadRequest struct {
+	Keys []string `json:"keys"`
+}
+
+type DeleteFilesRequest struct {
+	Keys []string `json:"keys"`
+}
+
+type DeleteFilesResponse struct {
+	Deleted []string `json:"deleted"`
+	// Aborted is number of aborted unfinished multipart uploads
+	Aborted int `json:"aborted"`
+}
@@ -4,6 +4,39 @@ import "time"
 
 type UploadFilesResponse struct {
 	Name       string    `json:"name"`
-	Url        string    `json:"url"`
+	Url        string    `json:"url,omitempty"`
 	UploadedAt time.Time `json:"uploadedAt"`
+	// Error is code of failed upload of the file, it has no URL then
+	Error string `json:"error,omitempty"`
+	// BlurHash and Lqip (data URI) are placeholders of uploaded image
+	BlurHash string `json:"blurhash,omitempty"`
+	Lqip     string `json:"lqip,omitempty"`
+	// Variants are public URLs of eagerly rendered presets by preset name,
+	// they are available once variants job is done
+	Variants map[string]string `json:"variants,omitempty"`
+	// Jobs are IDs of background jobs making derived assets
+	Jobs []string `json:"jobs,omitempty"`
+}
+
+// UploadLimitResponse is data of error of file exceeding upload limit
+type UploadLimitResponse struct {
+	Name   string  `json:"name"`
+	Limit  string  `json:"limit"`
+	Max    float64 `json:"max"`
+	Actual float64 `json:"actual"`
+}
+
+// UploadSizeResponse is data of error of request over APP_MAX_* limits
+type UploadSizeResponse struct {
+	MaxFiles       int              `json:"maxFiles"`
+	MaxFileSize    int64            `json:"maxFileSize"`
+	MaxRequestSize int64            `json:"maxRequestSize"`
+	Size           int64            `json:"size"`
+	Files          []UploadFileSize `json:"files"`
+}
+
+type UploadFileSize struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	TooLarge bool   `json:"tooLarge"`
 }
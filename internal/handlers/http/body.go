@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	dtos "github.com/WildEgor/gImageResizer/internal/dtos"
+	"github.com/gofiber/fiber/v2"
+)
+
+// LimitBody reads request bodies of up to limit bytes into memory and rejects
+// larger ones with 413 ERR_REQUEST_TOO_LARGE. Fiber streams request bodies,
+// so every route but uploads, which measure bodies over the limit, needs it
+func LimitBody(limit int) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if isUpload(ctx) {
+			return ctx.Next()
+		}
+
+		_, buffered, err := bufferBody(ctx, limit)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_BODY"))
+		}
+		if !buffered {
+			// the rest of body isn't read, connection can't be reused
+			ctx.Context().SetConnectionClose()
+			return ctx.Status(fiber.StatusRequestEntityTooLarge).JSON(dtos.ErrResponse("ERR_REQUEST_TOO_LARGE"))
+		}
+
+		return ctx.Next()
+	}
+}
+
+// isUpload tells if request is POST /api/v1/upload, it reads body on its own
+func isUpload(ctx *fiber.Ctx) bool {
+	return ctx.Method() == fiber.MethodPost && strings.EqualFold(strings.TrimSuffix(ctx.Path(), "/"), "/api/v1/upload")
+}
+
+// bufferBody reads request body of up to limit bytes into memory, as fiber does
+// without streaming. Larger bodies aren't buffered, the whole body is returned
+// to be read instead
+func bufferBody(ctx *fiber.Ctx, limit int) (io.Reader, bool, error) {
+	stream := ctx.Context().RequestBodyStream()
+	length := ctx.Request().Header.ContentLength()
+
+	switch {
+	case stream == nil:
+		return nil, true, nil
+	case length > limit:
+		return stream, false, nil
+	case length >= 0:
+		ctx.Request().Body()
+		return nil, true, nil
+	}
+
+	// chunked body of unknown size
+	body, err := io.ReadAll(io.LimitReader(stream, int64(limit)+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(body) > limit {
+		return io.MultiReader(bytes.NewReader(body), stream), false, nil
+	}
+	ctx.Request().SetBody(body)
+
+	return nil, true, nil
+}
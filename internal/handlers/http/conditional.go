@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/gofiber/fiber/v2"
+)
+
+var errRange = errors.New("range not satisfiable")
+
+// setValidators sets ETag and Last-Modified of the object
+func setValidators(ctx *fiber.Ctx, obj *adapters.S3Obj) {
+	if obj.ETag != "" {
+		ctx.Set(fiber.HeaderETag, obj.ETag)
+	}
+
+	if !obj.LastModified.IsZero() {
+		ctx.Set(fiber.HeaderLastModified, obj.LastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// notModified tells if client's copy of the object is fresh,
+// If-Modified-Since is ignored when If-None-Match is sent
+func notModified(ctx *fiber.Ctx, obj *adapters.S3Obj) bool {
+	if match := ctx.Get(fiber.HeaderIfNoneMatch); match != "" {
+		return etagMatches(match, obj.ETag)
+	}
+
+	since, err := http.ParseTime(ctx.Get(fiber.HeaderIfModifiedSince))
+	if err != nil || obj.LastModified.IsZero() {
+		return false
+	}
+
+	// header has seconds precision
+	return !obj.LastModified.Truncate(time.Second).After(since)
+}
+
+// etagMatches compares etags weakly, as If-None-Match requires
+func etagMatches(header, etag string) bool {
+	if etag == "" {
+		return false
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseRange reads single byte range of Range header for object of size.
+// ok is false when the whole object should be sent: no header, several ranges
+// or a malformed one. errRange means range is out of the object
+func parseRange(header string, size int64) (start, length int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+
+	// suffix range: last N bytes
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false, nil
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false, errRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, nil
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	if start >= size {
+		return 0, 0, false, errRange
+	}
+
+	return start, end - start + 1, true, nil
+}
+
+// ifRangeMatches tells if Range may be served, If-Range needs
+// strong ETag or exact Last-Modified of the object
+func ifRangeMatches(ctx *fiber.Ctx, obj *adapters.S3Obj) bool {
+	ifRange := ctx.Get(fiber.HeaderIfRange)
+	if ifRange == "" {
+		return true
+	}
+
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return ifRange == obj.ETag && !strings.HasPrefix(obj.ETag, "W/")
+	}
+
+	t, err := http.ParseTime(ifRange)
+
+	return err == nil && obj.LastModified.Truncate(time.Second).Equal(t)
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header        string
+		size          int64
+		start, length int64
+		ok            bool
+		err           error
+	}{
+		{"", 100, 0, 0, false, nil},
+		{"bytes=0-9", 100, 0, 10, true, nil},
+		{"bytes= 10-19", 100, 10, 10, true, nil},
+		{"bytes=90-", 100, 90, 10, true, nil},
+		{"bytes=90-200", 100, 90, 10, true, nil},
+		{"bytes=99-99", 100, 99, 1, true, nil},
+		// suffix ranges
+		{"bytes=-10", 100, 90, 10, true, nil},
+		{"bytes=-200", 100, 0, 100, true, nil},
+		{"bytes=-0", 100, 0, 0, false, errRange},
+		{"bytes=-5", 0, 0, 0, false, errRange},
+		// out of the file
+		{"bytes=100-", 100, 0, 0, false, errRange},
+		{"bytes=150-160", 100, 0, 0, false, errRange},
+		{"bytes=0-", 0, 0, 0, false, errRange},
+		// several ranges and malformed ones are ignored, the whole file is sent
+		{"bytes=0-9,20-29", 100, 0, 0, false, nil},
+		{"bytes=9-0", 100, 0, 0, false, nil},
+		{"bytes=a-b", 100, 0, 0, false, nil},
+		{"bytes=-a", 100, 0, 0, false, nil},
+		{"bytes=5", 100, 0, 0, false, nil},
+		{"bytes=-1-5", 100, 0, 0, false, nil},
+		{"items=0-9", 100, 0, 0, false, nil},
+	}
+
+	for _, tt := range tests {
+		start, length, ok, err := parseRange(tt.header, tt.size)
+		if !errors.Is(err, tt.err) || ok != tt.ok || start != tt.start || length != tt.length {
+			t.Errorf("parseRange(%q, %d) = %d, %d, %v, %v, want %d, %d, %v, %v",
+				tt.header, tt.size, start, length, ok, err, tt.start, tt.length, tt.ok, tt.err)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/cas"
+	"github.com/WildEgor/gImageResizer/internal/dtos"
+	"github.com/WildEgor/gImageResizer/internal/jobs"
+	"github.com/gofiber/fiber/v2"
+)
+
+// keys of one batch delete request
+const maxDeleteKeys = 1000
+
+type DeleteFileHandler struct {
+	s3Adapter adapters.IS3Adapter
+	tasks     *jobs.Tasks
+	store     *cas.Store
+}
+
+func NewDeleteFileHandler(
+	s3Adapter adapters.IS3Adapter,
+	tasks *jobs.Tasks,
+	store *cas.Store,
+) *DeleteFileHandler {
+	return &DeleteFileHandler{
+		s3Adapter: s3Adapter,
+		tasks:     tasks,
+		store:     store,
+	}
+}
+
+// DeleteFile godoc
+//
+//	@Summary		Delete file
+//	@Description	Deletes the file with its variants and metadata, unfinished multipart uploads of the key are aborted.
+//	@Description	Keys of content addressed uploads drop their reference, the shared file is deleted with the last one
+//	@Tags			upload
+//	@Param			key	path	string	true	"File key"
+//	@Router			/api/v1/upload/{key} [delete]
+func (h *DeleteFileHandler) Handle(ctx *fiber.Ctx) error {
+	key, err := url.PathUnescape(ctx.Params("key", ""))
+	if err != nil || !isDeletableKey(key) {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_KEY"))
+	}
+
+	exists, err := h.exists(ctx.Context(), key)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_DELETE"))
+	}
+
+	aborted, err := h.delete(ctx.Context(), []string{key})
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_DELETE"))
+	}
+
+	if !exists && aborted == 0 {
+		return ctx.Status(fiber.StatusNotFound).JSON(dtos.ErrResponse("ERR_NOT_FOUND"))
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// DeleteFiles godoc
+//
+//	@Summary		Delete files
+//	@Description	Deletes files with their variants and metadata in batches, missing keys are ignored.
+//	@Description	Keys of content addressed uploads drop their reference, the shared file is deleted with the last one
+//	@Tags			upload
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body	dtos.DeleteFilesRequest	true	"Keys"
+//	@Router			/api/v1/upload/delete [post]
+func (h *DeleteFileHandler) Batch(ctx *fiber.Ctx) error {
+	var req dtos.DeleteFilesRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_BODY"))
+	}
+
+	if len(req.Keys) == 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_EMPTY_KEYS"))
+	}
+
+	if len(req.Keys) > maxDeleteKeys {
+		return ctx.Status(fiber.StatusRequestEntityTooLarge).JSON(dtos.ErrResponse("ERR_TOO_MANY_KEYS"))
+	}
+
+	for _, key := range req.Keys {
+		if !isDeletableKey(key) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_KEY"))
+		}
+	}
+
+	aborted, err := h.delete(ctx.Context(), req.Keys)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_DELETE"))
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(dtos.SuccessResponse(dtos.DeleteFilesResponse{
+		Deleted: req.Keys,
+		Aborted: aborted,
+	}))
+}
+
+// exists tells if key is a stored file or alias of one
+func (h *DeleteFileHandler) exists(ctx context.Context, key string) (bool, error) {
+	_, err := h.store.Target(ctx, key)
+	if err == nil {
+		return true, nil
+	}
+
+	if errors.Is(err, adapters.ErrNotFound) {
+		_, err = h.s3Adapter.Stat(ctx, &adapters.S3Obj{Key: key})
+		if err == nil {
+			return true, nil
+		}
+	}
+
+	if errors.Is(err, adapters.ErrNotFound) {
+		return false, nil
+	}
+
+	log.Errorf("[DeleteFileHandler] Failed stat %v", err)
+	return false, err
+}
+
+// delete aborts unfinished uploads of keys and removes files with derived
+// objects, aliases of content addressed files are removed and the file goes
+// with the last of them. Returns number of aborted uploads
+func (h *DeleteFileHandler) delete(ctx context.Context, keys []string) (int, error) {
+	// aliases by the file they refer to, other keys are files themselves
+	shared := make(map[string]map[string]bool)
+	aliases := make(map[string]string)
+	plain := make([]string, 0, len(keys))
+	for _, key := range keys {
+		target, err := h.store.Target(ctx, key)
+		if errors.Is(err, adapters.ErrNotFound) {
+			plain = append(plain, key)
+			continue
+		}
+		if err != nil {
+			log.Errorf("[DeleteFileHandler] Failed load alias %v", err)
+			return 0, err
+		}
+
+		if shared[target] == nil {
+			shared[target] = make(map[string]bool)
+		}
+		shared[target][key] = true
+		aliases[key] = target
+	}
+
+	unlock := h.lock(shared)
+	defer unlock()
+
+	aborted := 0
+	objs := make([]*adapters.S3Obj, 0, len(keys))
+
+	for _, key := range plain {
+		uploadIDs, err := h.s3Adapter.ListMultipart(ctx, &adapters.S3Obj{Key: key})
+		if err != nil {
+			log.Errorf("[DeleteFileHandler] Failed list uploads %v", err)
+			return 0, err
+		}
+
+		for _, uploadID := range uploadIDs {
+			err := h.s3Adapter.AbortMultipart(ctx, &adapters.S3Obj{Key: key, UploadID: uploadID})
+			if err != nil && !errors.Is(err, adapters.ErrNotFound) {
+				log.Errorf("[DeleteFileHandler] Failed abort upload %v", err)
+				return 0, err
+			}
+			aborted++
+		}
+
+		objs = append(objs, &adapters.S3Obj{Key: key}, &adapters.S3Obj{Key: presignKey(key)})
+		for _, derived := range h.tasks.DerivedKeys(key) {
+			objs = append(objs, &adapters.S3Obj{Key: derived})
+		}
+	}
+
+	for key, deleted := range shared {
+		refs, err := h.store.Aliases(ctx, key)
+		if err != nil {
+			log.Errorf("[DeleteFileHandler] Failed list references %v", err)
+			return 0, err
+		}
+
+		// other uploads still refer to the file
+		if referred(refs, deleted) {
+			continue
+		}
+
+		objs = append(objs, &adapters.S3Obj{Key: key})
+		for _, derived := range h.tasks.DerivedKeys(key) {
+			objs = append(objs, &adapters.S3Obj{Key: derived})
+		}
+	}
+
+	if err := h.s3Adapter.DeleteObjs(ctx, objs); err != nil {
+		log.Errorf("[DeleteFileHandler] Failed delete %v", err)
+		return 0, err
+	}
+
+	// failed request keeps aliases, so files are never left without them
+	if err := h.store.Unlink(ctx, aliases); err != nil {
+		log.Errorf("[DeleteFileHandler] Failed delete aliases %v", err)
+		return 0, err
+	}
+
+	return aborted, nil
+}
+
+// referred tells if any of refs isn't deleted
+func referred(refs []string, deleted map[string]bool) bool {
+	for _, alias := range refs {
+		if !deleted[alias] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lock holds content addressed files until they are deleted, so they aren't
+// deduplicated meanwhile. Keys are locked in order to not deadlock other requests
+func (h *DeleteFileHandler) lock(shared map[string]map[string]bool) func() {
+	locked := make([]string, 0, len(shared))
+	for key := range shared {
+		locked = append(locked, key)
+	}
+	sort.Strings(locked)
+
+	unlocks := make([]func(), 0, len(locked))
+	for _, key := range locked {
+		unlocks = append(unlocks, h.store.Lock(key))
+	}
+
+	return func() {
+		for _, unlock := range unlocks {
+			unlock()
+		}
+	}
+}
+
+// isDeletableKey tells if key may be deleted by the API, content
+// addressed files are deleted through keys of their uploads
+func isDeletableKey(key string) bool {
+	return isFileKey(key) && !cas.IsKey(key)
+}
+
+// isFileKey tells if key may belong to uploaded file,
+// keys starting with "." or "_" are internal
+func isFileKey(key string) bool {
+	return key != "" && !strings.HasPrefix(key, ".") && !strings.HasPrefix(key, "_")
+}
@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/cas"
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/WildEgor/gImageResizer/internal/dtos"
+	"github.com/WildEgor/gImageResizer/internal/imgproxy"
+	"github.com/WildEgor/gImageResizer/internal/jobs"
+	"github.com/WildEgor/gImageResizer/internal/metadata"
+	"github.com/WildEgor/gImageResizer/internal/policy"
+	"github.com/WildEgor/gImageResizer/internal/resizer"
+	"github.com/gofiber/fiber/v2"
+)
+
+// flakyDeletes is storage failing batch deletes while fail is set
+type flakyDeletes struct {
+	*adapters.MemoryAdapter
+	fail *bool
+}
+
+func (f flakyDeletes) DeleteObjs(ctx context.Context, objs []*adapters.S3Obj) error {
+	if *f.fail {
+		return errors.New("delete failed")
+	}
+
+	return f.MemoryAdapter.DeleteObjs(ctx, objs)
+}
+
+// uploadTwice uploads the same image twice with UPLOAD_CONTENT_ADDRESSED,
+// returns keys of both uploads and content address they share
+func uploadTwice(t *testing.T, s *testServer) (string, string, string) {
+	t.Helper()
+
+	data := testPNG(t, 8, 8)
+	sum := sha256.Sum256(data)
+
+	keys := make([]string, 0, 2)
+	for _, name := range []string{"a.png", "b.png"} {
+		var files []dtos.UploadFilesResponse
+		s.do(t, uploadRequest(t, map[string][]byte{name: data}), &files)
+		if len(files) != 1 {
+			t.Fatalf("upload of %s failed", name)
+		}
+		key, _ := url.PathUnescape(strings.TrimPrefix(files[0].Url, testBaseURL+"/"))
+		keys = append(keys, key)
+	}
+
+	return keys[0], keys[1], cas.Key(sum[:])
+}
+
+func aliases(t *testing.T, s *testServer, key string) []string {
+	t.Helper()
+
+	refs, err := s.store.Aliases(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(refs)
+
+	return refs
+}
+
+func TestDeleteFileKeepsReferencesOnFailure(t *testing.T) {
+	fail := false
+	s := newWrappedTestServer(t, func(memory *adapters.MemoryAdapter) adapters.IS3Adapter {
+		return flakyDeletes{MemoryAdapter: memory, fail: &fail}
+	}, func(c *configs.UploadConfig) {
+		c.ContentAddressed = true
+	})
+
+	a, b, key := uploadTwice(t, s)
+	plain := "plain.png"
+	s.storage.PutObj(context.Background(), &adapters.S3Obj{Key: plain, Bytes: []byte("x"), ContentType: "image/png"})
+
+	fail = true
+	resp, _ := s.do(t, jsonRequest(t, http.MethodPost, "/api/v1/upload/delete", dtos.DeleteFilesRequest{
+		Keys: []string{a, plain},
+	}, nil), nil)
+	if resp.StatusCode != fiber.StatusInternalServerError {
+		t.Fatalf("got status %d, want 500", resp.StatusCode)
+	}
+	if refs := aliases(t, s, key); len(refs) != 2 {
+		t.Fatalf("got references %v after failed delete, want 2", refs)
+	}
+
+	fail = false
+	resp, _ = s.do(t, httpRequest(http.MethodDelete, "/api/v1/upload/"+url.PathEscape(a)), nil)
+	if resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("got status %d, want 204", resp.StatusCode)
+	}
+	if refs := aliases(t, s, key); len(refs) != 1 || refs[0] != b {
+		t.Errorf("got references %v, want %s", refs, b)
+	}
+	if s.storage.Object("test", key) == nil {
+		t.Errorf("file with a reference left is deleted")
+	}
+}
+
+func TestDeleteFileTwiceReleasesOnce(t *testing.T) {
+	s := newTestServer(t, func(c *configs.UploadConfig) {
+		c.ContentAddressed = true
+	})
+
+	a, b, key := uploadTwice(t, s)
+	if a == b {
+		t.Fatalf("uploads share key %s", a)
+	}
+
+	// retried delete of one upload doesn't touch the other one
+	for i, status := range []int{fiber.StatusNoContent, fiber.StatusNotFound, fiber.StatusNotFound} {
+		resp, _ := s.do(t, httpRequest(http.MethodDelete, "/api/v1/upload/"+url.PathEscape(a)), nil)
+		if resp.StatusCode != status {
+			t.Fatalf("delete #%d: got status %d, want %d", i+1, resp.StatusCode, status)
+		}
+	}
+	if s.storage.Object("test", key) == nil {
+		t.Fatalf("file of the other upload is deleted")
+	}
+
+	resp, _ := s.do(t, httpRequest(http.MethodGet, "/api/v1/upload/"+url.PathEscape(b)), nil)
+	if resp.StatusCode != fiber.StatusFound {
+		t.Errorf("other upload: got status %d, want 302", resp.StatusCode)
+	}
+	resp, _ = s.do(t, httpRequest(http.MethodGet, "/api/v1/upload/"+url.PathEscape(a)), nil)
+	if resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("deleted upload: got status %d, want 404", resp.StatusCode)
+	}
+
+	resp, _ = s.do(t, httpRequest(http.MethodDelete, "/api/v1/upload/"+url.PathEscape(b)), nil)
+	if resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("got status %d, want 204", resp.StatusCode)
+	}
+	if s.storage.Object("test", key) != nil {
+		t.Errorf("file is kept after its last upload is deleted")
+	}
+	if refs := aliases(t, s, key); len(refs) != 0 {
+		t.Errorf("got references %v", refs)
+	}
+}
+
+func TestDeleteFileRejectsContentAddress(t *testing.T) {
+	s := newTestServer(t, func(c *configs.UploadConfig) {
+		c.ContentAddressed = true
+	})
+
+	_, _, key := uploadTwice(t, s)
+
+	resp, message := s.do(t, httpRequest(http.MethodDelete, "/api/v1/upload/"+url.PathEscape(key)), nil)
+	if resp.StatusCode != fiber.StatusBadRequest || message != "ERR_KEY" {
+		t.Errorf("got %d %q, want 400 ERR_KEY", resp.StatusCode, message)
+	}
+	if s.storage.Object("test", key) == nil {
+		t.Errorf("shared file is deleted")
+	}
+}
+
+func TestDeleteFilesReleasesEveryKey(t *testing.T) {
+	s := newTestServer(t, func(c *configs.UploadConfig) {
+		c.ContentAddressed = true
+	})
+
+	a, b, key := uploadTwice(t, s)
+
+	var deleted dtos.DeleteFilesResponse
+	resp, _ := s.do(t, jsonRequest(t, http.MethodPost, "/api/v1/upload/delete", dtos.DeleteFilesRequest{
+		Keys: []string{a, b, a},
+	}, nil), &deleted)
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+
+	if s.storage.Object("test", key) != nil {
+		t.Errorf("file is kept after its last reference is deleted")
+	}
+	for _, alias := range []string{a, b} {
+		if s.storage.Object("test", cas.AliasKey(alias)) != nil || s.storage.Object("test", cas.RefKey(key, alias)) != nil {
+			t.Errorf("alias %s is kept", alias)
+		}
+	}
+}
+
+// newTestDeleteHandler deletes files of storage with variants of default presets
+func newTestDeleteHandler(storage adapters.IS3Adapter) *DeleteFileHandler {
+	appConfig := &configs.AppConfig{BaseURL: testBaseURL}
+	uploadConfig := &configs.UploadConfig{}
+	s3Config := &configs.S3Config{Bucket: "test"}
+	resizerConfig := &configs.ResizerConfig{Engine: "imgproxy", Filter: "lanczos"}
+	presets := imgproxy.NewPresets(&configs.ImgProxyConfig{DefaultPreset: "medium"})
+	tasks := jobs.NewTasks(s3Config, resizerConfig, storage, presets, resizer.NewResizer(resizerConfig), policy.NewLimits(appConfig, uploadConfig))
+
+	return NewDeleteFileHandler(storage, tasks, cas.NewStore(uploadConfig, storage))
+}
+
+// derivedKeys are variants, metadata sidecar and presign record of key
+func derivedKeys(key string) []string {
+	return []string{
+		resizer.VariantKey(key, "blurry"),
+		resizer.VariantKey(key, "small"),
+		resizer.VariantKey(key, "medium"),
+		metadata.Key(key),
+		presignKey(key),
+	}
+}
+
+func TestDeleteFileRemovesDerived(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, key := range []string{"a.png", "b.png", "c.png"} {
+		for _, k := range append(derivedKeys(key), key) {
+			s.storage.PutObj(context.Background(), &adapters.S3Obj{Key: k, Bytes: []byte(k), ContentType: "image/png"})
+		}
+	}
+
+	resp, _ := s.do(t, httpRequest(http.MethodDelete, "/api/v1/upload/a.png"), nil)
+	if resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("got status %d, want 204", resp.StatusCode)
+	}
+
+	var deleted dtos.DeleteFilesResponse
+	resp, _ = s.do(t, jsonRequest(t, http.MethodPost, "/api/v1/upload/delete", dtos.DeleteFilesRequest{
+		Keys: []string{"b.png", "missing.png"},
+	}, nil), &deleted)
+	if resp.StatusCode != fiber.StatusOK || deleted.Aborted != 0 {
+		t.Fatalf("got status %d, %+v", resp.StatusCode, deleted)
+	}
+
+	for _, key := range []string{"a.png", "b.png"} {
+		for _, k := range append(derivedKeys(key), key) {
+			if s.storage.Object("test", k) != nil {
+				t.Errorf("%s is kept after %s is deleted", k, key)
+			}
+		}
+	}
+
+	// objects of other keys stay
+	for _, k := range append(derivedKeys("c.png"), "c.png") {
+		if s.storage.Object("test", k) == nil {
+			t.Errorf("%s is deleted", k)
+		}
+	}
+}
+
+func TestDeleteFileAbortsMultipart(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	// unfinished uploads of keys without files
+	uploads := make(map[string]string)
+	for _, key := range []string{"a.png", "b.png", "c.png"} {
+		uploadID, err := s.storage.CreateMultipart(ctx, &adapters.S3Obj{Key: key, ContentType: "image/png"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.storage.UploadPart(ctx, &adapters.S3Obj{Key: key, UploadID: uploadID, PartNumber: 1, Bytes: []byte("part")}); err != nil {
+			t.Fatal(err)
+		}
+		uploads[key] = uploadID
+	}
+
+	for i, status := range []int{fiber.StatusNoContent, fiber.StatusNotFound} {
+		resp, _ := s.do(t, httpRequest(http.MethodDelete, "/api/v1/upload/a.png"), nil)
+		if resp.StatusCode != status {
+			t.Fatalf("delete #%d: got status %d, want %d", i+1, resp.StatusCode, status)
+		}
+	}
+
+	var deleted dtos.DeleteFilesResponse
+	resp, _ := s.do(t, jsonRequest(t, http.MethodPost, "/api/v1/upload/delete", dtos.DeleteFilesRequest{
+		Keys: []string{"b.png", "a.png"},
+	}, nil), &deleted)
+	if resp.StatusCode != fiber.StatusOK || deleted.Aborted != 1 {
+		t.Fatalf("got status %d, %+v, want 1 aborted", resp.StatusCode, deleted)
+	}
+
+	for key, uploadID := range uploads {
+		_, err := s.storage.ListParts(ctx, &adapters.S3Obj{Key: key, UploadID: uploadID})
+		if aborted := errors.Is(err, adapters.ErrNotFound); aborted != (key != "c.png") {
+			t.Errorf("%s: got %v listing parts", key, err)
+		}
+	}
+}
+
+func TestDeleteFileAbortsTusUpload(t *testing.T) {
+	memory := adapters.NewMemoryAdapter(&configs.S3Config{Bucket: "test"})
+	app, tus := newTusTestApp(t, memory)
+	app.Delete("/api/v1/upload/:key", newTestDeleteHandler(memory).Handle)
+	s := &testServer{app: app, storage: memory}
+
+	data := append([]byte("\x89PNG\r\n\x1a\n"), []byte("0123456789abcdef")...)
+	target := createTus(t, s, "a.png", len(data))
+
+	// a part is uploaded, the rest waits in tail
+	if resp, message := patchTus(t, s, target, 0, data[:10], nil); resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("patch: got %d %q", resp.StatusCode, message)
+	}
+
+	upload, err := tus.load(context.Background(), target[strings.LastIndex(target, "/")+1:])
+	if err != nil || upload.UploadID == "" {
+		t.Fatalf("got upload %+v, %v", upload, err)
+	}
+
+	resp, _ := s.do(t, httpRequest(http.MethodDelete, "/api/v1/upload/"+url.PathEscape(upload.Key)), nil)
+	if resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("got status %d, want 204", resp.StatusCode)
+	}
+
+	if ids, _ := memory.ListMultipart(context.Background(), &adapters.S3Obj{Key: upload.Key}); len(ids) != 0 {
+		t.Errorf("got uploads %v", ids)
+	}
+
+	// the upload can't be resumed, nor completed at the deleted key
+	resp, _ = s.do(t, tusRequest(http.MethodHead, target, nil, nil), nil)
+	if resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("head: got status %d, want 404", resp.StatusCode)
+	}
+	if resp, _ := patchTus(t, s, target, 10, data[10:], nil); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("patch: got status %d, want 404", resp.StatusCode)
+	}
+	if memory.Object("test", upload.Key) != nil {
+		t.Errorf("deleted upload is completed")
+	}
+}
@@ -1,88 +1,465 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sync"
+
 	log "github.com/sirupsen/logrus"
 
 	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/cas"
 	"github.com/WildEgor/gImageResizer/internal/configs"
 	"github.com/WildEgor/gImageResizer/internal/dtos"
+	"github.com/WildEgor/gImageResizer/internal/imgproxy"
+	"github.com/WildEgor/gImageResizer/internal/policy"
+	"github.com/WildEgor/gImageResizer/internal/resizer"
 	"github.com/gofiber/fiber/v2"
 )
 
 type DownloadFileHandler struct {
 	imgProxyConfig *configs.ImgProxyConfig
 	appConfig      *configs.AppConfig
+	storageConfig  *configs.StorageConfig
+	s3Config       *configs.S3Config
+	resizerConfig  *configs.ResizerConfig
 	s3Adapter      adapters.IS3Adapter
+	urlBuilder     *imgproxy.Builder
+	presets        *imgproxy.Presets
+	resizer        *resizer.Resizer
+	limits         *policy.Limits
+	store          *cas.Store
 }
 
 func NewDownloadFileHandler(
 	imgProxyConfig *configs.ImgProxyConfig,
 	appConfig *configs.AppConfig,
+	storageConfig *configs.StorageConfig,
+	s3Config *configs.S3Config,
+	resizerConfig *configs.ResizerConfig,
 	s3Adapter adapters.IS3Adapter,
+	presets *imgproxy.Presets,
+	resizer *resizer.Resizer,
+	limits *policy.Limits,
+	store *cas.Store,
 ) *DownloadFileHandler {
+	urlBuilder, err := imgproxy.NewBuilder(imgProxyConfig.Key, imgProxyConfig.Salt, imgProxyConfig.EncodeSource)
+	if err != nil {
+		log.Fatalf("[DownloadFileHandler] %v", err)
+	}
+	if imgProxyConfig.Key == "" {
+		log.Warn("[DownloadFileHandler] IMG_PROXY_KEY isn't set, imgproxy URLs are unsigned")
+	}
+
 	return &DownloadFileHandler{
 		imgProxyConfig: imgProxyConfig,
 		appConfig:      appConfig,
+		storageConfig:  storageConfig,
+		s3Config:       s3Config,
+		resizerConfig:  resizerConfig,
 		s3Adapter:      s3Adapter,
+		urlBuilder:     urlBuilder,
+		presets:        presets,
+		resizer:        resizer,
+		limits:         limits,
+		store:          store,
 	}
 }
 
-var Sizes map[string]string = map[string]string{
-	"_small":  "_small",
-	"_medium": "_medium",
-	"_blurry": "_blurry",
-	"default": "_medium",
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+	// HEAD requests sent in parallel to get content types of listed files
+	statConcurrency = 8
+)
+
+// Formats imgproxy can convert images to,
+// native engine falls back to jpg or png for webp and avif
+var Formats = map[string]bool{
+	"jpg":  true,
+	"png":  true,
+	"webp": true,
+	"avif": true,
+	"gif":  true,
 }
 
-// TODO: generate sign for private bucket by key and create link
-// to image proxy, check extension if image -> proxy, else direct redirect
-// Example: GET https://yourdomain.com/api/v1/upload/{uuid.path = key}?size=200
+// Example: GET https://yourdomain.com/api/v1/upload/{uuid.path = key}?size=_small&format=webp
 
 // DownloadFiles godoc
 //
 //	@Summary		Get file
-//	@Description	Get file
+//	@Description	Redirects to signed imgproxy URL of the image, or returns processed image with RESIZER_ENGINE=native.
+//	@Description	Other files are redirected to presigned bucket URL, or streamed with Range support with STORAGE_DOWNLOAD_MODE=stream
 //	@Tags			upload
-//	@Produce		json
-//	@Router			/api/v1/upload [get]
+//	@Param			key		path	string	true	"File key"
+//	@Param			size	query	string	false	"Preset name, e.g. _small"
+//	@Param			format	query	string	false	"Output format: jpg, png, gif, webp or avif. With RESIZER_ENGINE=native webp and avif fall back to jpg, or to png for images with transparency"
+//	@Router			/api/v1/upload/{key} [get]
 func (h *DownloadFileHandler) Handle(ctx *fiber.Ctx) error {
-	log.WithContext(ctx.Context())
+	return h.serve(ctx, false)
+}
+
+// HeadFile godoc
+//
+//	@Summary		Get file headers
+//	@Description	Returns status and headers GET of the same URL would, supports If-None-Match and If-Modified-Since
+//	@Tags			upload
+//	@Param			key		path	string	true	"File key"
+//	@Param			size	query	string	false	"Preset name, e.g. _small"
+//	@Param			format	query	string	false	"Output format: jpg, png, gif, webp or avif. With RESIZER_ENGINE=native webp and avif fall back to jpg, or to png for images with transparency"
+//	@Router			/api/v1/upload/{key} [head]
+func (h *DownloadFileHandler) Head(ctx *fiber.Ctx) error {
+	return h.serve(ctx, true)
+}
 
-	// link, err := h.s3Adapter.GetPresign(ctx.Context(), &adapters.S3Obj{
-	// 	Key: key,
-	// })
-	// if err != nil {
-	// 	log.Error(err)
-	// 	ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_PRESIGN"))
-	// }
+// serve answers GET and HEAD alike, so they have the same status, validators
+// and headers. HEAD doesn't read streamed files, fasthttp drops other bodies
+func (h *DownloadFileHandler) serve(ctx *fiber.Ctx, head bool) error {
+	log.WithContext(ctx.Context())
 
-	key := ctx.Params("key", "")
-	if key == "" {
-		ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_EMPTY_KEY"))
+	key, err := url.PathUnescape(ctx.Params("key", ""))
+	if err != nil || key == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_EMPTY_KEY"))
 	}
 
 	query, err := h.parseQuery(ctx)
 	if err != nil {
-		ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_QUERY"))
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_QUERY"))
+	}
+
+	if query.Format != "" && !Formats[query.Format] {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_FORMAT"))
+	}
+
+	key, err = h.store.Resolve(ctx.Context(), key)
+	if err != nil {
+		return statErr(ctx, err)
+	}
+
+	stat, err := h.s3Adapter.Stat(ctx.Context(), &adapters.S3Obj{Key: key})
+	if err != nil {
+		return statErr(ctx, err)
+	}
+
+	// processed image and redirect depend only on the original and the query,
+	// image processed here isn't the original, so it gets own etag
+	validators := stat
+	native := isImage(stat.ContentType) && h.resizerConfig.IsNative()
+	if native {
+		validators = &adapters.S3Obj{ETag: variantETag(stat.ETag, query), LastModified: stat.LastModified}
+	}
+
+	setValidators(ctx, validators)
+	if notModified(ctx, validators) {
+		return ctx.SendStatus(fiber.StatusNotModified)
+	}
+
+	if !isImage(stat.ContentType) {
+		return h.serveFile(ctx, stat, head)
+	}
+
+	// processed image is rendered for HEAD too, its length isn't known otherwise
+	if native {
+		return h.serveNative(ctx, stat, query)
+	}
+
+	URL, err := h.buildURL(key, query)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_UNKNOWN_PRESET"))
+	}
+
+	return ctx.Redirect(h.imgProxyConfig.BaseURL + URL)
+}
+
+// statErr responds with 404 when the file doesn't exist
+func statErr(ctx *fiber.Ctx, err error) error {
+	if errors.Is(err, adapters.ErrNotFound) {
+		return ctx.Status(fiber.StatusNotFound).JSON(dtos.ErrResponse("ERR_NOT_FOUND"))
+	}
+
+	log.Error("[DownloadFileHandler] Failed stat: ", err)
+	return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_DOWNLOAD"))
+}
+
+// ListFiles godoc
+//
+//	@Summary		List files
+//	@Description	Returns a page of uploaded files with their size, content type and the URL GET /api/v1/upload/{key} leads to.
+//	@Description	Variants, metadata and other internal objects are skipped, so pages may be shorter than limit.
+//	@Description	S3 listing has no content types, stat=true gets them with a request per file, images get imgproxy URLs only then
+//	@Tags			upload
+//	@Produce		json
+//	@Param			prefix		query	string	false	"Key prefix"
+//	@Param			delimiter	query	string	false	"Groups keys into prefixes, e.g. /"
+//	@Param			cursor		query	string	false	"Cursor of the previous page"
+//	@Param			limit		query	int		false	"Page size, 100 by default, up to 1000"
+//	@Param			stat		query	bool	false	"Stat files without content type"
+//	@Router			/api/v1/upload [get]
+func (h *DownloadFileHandler) List(ctx *fiber.Ctx) error {
+	var query dtos.ListFilesQuery
+	if err := ctx.QueryParser(&query); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_QUERY"))
+	}
+
+	if query.Limit == 0 {
+		query.Limit = defaultListLimit
+	}
+	if query.Limit < 0 || query.Limit > maxListLimit {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_LIMIT"))
+	}
+
+	listQuery := &adapters.S3ListQuery{
+		Prefix:    query.Prefix,
+		Delimiter: query.Delimiter,
+		Cursor:    query.Cursor,
+		Limit:     query.Limit,
+	}
+	// internal objects are listed too and filtered out below,
+	// keys of uploaded files may sort before and after them
+	list, err := h.s3Adapter.List(ctx.Context(), listQuery)
+	if err != nil {
+		log.Error("[DownloadFileHandler] Failed list objects: ", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_LIST"))
+	}
+
+	resp := dtos.ListFilesResponse{
+		Files:  make([]dtos.FileInfo, 0, len(list.Objects)),
+		Cursor: list.Cursor,
+	}
+	for _, obj := range list.Objects {
+		if isFileKey(obj.Key) {
+			resp.Files = append(resp.Files, dtos.FileInfo{
+				Key:          obj.Key,
+				Size:         obj.ContentLength,
+				ContentType:  obj.ContentType,
+				LastModified: obj.LastModified,
+			})
+		}
+	}
+	for _, prefix := range list.Prefixes {
+		if isFileKey(prefix) {
+			resp.Prefixes = append(resp.Prefixes, prefix)
+		}
+	}
+
+	if query.Stat {
+		h.statFiles(ctx.Context(), resp.Files)
+	}
+	for i := range resp.Files {
+		resp.Files[i].Url = h.fileURL(resp.Files[i].Key, resp.Files[i].ContentType)
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(dtos.SuccessResponse(resp))
+}
+
+// statFiles fills content types listing doesn't return
+func (h *DownloadFileHandler) statFiles(ctx context.Context, files []dtos.FileInfo) {
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, statConcurrency)
+
+	for i := range files {
+		if files[i].ContentType != "" {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(file *dtos.FileInfo) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			stat, err := h.s3Adapter.Stat(ctx, &adapters.S3Obj{Key: file.Key})
+			if err != nil {
+				log.Error("[DownloadFileHandler] Failed stat: ", err)
+				return
+			}
+			file.ContentType = stat.ContentType
+		}(&files[i])
+	}
+
+	wg.Wait()
+}
+
+// fileURL is where GET /api/v1/upload/<key> without query leads, files that
+// aren't images or of unknown type get URL of GET as presigned URLs expire
+func (h *DownloadFileHandler) fileURL(key, contentType string) string {
+	if h.resizerConfig.IsNative() || !isImage(contentType) {
+		return h.appConfig.BaseURL + "/" + url.PathEscape(key)
+	}
+
+	URL, err := h.buildURL(key, &dtos.DownloadFileQuery{})
+	if err != nil {
+		return ""
 	}
 
-	URL := h.buildURL(key, query)
+	return h.imgProxyConfig.BaseURL + URL
+}
+
+// buildURL makes signed imgproxy path of the file
+func (h *DownloadFileHandler) buildURL(key string, params *dtos.DownloadFileQuery) (string, error) {
+	opts, err := h.presets.Get(params.Size)
+	if err != nil {
+		return "", err
+	}
 
-	ctx.Redirect(h.imgProxyConfig.BaseURL + "/" + URL)
+	if params.Format != "" {
+		opts.Format = params.Format
+	}
 
-	return nil
+	return h.urlBuilder.Path(opts, h.sourceURL(key)), nil
 }
 
-func (h *DownloadFileHandler) buildURL(key string, params *dtos.DownloadFileQuery) string {
-	region := "@bucket" // TODO: use region here
+// serveNative processes the file in-process with preset options,
+// limits are checked first as direct and tus uploads skip them
+func (h *DownloadFileHandler) serveNative(ctx *fiber.Ctx, stat *adapters.S3Obj, params *dtos.DownloadFileQuery) error {
+	opts, err := h.presets.Get(params.Size)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_UNKNOWN_PRESET"))
+	}
+
+	if params.Format != "" {
+		opts.Format = params.Format
+	}
+
+	if err := h.limits.CheckSize(stat.ContentLength); err != nil {
+		return limitErr(ctx, stat.Key, err)
+	}
+
+	body, err := h.s3Adapter.GetObj(ctx.Context(), &adapters.S3Obj{
+		Bucket: h.s3Config.Bucket,
+		Key:    stat.Key,
+	})
+	if err != nil {
+		if errors.Is(err, adapters.ErrNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(dtos.ErrResponse("ERR_NOT_FOUND"))
+		}
+		log.Error("[DownloadFileHandler] Failed get object: ", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_DOWNLOAD"))
+	}
+	defer body.Close()
+
+	// the object may have grown since stat
+	var src io.Reader = body
+	if h.limits.MaxFileSize > 0 {
+		src = io.LimitReader(body, h.limits.MaxFileSize+1)
+	}
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		log.Error("[DownloadFileHandler] Failed read object: ", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_DOWNLOAD"))
+	}
+	if err := h.limits.CheckSize(int64(len(data))); err != nil {
+		return limitErr(ctx, stat.Key, err)
+	}
 
-	size := Sizes[params.Size]
+	if err := h.limits.CheckImage(bytes.NewReader(data)); err != nil {
+		return limitErr(ctx, stat.Key, err)
+	}
+
+	result, err := h.resizer.Process(bytes.NewReader(data), opts)
+	if err != nil {
+		if errors.Is(err, resizer.ErrUnsupportedImage) {
+			return ctx.Status(fiber.StatusUnprocessableEntity).JSON(dtos.ErrResponse("ERR_NOT_IMAGE"))
+		}
+		log.Error("[DownloadFileHandler] Failed process image: ", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_PROCESS"))
+	}
+
+	ctx.Set(fiber.HeaderContentType, result.ContentType)
+
+	return ctx.Send(result.Bytes)
+}
+
+// variantETag is weak etag of the original processed with the query,
+// encoding of the same image may differ byte to byte
+func variantETag(etag string, params *dtos.DownloadFileQuery) string {
+	if etag == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(etag + "\x00" + params.Size + "\x00" + params.Format))
+
+	return fmt.Sprintf(`W/"%x"`, sum[:16])
+}
+
+// serveFile sends file that isn't an image: redirects to presigned URL,
+// or streams it honoring single range Range and If-Range. HEAD gets
+// headers of the stream without reading it
+func (h *DownloadFileHandler) serveFile(ctx *fiber.Ctx, stat *adapters.S3Obj, head bool) error {
+	if !h.storageConfig.IsStream() {
+		link, err := h.s3Adapter.GetPresign(ctx.Context(), &adapters.S3Obj{Key: stat.Key})
+		if err != nil {
+			log.Error("[DownloadFileHandler] Failed presign: ", err)
+			return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_PRESIGN"))
+		}
+
+		return ctx.Redirect(*link)
+	}
+
+	ctx.Set(fiber.HeaderAcceptRanges, "bytes")
+
+	start, length, partial := int64(0), stat.ContentLength, false
+	if ifRangeMatches(ctx, stat) {
+		var err error
+		start, length, partial, err = parseRange(ctx.Get(fiber.HeaderRange), stat.ContentLength)
+		if err != nil {
+			ctx.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", stat.ContentLength))
+			return ctx.Status(fiber.StatusRequestedRangeNotSatisfiable).JSON(dtos.ErrResponse("ERR_RANGE"))
+		}
+	}
+
+	if !partial {
+		start, length = 0, stat.ContentLength
+	}
+
+	ctx.Set(fiber.HeaderContentType, stat.ContentType)
+
+	status := fiber.StatusOK
+	if partial {
+		status = fiber.StatusPartialContent
+		ctx.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, stat.ContentLength))
+	}
+
+	// SendStatus would set status text as the body
+	if head {
+		ctx.Response().Header.SetContentLength(int(length))
+		ctx.Status(status)
+		return nil
+	}
+
+	var body io.ReadCloser
+	var err error
+	if partial {
+		body, err = h.s3Adapter.GetRange(ctx.Context(), &adapters.S3Obj{Key: stat.Key}, start, length)
+	} else {
+		body, err = h.s3Adapter.GetObj(ctx.Context(), &adapters.S3Obj{Key: stat.Key})
+	}
+	if err != nil {
+		if errors.Is(err, adapters.ErrNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(dtos.ErrResponse("ERR_NOT_FOUND"))
+		}
+		log.Error("[DownloadFileHandler] Failed get object: ", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_DOWNLOAD"))
+	}
+
+	// fasthttp closes body once it's sent
+	return ctx.Status(status).SendStream(body, int(length))
+}
 
-	if size == "" {
-		size = Sizes["default"]
+// sourceURL points imgproxy to the file in storage
+func (h *DownloadFileHandler) sourceURL(key string) string {
+	// local files are served by imgproxy from <root>/<bucket>
+	if h.storageConfig.IsLocal() {
+		return "local:///" + h.s3Config.Bucket + "/" + key
 	}
 
-	return region + "/" + size + "/" + key
+	return "s3://" + h.s3Config.Bucket + "/" + key
 }
 
 func (h *DownloadFileHandler) parseQuery(ctx *fiber.Ctx) (*dtos.DownloadFileQuery, error) {
@@ -0,0 +1,567 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/cas"
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/WildEgor/gImageResizer/internal/dtos"
+	"github.com/WildEgor/gImageResizer/internal/imgproxy"
+	"github.com/WildEgor/gImageResizer/internal/policy"
+	"github.com/WildEgor/gImageResizer/internal/resizer"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestDownloadFileRedirectsImage(t *testing.T) {
+	s := newTestServer(t)
+
+	var files []dtos.UploadFilesResponse
+	s.do(t, uploadRequest(t, map[string][]byte{"a.png": testPNG(t, 32, 24)}), &files)
+	if len(files) != 1 {
+		t.Fatalf("upload failed: %+v", files)
+	}
+	key := strings.TrimPrefix(files[0].Url, testBaseURL+"/")
+
+	resp, _ := s.do(t, httpRequest(http.MethodGet, "/api/v1/upload/"+key+"?size=_small&format=webp"), nil)
+	if resp.StatusCode != fiber.StatusFound {
+		t.Fatalf("got status %d, want 302", resp.StatusCode)
+	}
+
+	location := resp.Header.Get(fiber.HeaderLocation)
+	if !strings.HasPrefix(location, "http://imgproxy/insecure/") {
+		t.Errorf("got location %q", location)
+	}
+	if !strings.HasSuffix(location, "/plain/s3://test/"+key+"@webp") {
+		t.Errorf("location %q isn't webp of s3://test/%s", location, key)
+	}
+	if resp.Header.Get(fiber.HeaderETag) == "" {
+		t.Errorf("no ETag of the original")
+	}
+
+	if presigns := s.storage.Presigns(); len(presigns) != 0 {
+		t.Errorf("images aren't presigned: %+v", presigns)
+	}
+}
+
+func TestDownloadFilePresignsOtherFiles(t *testing.T) {
+	s := newTestServer(t)
+
+	err := s.storage.PutObj(context.Background(), &adapters.S3Obj{
+		Key:         "doc.pdf",
+		Bytes:       []byte("%PDF-1.4\n"),
+		ContentType: "application/pdf",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, _ := s.do(t, httpRequest(http.MethodGet, "/api/v1/upload/doc.pdf"), nil)
+	if resp.StatusCode != fiber.StatusFound {
+		t.Fatalf("got status %d, want 302", resp.StatusCode)
+	}
+	if location := resp.Header.Get(fiber.HeaderLocation); location != "memory://test/doc.pdf" {
+		t.Errorf("got location %q", location)
+	}
+
+	presigns := s.storage.Presigns()
+	if len(presigns) != 1 || presigns[0].Key != "doc.pdf" || presigns[0].Bucket != "test" {
+		t.Errorf("got presigns %+v", presigns)
+	}
+}
+
+func TestDownloadFileErrors(t *testing.T) {
+	s := newTestServer(t)
+
+	s.storage.PutObj(context.Background(), &adapters.S3Obj{
+		Key:         "a.png",
+		Bytes:       testPNG(t, 8, 8),
+		ContentType: "image/png",
+	})
+
+	tests := []struct {
+		name    string
+		target  string
+		status  int
+		message string
+	}{
+		{"missing", "/api/v1/upload/" + url.PathEscape("no such.png"), fiber.StatusNotFound, "ERR_NOT_FOUND"},
+		{"format", "/api/v1/upload/a.png?format=bmp", fiber.StatusBadRequest, "ERR_FORMAT"},
+		{"preset", "/api/v1/upload/a.png?size=_huge", fiber.StatusBadRequest, "ERR_UNKNOWN_PRESET"},
+	}
+
+	for _, tt := range tests {
+		resp, message := s.do(t, httpRequest(http.MethodGet, tt.target), nil)
+		if resp.StatusCode != tt.status || message != tt.message {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, resp.StatusCode, message, tt.status, tt.message)
+		}
+	}
+}
+
+// newDownloadTestApp runs GET and HEAD of files with engine and download mode on MemoryAdapter
+func newDownloadTestApp(
+	t *testing.T,
+	engine, downloadMode string,
+	appConfig *configs.AppConfig,
+	uploadConfig *configs.UploadConfig,
+) *testServer {
+	t.Helper()
+
+	s3Config := &configs.S3Config{Bucket: "test"}
+	resizerConfig := &configs.ResizerConfig{Engine: engine, Filter: "lanczos"}
+	imgProxyConfig := &configs.ImgProxyConfig{BaseURL: "http://imgproxy", DefaultPreset: "medium"}
+
+	storage := adapters.NewMemoryAdapter(s3Config)
+	downloadFile := NewDownloadFileHandler(
+		imgProxyConfig, appConfig, &configs.StorageConfig{Driver: "s3", DownloadMode: downloadMode}, s3Config, resizerConfig,
+		storage, imgproxy.NewPresets(imgProxyConfig), resizer.NewResizer(resizerConfig), policy.NewLimits(appConfig, uploadConfig),
+		cas.NewStore(uploadConfig, storage),
+	)
+
+	app := fiber.New()
+	app.Head("/api/v1/upload/:key", downloadFile.Head)
+	app.Get("/api/v1/upload/:key", downloadFile.Handle)
+
+	return &testServer{app: app, storage: storage}
+}
+
+func newNativeTestApp(t *testing.T, appConfig *configs.AppConfig, uploadConfig *configs.UploadConfig) *testServer {
+	t.Helper()
+
+	return newDownloadTestApp(t, "native", "presign", appConfig, uploadConfig)
+}
+
+func TestDownloadFileNative(t *testing.T) {
+	s := newNativeTestApp(t, &configs.AppConfig{}, &configs.UploadConfig{})
+	storage := s.storage
+
+	storage.PutObj(context.Background(), &adapters.S3Obj{
+		Key:         "a.png",
+		Bytes:       testPNG(t, 32, 24),
+		ContentType: "image/png",
+	})
+	stat, _ := storage.Stat(context.Background(), &adapters.S3Obj{Key: "a.png"})
+
+	resp, message := s.do(t, httpRequest(http.MethodGet, "/api/v1/upload/a.png?size=_small"), nil)
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("got %d %q, want 200", resp.StatusCode, message)
+	}
+
+	small := resp.Header.Get(fiber.HeaderETag)
+	if !strings.HasPrefix(small, `W/"`) || small == stat.ETag {
+		t.Errorf("processed image got etag %q, original has %q", small, stat.ETag)
+	}
+
+	resp, _ = s.do(t, httpRequest(http.MethodGet, "/api/v1/upload/a.png?size=_thumb"), nil)
+	if thumb := resp.Header.Get(fiber.HeaderETag); thumb == small {
+		t.Errorf("presets share etag %q", thumb)
+	}
+
+	req := httpRequest(http.MethodGet, "/api/v1/upload/a.png?size=_small")
+	req.Header.Set(fiber.HeaderIfNoneMatch, small)
+	if resp, _ = s.do(t, req, nil); resp.StatusCode != fiber.StatusNotModified {
+		t.Errorf("got status %d with etag of the variant, want 304", resp.StatusCode)
+	}
+
+	req = httpRequest(http.MethodGet, "/api/v1/upload/a.png?size=_small")
+	req.Header.Set(fiber.HeaderIfNoneMatch, stat.ETag)
+	if resp, _ = s.do(t, req, nil); resp.StatusCode != fiber.StatusOK {
+		t.Errorf("got status %d with etag of the original, want 200", resp.StatusCode)
+	}
+}
+
+func TestDownloadFileNativeLimits(t *testing.T) {
+	s := newNativeTestApp(t, &configs.AppConfig{}, &configs.UploadConfig{MaxWidth: 16})
+	storage := s.storage
+
+	// direct uploads skip limits, so the file gets to the bucket as is
+	storage.PutObj(context.Background(), &adapters.S3Obj{
+		Key:         "wide.png",
+		Bytes:       testPNG(t, 32, 8),
+		ContentType: "image/png",
+	})
+
+	resp, message := s.do(t, httpRequest(http.MethodGet, "/api/v1/upload/wide.png"), nil)
+	if resp.StatusCode != fiber.StatusUnprocessableEntity || message != "ERR_IMAGE_WIDTH" {
+		t.Errorf("got %d %q, want 422 ERR_IMAGE_WIDTH", resp.StatusCode, message)
+	}
+}
+
+func TestDownloadFileNativeFileSize(t *testing.T) {
+	// APP_MAX_FILE_SIZE applies to images of any upload
+	s := newNativeTestApp(t, &configs.AppConfig{MaxFileSize: 16}, &configs.UploadConfig{})
+	storage := s.storage
+
+	storage.PutObj(context.Background(), &adapters.S3Obj{
+		Key:         "a.png",
+		Bytes:       testPNG(t, 8, 8),
+		ContentType: "image/png",
+	})
+
+	resp, message := s.do(t, httpRequest(http.MethodGet, "/api/v1/upload/a.png"), nil)
+	if resp.StatusCode != fiber.StatusRequestEntityTooLarge || message != "ERR_IMAGE_FILE_SIZE" {
+		t.Errorf("got %d %q, want 413 ERR_IMAGE_FILE_SIZE", resp.StatusCode, message)
+	}
+}
+
+func TestListFilesSkipsInternalKeys(t *testing.T) {
+	s := newTestServer(t)
+
+	// "!" and "-" sort before internal "." keys
+	for _, key := range []string{"!a", "-foo", ".meta/b.png.json", ".tus/x.info", "_small/b.png", "b.png"} {
+		s.storage.PutObj(context.Background(), &adapters.S3Obj{
+			Key:         key,
+			Bytes:       []byte("x"),
+			ContentType: "application/octet-stream",
+		})
+	}
+
+	var list dtos.ListFilesResponse
+	resp, _ := s.do(t, httpRequest(http.MethodGet, "/api/v1/upload/"), &list)
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+
+	keys := make([]string, 0, len(list.Files))
+	for _, file := range list.Files {
+		keys = append(keys, file.Key)
+	}
+	if got := strings.Join(keys, ","); got != "!a,-foo,b.png" {
+		t.Errorf("listed %q, want !a,-foo,b.png", got)
+	}
+}
+
+func listFiles(t *testing.T, s *testServer, query string) dtos.ListFilesResponse {
+	t.Helper()
+
+	var list dtos.ListFilesResponse
+	resp, message := s.do(t, httpRequest(http.MethodGet, "/api/v1/upload/?"+query), &list)
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("%s: got %d %q", query, resp.StatusCode, message)
+	}
+
+	return list
+}
+
+func listedKeys(list dtos.ListFilesResponse) string {
+	keys := make([]string, 0, len(list.Files))
+	for _, file := range list.Files {
+		keys = append(keys, file.Key)
+	}
+
+	return strings.Join(keys, ",")
+}
+
+func TestListFilesPages(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		s.storage.PutObj(context.Background(), &adapters.S3Obj{Key: key, Bytes: []byte("x"), ContentType: "text/plain"})
+	}
+
+	var pages []string
+	cursor := ""
+	for i := 0; i < 5; i++ {
+		list := listFiles(t, s, "limit=2&cursor="+url.QueryEscape(cursor))
+		pages = append(pages, listedKeys(list))
+		if cursor = list.Cursor; cursor == "" {
+			break
+		}
+	}
+
+	if got := strings.Join(pages, "|"); got != "a,b|c,d|e" {
+		t.Errorf("got pages %q, want a,b|c,d|e", got)
+	}
+
+	for _, query := range []string{"limit=-1", "limit=1001", "limit=x"} {
+		resp, message := s.do(t, httpRequest(http.MethodGet, "/api/v1/upload/?"+query), nil)
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("%s: got %d %q, want 400", query, resp.StatusCode, message)
+		}
+	}
+}
+
+func TestListFilesDelimiter(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, key := range []string{".meta/a/b.png.json", "_small/a/b.png", "a/b.png", "a/c/d.png", "e/f.png", "g.png"} {
+		s.storage.PutObj(context.Background(), &adapters.S3Obj{Key: key, Bytes: []byte("x"), ContentType: "image/png"})
+	}
+
+	tests := []struct {
+		query    string
+		files    string
+		prefixes string
+	}{
+		// internal prefixes are skipped
+		{"delimiter=/", "g.png", "a/,e/"},
+		{"delimiter=/&prefix=a/", "a/b.png", "a/c/"},
+		{"delimiter=/&prefix=a/c/", "a/c/d.png", ""},
+		{"prefix=a/", "a/b.png,a/c/d.png", ""},
+	}
+
+	for _, tt := range tests {
+		list := listFiles(t, s, tt.query)
+		if got := listedKeys(list); got != tt.files {
+			t.Errorf("%s: got files %q, want %q", tt.query, got, tt.files)
+		}
+		if got := strings.Join(list.Prefixes, ","); got != tt.prefixes {
+			t.Errorf("%s: got prefixes %q, want %q", tt.query, got, tt.prefixes)
+		}
+	}
+
+	// internal prefixes take places on pages too
+	var files, prefixes []string
+	cursor := ""
+	for i := 0; i < 5; i++ {
+		list := listFiles(t, s, "delimiter=/&limit=2&cursor="+url.QueryEscape(cursor))
+		if n := len(list.Files) + len(list.Prefixes); n > 2 {
+			t.Errorf("got %d items on a page of 2", n)
+		}
+		files = append(files, listedKeys(list))
+		prefixes = append(prefixes, list.Prefixes...)
+		if cursor = list.Cursor; cursor == "" {
+			break
+		}
+	}
+	if got := strings.Join(prefixes, ","); got != "a/,e/" {
+		t.Errorf("got prefixes %q of all pages, want a/,e/", got)
+	}
+	if got := strings.Join(files, ""); got != "g.png" {
+		t.Errorf("got files %q of all pages, want g.png", got)
+	}
+}
+
+// typelessListing lists objects without content type like S3 and counts stats
+type typelessListing struct {
+	*adapters.MemoryAdapter
+	stats *int
+}
+
+func (l typelessListing) List(ctx context.Context, query *adapters.S3ListQuery) (*adapters.S3List, error) {
+	list, err := l.MemoryAdapter.List(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list.Objects {
+		list.Objects[i].ContentType = ""
+	}
+
+	return list, nil
+}
+
+func (l typelessListing) Stat(ctx context.Context, obj *adapters.S3Obj) (*adapters.S3Obj, error) {
+	*l.stats++
+	return l.MemoryAdapter.Stat(ctx, obj)
+}
+
+func TestListFilesURLs(t *testing.T) {
+	stats := 0
+	s := newWrappedTestServer(t, func(m *adapters.MemoryAdapter) adapters.IS3Adapter {
+		return typelessListing{m, &stats}
+	})
+	putTestFiles(t, s)
+
+	// without types every file gets URL of GET, files aren't stat
+	list := listFiles(t, s, "")
+	for _, file := range list.Files {
+		if file.ContentType != "" || file.Url != testBaseURL+"/"+file.Key {
+			t.Errorf("got %+v, want no type and url of GET", file)
+		}
+	}
+	if stats != 0 {
+		t.Errorf("got %d stats, want none", stats)
+	}
+
+	// image gets imgproxy URL, PDF isn't sent to imgproxy
+	list = listFiles(t, s, "stat=true")
+	if len(list.Files) != 2 || stats != 2 {
+		t.Fatalf("got %+v with %d stats", list.Files, stats)
+	}
+	image, doc := list.Files[0], list.Files[1]
+	if image.ContentType != "image/png" || !strings.HasPrefix(image.Url, "http://imgproxy/") || !strings.HasSuffix(image.Url, "/plain/s3://test/a.png") {
+		t.Errorf("got image %+v", image)
+	}
+	if doc.ContentType != "application/pdf" || doc.Url != testBaseURL+"/doc.pdf" {
+		t.Errorf("got doc %+v", doc)
+	}
+	if presigns := s.storage.Presigns(); len(presigns) != 0 {
+		t.Errorf("listing presigned %+v", presigns)
+	}
+}
+
+// send runs request without reading the body, HEAD responses have none
+func (s *testServer) send(t *testing.T, req *http.Request) *http.Response {
+	t.Helper()
+
+	resp, err := s.app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.Method, req.URL, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func putTestFiles(t *testing.T, s *testServer) {
+	t.Helper()
+
+	s.storage.PutObj(context.Background(), &adapters.S3Obj{Key: "a.png", Bytes: testPNG(t, 32, 24), ContentType: "image/png"})
+	s.storage.PutObj(context.Background(), &adapters.S3Obj{Key: "doc.pdf", Bytes: []byte("%PDF-1.4\n"), ContentType: "application/pdf"})
+}
+
+func TestHeadFileMatchesGet(t *testing.T) {
+	tests := []struct {
+		engine, mode, target string
+		status               int
+	}{
+		{"imgproxy", "presign", "/api/v1/upload/a.png?size=_small", fiber.StatusFound},
+		{"native", "presign", "/api/v1/upload/a.png?size=_small&format=png", fiber.StatusOK},
+		{"imgproxy", "presign", "/api/v1/upload/doc.pdf", fiber.StatusFound},
+		{"imgproxy", "stream", "/api/v1/upload/doc.pdf", fiber.StatusOK},
+		{"imgproxy", "presign", "/api/v1/upload/missing.png", fiber.StatusNotFound},
+	}
+
+	headers := []string{
+		fiber.HeaderETag, fiber.HeaderLastModified, fiber.HeaderLocation,
+		fiber.HeaderContentType, fiber.HeaderContentLength, fiber.HeaderAcceptRanges,
+	}
+
+	for _, tt := range tests {
+		s := newDownloadTestApp(t, tt.engine, tt.mode, &configs.AppConfig{}, &configs.UploadConfig{})
+		putTestFiles(t, s)
+
+		get := s.send(t, httpRequest(http.MethodGet, tt.target))
+		head := s.send(t, httpRequest(http.MethodHead, tt.target))
+
+		if get.StatusCode != tt.status || head.StatusCode != tt.status {
+			t.Errorf("%s %s %s: got GET %d, HEAD %d, want %d", tt.engine, tt.mode, tt.target, get.StatusCode, head.StatusCode, tt.status)
+			continue
+		}
+		for _, header := range headers {
+			// bodies of redirects and errors differ, HEAD has none
+			if header == fiber.HeaderContentLength && tt.status != fiber.StatusOK {
+				continue
+			}
+			if got, want := head.Header.Get(header), get.Header.Get(header); got != want {
+				t.Errorf("%s %s %s: HEAD got %s %q, GET has %q", tt.engine, tt.mode, tt.target, header, got, want)
+			}
+		}
+	}
+}
+
+func TestDownloadFileConditional(t *testing.T) {
+	tests := []struct {
+		engine, target string
+		status         int
+	}{
+		{"imgproxy", "/api/v1/upload/a.png", fiber.StatusFound},
+		{"native", "/api/v1/upload/a.png?size=_small", fiber.StatusOK},
+		{"imgproxy", "/api/v1/upload/doc.pdf", fiber.StatusFound},
+	}
+
+	for _, tt := range tests {
+		s := newDownloadTestApp(t, tt.engine, "presign", &configs.AppConfig{}, &configs.UploadConfig{})
+		putTestFiles(t, s)
+
+		first := s.send(t, httpRequest(http.MethodGet, tt.target))
+		etag, modified := first.Header.Get(fiber.HeaderETag), first.Header.Get(fiber.HeaderLastModified)
+		if etag == "" || modified == "" {
+			t.Fatalf("%s %s: no validators", tt.engine, tt.target)
+		}
+		lastModified, _ := http.ParseTime(modified)
+
+		conditions := []struct {
+			header, value string
+			status        int
+		}{
+			{fiber.HeaderIfNoneMatch, etag, fiber.StatusNotModified},
+			{fiber.HeaderIfNoneMatch, `"other", ` + etag, fiber.StatusNotModified},
+			{fiber.HeaderIfNoneMatch, `"other"`, tt.status},
+			{fiber.HeaderIfModifiedSince, modified, fiber.StatusNotModified},
+			{fiber.HeaderIfModifiedSince, lastModified.Add(-time.Hour).Format(http.TimeFormat), tt.status},
+		}
+
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			for _, c := range conditions {
+				req := httpRequest(method, tt.target)
+				req.Header.Set(c.header, c.value)
+				if resp := s.send(t, req); resp.StatusCode != c.status {
+					t.Errorf("%s %s %s with %s %q: got %d, want %d", tt.engine, method, tt.target, c.header, c.value, resp.StatusCode, c.status)
+				}
+			}
+		}
+	}
+}
+
+func TestDownloadFileStreamsRanges(t *testing.T) {
+	s := newDownloadTestApp(t, "imgproxy", "stream", &configs.AppConfig{}, &configs.UploadConfig{})
+
+	data := "0123456789abcdef"
+	s.storage.PutObj(context.Background(), &adapters.S3Obj{Key: "video.mp4", Bytes: []byte(data), ContentType: "video/mp4"})
+	stat, _ := s.storage.Stat(context.Background(), &adapters.S3Obj{Key: "video.mp4"})
+	modified := stat.LastModified.UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name         string
+		headers      map[string]string
+		status       int
+		contentRange string
+		body         string
+	}{
+		{"whole", nil, fiber.StatusOK, "", data},
+		{"range", map[string]string{"Range": "bytes=2-5"}, fiber.StatusPartialContent, "bytes 2-5/16", "2345"},
+		{"open range", map[string]string{"Range": "bytes=10-"}, fiber.StatusPartialContent, "bytes 10-15/16", "abcdef"},
+		{"suffix", map[string]string{"Range": "bytes=-4"}, fiber.StatusPartialContent, "bytes 12-15/16", "cdef"},
+		{"past the end", map[string]string{"Range": "bytes=8-100"}, fiber.StatusPartialContent, "bytes 8-15/16", "89abcdef"},
+		{"unsatisfiable", map[string]string{"Range": "bytes=16-"}, fiber.StatusRequestedRangeNotSatisfiable, "bytes */16", ""},
+		{"multi-range", map[string]string{"Range": "bytes=0-1,4-5"}, fiber.StatusOK, "", data},
+		{"malformed", map[string]string{"Range": "bytes=5-2"}, fiber.StatusOK, "", data},
+		{"if-range etag", map[string]string{"Range": "bytes=0-3", "If-Range": stat.ETag}, fiber.StatusPartialContent, "bytes 0-3/16", "0123"},
+		{"if-range stale etag", map[string]string{"Range": "bytes=0-3", "If-Range": `"stale"`}, fiber.StatusOK, "", data},
+		{"if-range weak etag", map[string]string{"Range": "bytes=0-3", "If-Range": "W/" + stat.ETag}, fiber.StatusOK, "", data},
+		{"if-range date", map[string]string{"Range": "bytes=0-3", "If-Range": modified}, fiber.StatusPartialContent, "bytes 0-3/16", "0123"},
+		{"if-range stale date", map[string]string{"Range": "bytes=0-3", "If-Range": stat.LastModified.Add(-time.Hour).UTC().Format(http.TimeFormat)}, fiber.StatusOK, "", data},
+	}
+
+	for _, tt := range tests {
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			req := httpRequest(method, "/api/v1/upload/video.mp4")
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			resp := s.send(t, req)
+			if resp.StatusCode != tt.status {
+				t.Errorf("%s %s: got status %d, want %d", method, tt.name, resp.StatusCode, tt.status)
+				continue
+			}
+			if got := resp.Header.Get(fiber.HeaderContentRange); got != tt.contentRange {
+				t.Errorf("%s %s: got Content-Range %q, want %q", method, tt.name, got, tt.contentRange)
+			}
+			if resp.Header.Get(fiber.HeaderAcceptRanges) != "bytes" {
+				t.Errorf("%s %s: no Accept-Ranges", method, tt.name)
+			}
+			if tt.status == fiber.StatusRequestedRangeNotSatisfiable {
+				continue
+			}
+
+			if got := resp.Header.Get(fiber.HeaderContentLength); got != strconv.Itoa(len(tt.body)) {
+				t.Errorf("%s %s: got Content-Length %s, want %d", method, tt.name, got, len(tt.body))
+			}
+			if method == http.MethodGet {
+				body, _ := io.ReadAll(resp.Body)
+				if string(body) != tt.body {
+					t.Errorf("%s %s: got body %q, want %q", method, tt.name, body, tt.body)
+				}
+			}
+		}
+	}
+}
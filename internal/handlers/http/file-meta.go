@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/cas"
+	"github.com/WildEgor/gImageResizer/internal/dtos"
+	"github.com/WildEgor/gImageResizer/internal/jobs"
+	"github.com/gofiber/fiber/v2"
+)
+
+type FileMetaHandler struct {
+	tasks *jobs.Tasks
+	store *cas.Store
+}
+
+func NewFileMetaHandler(
+	tasks *jobs.Tasks,
+	store *cas.Store,
+) *FileMetaHandler {
+	return &FileMetaHandler{
+		tasks: tasks,
+		store: store,
+	}
+}
+
+// FileMeta godoc
+//
+//	@Summary		Get file metadata
+//	@Description	Returns size, dimensions, format, orientation, color model, frames, EXIF and placeholders of the file
+//	@Tags			upload
+//	@Produce		json
+//	@Param			key	path	string	true	"File key"
+//	@Router			/api/v1/upload/{key}/meta [get]
+func (h *FileMetaHandler) Handle(ctx *fiber.Ctx) error {
+	key, err := url.PathUnescape(ctx.Params("key", ""))
+	if err != nil || key == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_EMPTY_KEY"))
+	}
+
+	// metadata of sidecars, variants and upload parts isn't extracted
+	if !isFileKey(key) {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_KEY"))
+	}
+
+	key, err = h.store.Resolve(ctx.Context(), key)
+	if err != nil {
+		log.Error("[FileMetaHandler] Failed load alias: ", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_METADATA"))
+	}
+
+	meta, err := h.tasks.LoadMetadata(ctx.Context(), key)
+	if errors.Is(err, adapters.ErrNotFound) {
+		// metadata job isn't done yet or file was uploaded before it existed
+		meta, err = h.tasks.ExtractMetadata(ctx.Context(), key)
+	}
+	if err != nil {
+		if errors.Is(err, adapters.ErrNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(dtos.ErrResponse("ERR_NOT_FOUND"))
+		}
+		log.Error("[FileMetaHandler] Failed get metadata: ", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_METADATA"))
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(dtos.SuccessResponse(meta))
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/cas"
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/WildEgor/gImageResizer/internal/imgproxy"
+	"github.com/WildEgor/gImageResizer/internal/jobs"
+	"github.com/WildEgor/gImageResizer/internal/metadata"
+	"github.com/WildEgor/gImageResizer/internal/policy"
+	"github.com/WildEgor/gImageResizer/internal/resizer"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestFileMetaRejectsInternalKeys(t *testing.T) {
+	s3Config := &configs.S3Config{Bucket: "test"}
+	resizerConfig := &configs.ResizerConfig{Engine: "imgproxy", Filter: "lanczos"}
+	storage := adapters.NewMemoryAdapter(s3Config)
+	tasks := jobs.NewTasks(
+		s3Config, resizerConfig, storage, imgproxy.NewPresets(&configs.ImgProxyConfig{DefaultPreset: "medium"}),
+		resizer.NewResizer(resizerConfig), policy.NewLimits(&configs.AppConfig{}, &configs.UploadConfig{}),
+	)
+
+	app := fiber.New()
+	app.Get("/api/v1/upload/:key/meta", NewFileMetaHandler(tasks, cas.NewStore(&configs.UploadConfig{}, storage)).Handle)
+	s := &testServer{app: app, storage: storage}
+
+	storage.PutObj(context.Background(), &adapters.S3Obj{
+		Key:         "a.png",
+		Bytes:       testPNG(t, 8, 8),
+		ContentType: "image/png",
+	})
+
+	resp, _ := s.do(t, httpRequest(http.MethodGet, "/api/v1/upload/a.png/meta"), nil)
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+
+	for _, key := range []string{metadata.Key("a.png"), "_small/a.png"} {
+		resp, message := s.do(t, httpRequest(http.MethodGet, "/api/v1/upload/"+url.PathEscape(key)+"/meta"), nil)
+		if resp.StatusCode != fiber.StatusBadRequest || message != "ERR_KEY" {
+			t.Errorf("%s: got %d %q, want 400 ERR_KEY", key, resp.StatusCode, message)
+		}
+	}
+}
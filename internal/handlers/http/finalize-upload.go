@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/cas"
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/WildEgor/gImageResizer/internal/dtos"
+	"github.com/WildEgor/gImageResizer/internal/jobs"
+	"github.com/WildEgor/gImageResizer/internal/policy"
+	"github.com/gofiber/fiber/v2"
+	uuid "github.com/google/uuid"
+)
+
+type FinalizeUploadHandler struct {
+	appConfig     *configs.AppConfig
+	resizerConfig *configs.ResizerConfig
+	uploadConfig  *configs.UploadConfig
+	s3Adapter     adapters.IS3Adapter
+	queue         *jobs.Queue
+	policies      *policy.Policies
+	limits        *policy.Limits
+}
+
+func NewFinalizeUploadHandler(
+	appConfig *configs.AppConfig,
+	resizerConfig *configs.ResizerConfig,
+	uploadConfig *configs.UploadConfig,
+	s3Adapter adapters.IS3Adapter,
+	queue *jobs.Queue,
+	policies *policy.Policies,
+	limits *policy.Limits,
+) *FinalizeUploadHandler {
+	return &FinalizeUploadHandler{
+		appConfig:     appConfig,
+		resizerConfig: resizerConfig,
+		uploadConfig:  uploadConfig,
+		s3Adapter:     s3Adapter,
+		queue:         queue,
+		policies:      policies,
+		limits:        limits,
+	}
+}
+
+// FinalizeUpload godoc
+//
+//	@Summary		Finalize direct upload
+//	@Description	Checks files uploaded by presigned URLs exist and have allowed content,
+//	@Description	files violating upload policy or limits are deleted. Enqueues jobs of the rest.
+//	@Description	Keys must be issued by /api/v1/upload/presign to the same tenant, finalized keys get
+//	@Description	the same response again until their URL expires plus an hour, so failed batches may be retried
+//	@Tags			upload
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body	dtos.FinalizeUploadRequest	true	"Keys"
+//	@Router			/api/v1/upload/finalize [post]
+func (h *FinalizeUploadHandler) Handle(ctx *fiber.Ctx) error {
+	var req dtos.FinalizeUploadRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_BODY"))
+	}
+
+	if len(req.Keys) == 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_EMPTY_KEYS"))
+	}
+
+	tenant := ctx.Get(h.uploadConfig.TenantHeader)
+	filePolicy := h.policies.For(policy.RoutePresign, tenant)
+
+	// all keys are checked before any file is touched
+	records := make([]*presignRecord, len(req.Keys))
+	for i, key := range req.Keys {
+		if key == "" {
+			return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_EMPTY_KEY"))
+		}
+
+		if !isFileKey(key) || cas.IsKey(key) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_KEY"))
+		}
+
+		record, err := h.issued(ctx.Context(), key, tenant)
+		if err != nil {
+			log.Errorf("[FinalizeUploadHandler] Failed load record %v", err)
+			return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_STAT"))
+		}
+		if record == nil {
+			return ctx.Status(fiber.StatusForbidden).JSON(dtos.ErrResponse("ERR_KEY_NOT_ISSUED"))
+		}
+		records[i] = record
+	}
+
+	result := make([]dtos.UploadFilesResponse, 0, len(req.Keys))
+	for i, key := range req.Keys {
+		record := records[i]
+
+		// finalized by previous request, e.g. a batch failed on another key
+		if record.FinalizedAt != nil {
+			result = append(result, h.response(key, record))
+			continue
+		}
+
+		stat, err := h.s3Adapter.Stat(ctx.Context(), &adapters.S3Obj{Key: key})
+		if err != nil {
+			if errors.Is(err, adapters.ErrNotFound) {
+				return ctx.Status(fiber.StatusNotFound).JSON(dtos.ErrResponse("ERR_NOT_UPLOADED"))
+			}
+			log.Errorf("[FinalizeUploadHandler] Failed stat %v", err)
+			return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_STAT"))
+		}
+
+		contentType, err := h.detect(ctx.Context(), key)
+		if err != nil {
+			log.Errorf("[FinalizeUploadHandler] Failed read %v", err)
+			return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_READ_FILE"))
+		}
+
+		// client controls what is sent to presigned URL, so content is checked here.
+		// The key was issued to this tenant, so the file is theirs to delete
+		if err := filePolicy.Check(fileName(key), contentType); err != nil {
+			h.reject(ctx.Context(), key)
+			return policyErr(ctx, err)
+		}
+
+		err = checkStored(ctx.Context(), h.s3Adapter, h.limits, key, stat.ContentLength, contentType)
+		if isLimitErr(err) {
+			h.reject(ctx.Context(), key)
+			return limitErr(ctx, fileName(key), err)
+		}
+		if err != nil {
+			log.Errorf("[FinalizeUploadHandler] Failed check limits %v", err)
+			return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_READ_FILE"))
+		}
+
+		now := time.Now()
+		record.FinalizedAt = &now
+		if isImage(contentType) {
+			record.Jobs = enqueueDerived(h.queue, h.resizerConfig.HasVariants(), key)
+		}
+
+		// the record is kept until it expires to answer retries
+		if err := savePresignRecord(ctx.Context(), h.s3Adapter, key, record); err != nil {
+			log.Errorf("[FinalizeUploadHandler] Failed save record %v", err)
+			return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_FINALIZE"))
+		}
+
+		result = append(result, h.response(key, record))
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(dtos.SuccessResponse(result))
+}
+
+// response describes file of finalized key
+func (h *FinalizeUploadHandler) response(key string, record *presignRecord) dtos.UploadFilesResponse {
+	return dtos.UploadFilesResponse{
+		Name:       fileName(key),
+		Url:        h.appConfig.BaseURL + "/" + url.PathEscape(key),
+		UploadedAt: *record.FinalizedAt,
+		Jobs:       record.Jobs,
+	}
+}
+
+// reject deletes file violating upload policy or limits with its record
+func (h *FinalizeUploadHandler) reject(ctx context.Context, key string) {
+	if err := h.s3Adapter.DeleteObjs(ctx, []*adapters.S3Obj{{Key: key}, {Key: presignKey(key)}}); err != nil {
+		log.Errorf("[FinalizeUploadHandler] Failed delete rejected file %v", err)
+	}
+}
+
+// issued returns record of key presigned for tenant, nil if there is none or it's expired
+func (h *FinalizeUploadHandler) issued(ctx context.Context, key, tenant string) (*presignRecord, error) {
+	record, err := loadPresignRecord(ctx, h.s3Adapter, presignKey(key))
+	if errors.Is(err, adapters.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if record.Tenant != tenant || record.expired(time.Now()) {
+		return nil, nil
+	}
+
+	return record, nil
+}
+
+// detect reads first bytes of uploaded file to detect its type
+func (h *FinalizeUploadHandler) detect(ctx context.Context, key string) (string, error) {
+	body, err := h.s3Adapter.GetObj(ctx, &adapters.S3Obj{Key: key})
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	head := make([]byte, policy.SniffLen)
+	n, err := io.ReadFull(body, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	return policy.Detect(head[:n]), nil
+}
+
+// fileName strips "uuid-" prefix of uploaded file key
+func fileName(key string) string {
+	if len(key) > 37 && key[36] == '-' {
+		if _, err := uuid.Parse(key[:36]); err == nil {
+			return key[37:]
+		}
+	}
+
+	return key
+}
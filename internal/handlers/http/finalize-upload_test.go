@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/WildEgor/gImageResizer/internal/dtos"
+	"github.com/gofiber/fiber/v2"
+)
+
+// presign asks for direct upload of a png and uploads data to the key
+func presign(t *testing.T, s *testServer, tenant string, data []byte) string {
+	t.Helper()
+
+	var files []dtos.PresignUploadResponse
+	resp, message := s.do(t, jsonRequest(t, http.MethodPost, "/api/v1/upload/presign", dtos.PresignUploadRequest{
+		Files: []dtos.PresignUploadFile{{Name: "a b.png", ContentType: "image/png", Size: int64(len(data))}},
+	}, map[string]string{"X-Tenant-ID": tenant}), &files)
+	if resp.StatusCode != fiber.StatusOK || len(files) != 1 {
+		t.Fatalf("presign: got %d %q", resp.StatusCode, message)
+	}
+
+	// client uploads to the presigned URL
+	err := s.storage.PutObj(context.Background(), &adapters.S3Obj{
+		Key:         files[0].Key,
+		Bytes:       data,
+		ContentType: "image/png",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files[0].Key
+}
+
+func finalize(t *testing.T, s *testServer, tenant string, keys ...string) (int, string, []dtos.UploadFilesResponse) {
+	t.Helper()
+
+	var files []dtos.UploadFilesResponse
+	resp, message := s.do(t, jsonRequest(t, http.MethodPost, "/api/v1/upload/finalize", dtos.FinalizeUploadRequest{
+		Keys: keys,
+	}, map[string]string{"X-Tenant-ID": tenant}), &files)
+
+	return resp.StatusCode, message, files
+}
+
+func TestFinalizeUploadIssuedKey(t *testing.T) {
+	s := newTestServer(t)
+	key := presign(t, s, "", testPNG(t, 8, 8))
+
+	status, message, files := finalize(t, s, "", key)
+	if status != fiber.StatusOK || len(files) != 1 {
+		t.Fatalf("got %d %q", status, message)
+	}
+	if want := testBaseURL + "/" + key[:37] + "a%20b.png"; files[0].Url != want {
+		t.Errorf("got url %q, want %q", files[0].Url, want)
+	}
+	if len(files[0].Jobs) != 1 {
+		t.Errorf("got jobs %v, want metadata job", files[0].Jobs)
+	}
+
+	// finalized again gets the same file without new jobs
+	status, message, again := finalize(t, s, "", key)
+	if status != fiber.StatusOK || len(again) != 1 {
+		t.Fatalf("second finalize: got %d %q", status, message)
+	}
+	if again[0].Url != files[0].Url || !again[0].UploadedAt.Equal(files[0].UploadedAt) || again[0].Jobs[0] != files[0].Jobs[0] {
+		t.Errorf("second finalize: got %+v, want %+v", again[0], files[0])
+	}
+}
+
+func TestFinalizeUploadRetriedBatch(t *testing.T) {
+	s := newTestServer(t)
+	uploaded := presign(t, s, "a", testPNG(t, 8, 8))
+	missing := presign(t, s, "a", testPNG(t, 8, 8))
+	if err := s.storage.DeleteObj(context.Background(), &adapters.S3Obj{Key: missing}); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, message, _ := finalize(t, s, "a", uploaded, missing); status != fiber.StatusNotFound || message != "ERR_NOT_UPLOADED" {
+		t.Fatalf("got %d %q, want 404 ERR_NOT_UPLOADED", status, message)
+	}
+
+	// the client uploads the missing file and retries the whole batch
+	s.storage.PutObj(context.Background(), &adapters.S3Obj{Key: missing, Bytes: testPNG(t, 8, 8), ContentType: "image/png"})
+	status, message, files := finalize(t, s, "a", uploaded, missing)
+	if status != fiber.StatusOK || len(files) != 2 {
+		t.Fatalf("retry: got %d %q", status, message)
+	}
+	for i, key := range []string{uploaded, missing} {
+		if want := testBaseURL + "/" + key[:37] + "a%20b.png"; files[i].Url != want {
+			t.Errorf("got url %q, want %q", files[i].Url, want)
+		}
+		if len(files[i].Jobs) != 1 {
+			t.Errorf("%s: got jobs %v, want metadata job", key, files[i].Jobs)
+		}
+	}
+}
+
+func TestFinalizeUploadExpiredKey(t *testing.T) {
+	s := newTestServer(t)
+	key := presign(t, s, "", testPNG(t, 8, 8))
+
+	expired := &presignRecord{ExpiresAt: time.Now().Add(-time.Second)}
+	if err := savePresignRecord(context.Background(), s.storage, key, expired); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, message, _ := finalize(t, s, "", key); status != fiber.StatusForbidden || message != "ERR_KEY_NOT_ISSUED" {
+		t.Errorf("got %d %q, want 403 ERR_KEY_NOT_ISSUED", status, message)
+	}
+}
+
+func TestPresignUploadPurge(t *testing.T) {
+	s := newTestServer(t)
+	abandoned := presign(t, s, "", testPNG(t, 8, 8))
+	finalized := presign(t, s, "", testPNG(t, 8, 8))
+	if status, message, _ := finalize(t, s, "", finalized); status != fiber.StatusOK {
+		t.Fatalf("finalize: got %d %q", status, message)
+	}
+
+	// records live as long as URL plus finalizeWindow
+	purged, err := s.presign.Purge(context.Background(), time.Now())
+	if err != nil || purged != 0 {
+		t.Fatalf("got %d purged, %v before expiry", purged, err)
+	}
+
+	purged, err = s.presign.Purge(context.Background(), time.Now().Add(finalizeWindow+time.Minute))
+	if err != nil || purged != 2 {
+		t.Fatalf("got %d purged, %v, want 2", purged, err)
+	}
+
+	if s.storage.Object("test", presignKey(abandoned)) != nil || s.storage.Object("test", presignKey(finalized)) != nil {
+		t.Errorf("expired records are kept")
+	}
+	if s.storage.Object("test", abandoned) != nil {
+		t.Errorf("file which wasn't finalized is kept")
+	}
+	if s.storage.Object("test", finalized) == nil {
+		t.Errorf("finalized file is deleted")
+	}
+}
+
+func TestFinalizeUploadRejectsKeys(t *testing.T) {
+	s := newTestServer(t)
+	key := presign(t, s, "a", testPNG(t, 8, 8))
+
+	for _, other := range []string{".meta/x.json", ".tus/x.info", "_small/x", "sha256/ab/cd/abcd"} {
+		s.storage.PutObj(context.Background(), &adapters.S3Obj{Key: other, Bytes: []byte("{}"), ContentType: "application/json"})
+	}
+	s.storage.PutObj(context.Background(), &adapters.S3Obj{Key: "other.png", Bytes: testPNG(t, 8, 8), ContentType: "image/png"})
+
+	tests := []struct {
+		name    string
+		tenant  string
+		key     string
+		status  int
+		message string
+	}{
+		{"metadata", "a", ".meta/x.json", fiber.StatusBadRequest, "ERR_KEY"},
+		{"tus", "a", ".tus/x.info", fiber.StatusBadRequest, "ERR_KEY"},
+		{"variant", "a", "_small/x", fiber.StatusBadRequest, "ERR_KEY"},
+		{"content addressed", "a", "sha256/ab/cd/abcd", fiber.StatusBadRequest, "ERR_KEY"},
+		{"not presigned", "a", "other.png", fiber.StatusForbidden, "ERR_KEY_NOT_ISSUED"},
+		{"other tenant", "b", key, fiber.StatusForbidden, "ERR_KEY_NOT_ISSUED"},
+	}
+
+	for _, tt := range tests {
+		status, message, _ := finalize(t, s, tt.tenant, tt.key)
+		if status != tt.status || message != tt.message {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, status, message, tt.status, tt.message)
+		}
+	}
+
+	// rejected keys are left as they are
+	for _, other := range []string{".meta/x.json", "sha256/ab/cd/abcd", "other.png", key} {
+		if s.storage.Object("test", other) == nil {
+			t.Errorf("%s was deleted", other)
+		}
+	}
+}
+
+func TestFinalizeUploadDeletesRejectedFile(t *testing.T) {
+	s := newTestServer(t, func(c *configs.UploadConfig) {
+		c.AllowedTypes = []string{"image/*"}
+	})
+	rejected := presign(t, s, "a", []byte("not an image at all"))
+	other := presign(t, s, "b", []byte("not an image either"))
+
+	// file of other tenant isn't deleted by failed finalize
+	if status, message, _ := finalize(t, s, "a", other); status != fiber.StatusForbidden {
+		t.Errorf("other tenant: got %d %q", status, message)
+	}
+	if s.storage.Object("test", other) == nil {
+		t.Errorf("file of other tenant was deleted")
+	}
+
+	status, message, _ := finalize(t, s, "a", rejected)
+	if status != fiber.StatusUnsupportedMediaType || message != "ERR_TYPE_NOT_ALLOWED" {
+		t.Errorf("got %d %q", status, message)
+	}
+	if s.storage.Object("test", rejected) != nil || s.storage.Object("test", presignKey(rejected)) != nil {
+		t.Errorf("rejected file or its record is kept")
+	}
+}
+
+func TestFinalizeUploadRejectsImageLimits(t *testing.T) {
+	s := newTestServer(t, func(c *configs.UploadConfig) {
+		c.MaxWidth = 16
+	})
+	key := presign(t, s, "", testPNG(t, 32, 4))
+
+	var limit dtos.UploadLimitResponse
+	status, message := s.fail(t, jsonRequest(t, http.MethodPost, "/api/v1/upload/finalize", dtos.FinalizeUploadRequest{
+		Keys: []string{key},
+	}, nil), &limit)
+	if status != fiber.StatusUnprocessableEntity || message != "ERR_IMAGE_WIDTH" {
+		t.Fatalf("got %d %q, want 422 ERR_IMAGE_WIDTH", status, message)
+	}
+	if limit.Name != "a b.png" || limit.Actual != 32 {
+		t.Errorf("got %+v", limit)
+	}
+	if s.storage.Object("test", key) != nil || s.storage.Object("test", presignKey(key)) != nil {
+		t.Errorf("rejected file or its record is kept")
+	}
+}
+
+func TestPresignUploadRejectsMaxFileSize(t *testing.T) {
+	s := newTestServer(t)
+
+	resp, message := s.do(t, jsonRequest(t, http.MethodPost, "/api/v1/upload/presign", dtos.PresignUploadRequest{
+		Files: []dtos.PresignUploadFile{{Name: "a.png", ContentType: "image/png", Size: 50*1024*1024 + 1}},
+	}, nil), nil)
+	if resp.StatusCode != fiber.StatusRequestEntityTooLarge || message != "ERR_FILE_SIZE" {
+		t.Errorf("got %d %q, want 413 ERR_FILE_SIZE", resp.StatusCode, message)
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/WildEgor/gImageResizer/internal/dtos"
+	"github.com/WildEgor/gImageResizer/internal/jobs"
+	"github.com/gofiber/fiber/v2"
+)
+
+type JobsHandler struct {
+	queue *jobs.Queue
+}
+
+func NewJobsHandler(
+	queue *jobs.Queue,
+) *JobsHandler {
+	return &JobsHandler{
+		queue: queue,
+	}
+}
+
+// GetJob godoc
+//
+//	@Summary		Get job
+//	@Description	Returns status of background job
+//	@Tags			jobs
+//	@Produce		json
+//	@Param			id	path	string	true	"Job ID"
+//	@Router			/api/v1/jobs/{id} [get]
+func (h *JobsHandler) Get(ctx *fiber.Ctx) error {
+	job, err := h.queue.Get(ctx.Params("id"))
+	if err != nil {
+		return h.jobErr(ctx, err)
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(dtos.SuccessResponse(job))
+}
+
+// DeadJobs godoc
+//
+//	@Summary		List dead jobs
+//	@Description	Returns jobs failed all attempts, oldest first
+//	@Tags			jobs
+//	@Produce		json
+//	@Router			/api/v1/jobs/dead [get]
+func (h *JobsHandler) Dead(ctx *fiber.Ctx) error {
+	dead, err := h.queue.Dead()
+	if err != nil {
+		log.Error("[JobsHandler] Failed list dead jobs: ", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_JOBS"))
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(dtos.SuccessResponse(dead))
+}
+
+// RetryJob godoc
+//
+//	@Summary		Retry dead job
+//	@Description	Puts dead job back to the queue
+//	@Tags			jobs
+//	@Produce		json
+//	@Param			id	path	string	true	"Job ID"
+//	@Router			/api/v1/jobs/{id}/retry [post]
+func (h *JobsHandler) Retry(ctx *fiber.Ctx) error {
+	job, err := h.queue.Retry(ctx.Params("id"))
+	if err != nil {
+		return h.jobErr(ctx, err)
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(dtos.SuccessResponse(job))
+}
+
+func (h *JobsHandler) jobErr(ctx *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, jobs.ErrJobNotFound):
+		return ctx.Status(fiber.StatusNotFound).JSON(dtos.ErrResponse("ERR_JOB_NOT_FOUND"))
+	case errors.Is(err, jobs.ErrNotDead):
+		return ctx.Status(fiber.StatusConflict).JSON(dtos.ErrResponse("ERR_JOB_NOT_DEAD"))
+	}
+
+	log.Error("[JobsHandler] Failed load job: ", err)
+
+	return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_JOBS"))
+}
+
+// enqueueDerived schedules metadata and, if configured, variants of uploaded file.
+// Upload is already saved, so failures are only logged
+func enqueueDerived(queue *jobs.Queue, hasVariants bool, key string) []string {
+	types := []string{jobs.TypeMetadata}
+	if hasVariants {
+		types = append(types, jobs.TypeVariants)
+	}
+
+	ids := make([]string, 0, len(types))
+	for _, jobType := range types {
+		job, err := queue.Enqueue(jobType, key)
+		if err != nil {
+			log.Errorf("[Jobs] Failed enqueue %v of %v: %v", jobType, key, err)
+			continue
+		}
+		ids = append(ids, job.ID)
+	}
+
+	return ids
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/dtos"
+	"github.com/WildEgor/gImageResizer/internal/policy"
+	"github.com/gofiber/fiber/v2"
+)
+
+// policyErr responds with code of upload policy violation
+func policyErr(ctx *fiber.Ctx, err error) error {
+	code := "ERR_TYPE_NOT_ALLOWED"
+
+	switch {
+	case errors.Is(err, policy.ErrExtensionNotAllowed):
+		code = "ERR_EXTENSION_NOT_ALLOWED"
+	case errors.Is(err, policy.ErrExtensionMismatch):
+		code = "ERR_EXTENSION_MISMATCH"
+	}
+
+	return ctx.Status(fiber.StatusUnsupportedMediaType).JSON(dtos.ErrResponse(code))
+}
+
+// limitErr responds with limit exceeded by the file, other errors are internal
+func limitErr(ctx *fiber.Ctx, name string, err error) error {
+	var limitErr *policy.LimitError
+	if !errors.As(err, &limitErr) {
+		log.Errorf("Failed check limits of %v %v", name, err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_READ_FILE"))
+	}
+
+	status, code := fiber.StatusUnprocessableEntity, ""
+	switch limitErr.Limit {
+	case policy.LimitFileSize:
+		status, code = fiber.StatusRequestEntityTooLarge, "ERR_IMAGE_FILE_SIZE"
+	case policy.LimitWidth:
+		code = "ERR_IMAGE_WIDTH"
+	case policy.LimitHeight:
+		code = "ERR_IMAGE_HEIGHT"
+	case policy.LimitResolution:
+		code = "ERR_IMAGE_RESOLUTION"
+	case policy.LimitFrames:
+		code = "ERR_ANIMATION_FRAMES"
+	}
+
+	return ctx.Status(status).JSON(dtos.ErrDataResponse(code, dtos.UploadLimitResponse{
+		Name:   name,
+		Limit:  limitErr.Limit,
+		Max:    limitErr.Max,
+		Actual: limitErr.Actual,
+	}))
+}
+
+// checkStored checks file uploaded around the handler, e.g. with tus or presigned URL,
+// against limits. Images are read to check their headers and frames
+func checkStored(
+	ctx context.Context,
+	s3Adapter adapters.IS3Adapter,
+	limits *policy.Limits,
+	key string,
+	size int64,
+	contentType string,
+) error {
+	if err := limits.CheckSize(size); err != nil {
+		return err
+	}
+
+	if !isImage(contentType) {
+		return nil
+	}
+
+	body, err := s3Adapter.GetObj(ctx, &adapters.S3Obj{Key: key})
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	return limits.CheckImage(bytes.NewReader(data))
+}
+
+// isLimitErr tells if err is a limit exceeded by the file
+func isLimitErr(err error) bool {
+	var limitErr *policy.LimitError
+	return errors.As(err, &limitErr)
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/cas"
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/WildEgor/gImageResizer/internal/dtos"
+	"github.com/gofiber/fiber/v2"
+)
+
+type PresignDownloadHandler struct {
+	s3Config      *configs.S3Config
+	storageConfig *configs.StorageConfig
+	s3Adapter     adapters.IS3Adapter
+	store         *cas.Store
+}
+
+func NewPresignDownloadHandler(
+	s3Config *configs.S3Config,
+	storageConfig *configs.StorageConfig,
+	s3Adapter adapters.IS3Adapter,
+	store *cas.Store,
+) *PresignDownloadHandler {
+	return &PresignDownloadHandler{
+		s3Config:      s3Config,
+		storageConfig: storageConfig,
+		s3Adapter:     s3Adapter,
+		store:         store,
+	}
+}
+
+// PresignDownload godoc
+//
+//	@Summary		Get presigned download URL
+//	@Description	Returns presigned GET URL of the original file. ttl is clamped to S3_DOWNLOAD_PRESIGN_MAX_TTL,
+//	@Description	S3_DOWNLOAD_PRESIGN_TTL is used without it
+//	@Tags			upload
+//	@Produce		json
+//	@Param			key								path	string	true	"File key"
+//	@Param			ttl								query	string	false	"Lifetime, e.g. 10m or seconds"
+//	@Param			response-content-disposition	query	string	false	"Content-Disposition of the response, e.g. attachment"
+//	@Param			response-content-type			query	string	false	"Content-Type of the response"
+//	@Router			/api/v1/upload/{key}/presign [get]
+func (h *PresignDownloadHandler) Handle(ctx *fiber.Ctx) error {
+	key, err := url.PathUnescape(ctx.Params("key", ""))
+	if err != nil || key == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_EMPTY_KEY"))
+	}
+
+	var query dtos.PresignDownloadQuery
+	if err := ctx.QueryParser(&query); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_QUERY"))
+	}
+
+	ttl := h.s3Config.DownloadPresignTTL
+	if query.TTL != "" {
+		ttl, err = parseTTL(query.TTL)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_TTL"))
+		}
+	}
+	ttl = h.s3Config.ClampPresignTTL(ttl)
+
+	// header values can't break lines of the signed response
+	if strings.ContainsAny(query.ContentDisposition+query.ContentType, "\r\n") {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_QUERY"))
+	}
+
+	// files on disk have no URLs to sign
+	if h.storageConfig.IsLocal() {
+		return ctx.Status(fiber.StatusNotImplemented).JSON(dtos.ErrResponse("ERR_PRESIGN_NOT_SUPPORTED"))
+	}
+
+	key, err = h.store.Resolve(ctx.Context(), key)
+	if err != nil {
+		log.Errorf("[PresignDownloadHandler] Failed load alias %v", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_PRESIGN"))
+	}
+
+	if _, err := h.s3Adapter.Stat(ctx.Context(), &adapters.S3Obj{Key: key}); err != nil {
+		if errors.Is(err, adapters.ErrNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(dtos.ErrResponse("ERR_NOT_FOUND"))
+		}
+		log.Errorf("[PresignDownloadHandler] Failed stat %v", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_PRESIGN"))
+	}
+
+	expiresAt := time.Now().Add(ttl)
+
+	link, err := h.s3Adapter.GetPresign(ctx.Context(), &adapters.S3Obj{
+		Key:                key,
+		PresignTTL:         ttl,
+		ContentType:        query.ContentType,
+		ContentDisposition: query.ContentDisposition,
+	})
+	if err != nil {
+		log.Errorf("[PresignDownloadHandler] Failed presign %v", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_PRESIGN"))
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(dtos.SuccessResponse(dtos.PresignDownloadResponse{
+		Url:       *link,
+		ExpiresAt: expiresAt,
+	}))
+}
+
+// parseTTL reads duration like 10m or number of seconds
+func parseTTL(value string) (time.Duration, error) {
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, serr := strconv.ParseInt(value, 10, 64)
+		if serr != nil {
+			return 0, err
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+
+	if ttl <= 0 {
+		return 0, errors.New("ttl should be positive")
+	}
+
+	return ttl, nil
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/cas"
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/WildEgor/gImageResizer/internal/dtos"
+	"github.com/gofiber/fiber/v2"
+)
+
+// newPresignDownloadTestServer serves presigned downloads of driver with default
+// TTL of an hour clamped to a day
+func newPresignDownloadTestServer(t *testing.T, driver string) *testServer {
+	t.Helper()
+
+	s3Config := &configs.S3Config{Bucket: "test", DownloadPresignTTL: time.Hour, DownloadPresignMaxTTL: 24 * time.Hour}
+	memory := adapters.NewMemoryAdapter(s3Config)
+	store := cas.NewStore(&configs.UploadConfig{}, memory)
+	handler := NewPresignDownloadHandler(s3Config, &configs.StorageConfig{Driver: driver}, memory, store)
+
+	app := fiber.New()
+	app.Get("/api/v1/upload/:key/presign", handler.Handle)
+
+	memory.PutObj(context.Background(), &adapters.S3Obj{Key: "a b.pdf", Bytes: []byte("%PDF-1.4\n"), ContentType: "application/pdf"})
+
+	return &testServer{app: app, storage: memory, store: store}
+}
+
+func TestPresignDownload(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       url.Values
+		ttl         time.Duration
+		disposition string
+		contentType string
+	}{
+		{"default ttl", nil, time.Hour, "", ""},
+		{"duration", url.Values{"ttl": {"10m"}}, 10 * time.Minute, "", ""},
+		{"seconds", url.Values{"ttl": {"90"}}, 90 * time.Second, "", ""},
+		{"clamped", url.Values{"ttl": {"720h"}}, 24 * time.Hour, "", ""},
+		{
+			"overrides",
+			url.Values{
+				"response-content-disposition": {`attachment; filename="a b.pdf"`},
+				"response-content-type":        {"application/octet-stream"},
+			},
+			time.Hour, `attachment; filename="a b.pdf"`, "application/octet-stream",
+		},
+	}
+
+	for _, tt := range tests {
+		s := newPresignDownloadTestServer(t, "s3")
+
+		start := time.Now()
+		var link dtos.PresignDownloadResponse
+		resp, message := s.do(t, httpRequest(http.MethodGet, "/api/v1/upload/a%20b.pdf/presign?"+tt.query.Encode()), &link)
+		if resp.StatusCode != fiber.StatusOK {
+			t.Errorf("%s: got %d %q", tt.name, resp.StatusCode, message)
+			continue
+		}
+
+		presigns := s.storage.Presigns()
+		if len(presigns) != 1 {
+			t.Errorf("%s: got %d presigns", tt.name, len(presigns))
+			continue
+		}
+		got := presigns[0]
+		if got.Key != "a b.pdf" || got.PresignTTL != tt.ttl {
+			t.Errorf("%s: presigned %q for %v, want a b.pdf for %v", tt.name, got.Key, got.PresignTTL, tt.ttl)
+		}
+		if got.ContentDisposition != tt.disposition || got.ContentType != tt.contentType {
+			t.Errorf("%s: got overrides %q %q, want %q %q", tt.name, got.ContentDisposition, got.ContentType, tt.disposition, tt.contentType)
+		}
+
+		if link.Url != "memory://test/a b.pdf" {
+			t.Errorf("%s: got url %q", tt.name, link.Url)
+		}
+		if link.ExpiresAt.Before(start.Add(tt.ttl)) || link.ExpiresAt.After(time.Now().Add(tt.ttl)) {
+			t.Errorf("%s: got expiresAt %v, want in %v", tt.name, link.ExpiresAt, tt.ttl)
+		}
+	}
+}
+
+func TestPresignDownloadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		driver  string
+		target  string
+		status  int
+		message string
+	}{
+		{"bad ttl", "s3", "/api/v1/upload/a%20b.pdf/presign?ttl=soon", fiber.StatusBadRequest, "ERR_TTL"},
+		{"zero ttl", "s3", "/api/v1/upload/a%20b.pdf/presign?ttl=0", fiber.StatusBadRequest, "ERR_TTL"},
+		{"negative ttl", "s3", "/api/v1/upload/a%20b.pdf/presign?ttl=-1m", fiber.StatusBadRequest, "ERR_TTL"},
+		{"header injection", "s3", "/api/v1/upload/a%20b.pdf/presign?response-content-type=" + url.QueryEscape("text/html\r\nX-A: b"), fiber.StatusBadRequest, "ERR_QUERY"},
+		{"not found", "s3", "/api/v1/upload/missing.pdf/presign", fiber.StatusNotFound, "ERR_NOT_FOUND"},
+		{"local driver", "local", "/api/v1/upload/a%20b.pdf/presign", fiber.StatusNotImplemented, "ERR_PRESIGN_NOT_SUPPORTED"},
+	}
+
+	for _, tt := range tests {
+		s := newPresignDownloadTestServer(t, tt.driver)
+
+		resp, message := s.do(t, httpRequest(http.MethodGet, tt.target), nil)
+		if resp.StatusCode != tt.status || message != tt.message {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, resp.StatusCode, message, tt.status, tt.message)
+		}
+		if presigns := s.storage.Presigns(); len(presigns) != 0 {
+			t.Errorf("%s: got presigns %+v", tt.name, presigns)
+		}
+	}
+}
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"10m", 10 * time.Minute, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"600", 10 * time.Minute, true},
+		{"0", 0, false},
+		{"-5s", 0, false},
+		{"", 0, false},
+		{"1d", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTTL(tt.value)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("%q: got %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
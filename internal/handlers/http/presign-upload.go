@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/WildEgor/gImageResizer/internal/dtos"
+	"github.com/WildEgor/gImageResizer/internal/policy"
+	"github.com/gofiber/fiber/v2"
+	uuid "github.com/google/uuid"
+)
+
+// S3 doesn't accept bigger single PUT uploads
+const maxPresignUploadSize = 5 * 1024 * 1024 * 1024
+
+// presignPrefix of records of issued direct uploads, internal keys start with "."
+const presignPrefix = ".presign/"
+
+// finalizeWindow is how long after its URL expires a key may be finalized,
+// uploads started in time may still be running
+const finalizeWindow = time.Hour
+
+// presignRecord is stored as .presign/<key>.json for every presigned key,
+// finalize accepts only keys having it until ExpiresAt. Finalized keys keep
+// it till then too, so retried finalize gets the same response
+type presignRecord struct {
+	Tenant      string     `json:"tenant,omitempty"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	FinalizedAt *time.Time `json:"finalizedAt,omitempty"`
+	Jobs        []string   `json:"jobs,omitempty"`
+}
+
+// expired tells if key of the record can't be finalized anymore at now
+func (r *presignRecord) expired(now time.Time) bool {
+	return !now.Before(r.ExpiresAt)
+}
+
+type PresignUploadHandler struct {
+	s3Config     *configs.S3Config
+	uploadConfig *configs.UploadConfig
+	s3Adapter    adapters.IS3Adapter
+	policies     *policy.Policies
+	limits       *policy.Limits
+}
+
+func NewPresignUploadHandler(
+	s3Config *configs.S3Config,
+	uploadConfig *configs.UploadConfig,
+	s3Adapter adapters.IS3Adapter,
+	policies *policy.Policies,
+	limits *policy.Limits,
+) *PresignUploadHandler {
+	return &PresignUploadHandler{
+		s3Config:     s3Config,
+		uploadConfig: uploadConfig,
+		s3Adapter:    s3Adapter,
+		policies:     policies,
+		limits:       limits,
+	}
+}
+
+// PresignUpload godoc
+//
+//	@Summary		Get presigned URLs for direct upload
+//	@Description	Returns presigned PUT URL per file, upload must be sent with returned headers.
+//	@Description	Call /api/v1/upload/finalize with keys after uploading, it checks content of files.
+//	@Description	Only keys issued here can be finalized, by the same tenant
+//	@Tags			upload
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body	dtos.PresignUploadRequest	true	"Files"
+//	@Router			/api/v1/upload/presign [post]
+func (h *PresignUploadHandler) Handle(ctx *fiber.Ctx) error {
+	var req dtos.PresignUploadRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_BODY"))
+	}
+
+	if len(req.Files) == 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_EMPTY_FILES"))
+	}
+
+	// declared type is checked here, magic bytes are checked on finalize
+	tenant := ctx.Get(h.uploadConfig.TenantHeader)
+	filePolicy := h.policies.For(policy.RoutePresign, tenant)
+	for _, file := range req.Files {
+		if file.Name == "" || file.ContentType == "" || file.Size <= 0 {
+			return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_FILE_INFO"))
+		}
+		if file.Size > maxPresignUploadSize || h.limits.CheckSize(file.Size) != nil {
+			return ctx.Status(fiber.StatusRequestEntityTooLarge).JSON(dtos.ErrResponse("ERR_FILE_SIZE"))
+		}
+		if err := filePolicy.Check(file.Name, file.ContentType); err != nil {
+			return policyErr(ctx, err)
+		}
+	}
+
+	expiresAt := time.Now().Add(h.s3Config.UploadPresignTTL)
+
+	result := make([]dtos.PresignUploadResponse, 0, len(req.Files))
+	for _, file := range req.Files {
+		name := path.Base("/" + strings.ReplaceAll(file.Name, "\\", "/"))
+		key := uuid.New().String() + "-" + name
+
+		link, headers, err := h.s3Adapter.PutPresign(ctx.Context(), &adapters.S3Obj{
+			Key:           key,
+			ContentType:   file.ContentType,
+			ContentLength: file.Size,
+		})
+		if errors.Is(err, adapters.ErrNotSupported) {
+			return ctx.Status(fiber.StatusNotImplemented).JSON(dtos.ErrResponse("ERR_PRESIGN_NOT_SUPPORTED"))
+		}
+		if err != nil {
+			log.Errorf("[PresignUploadHandler] Failed presign %v", err)
+			return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_PRESIGN"))
+		}
+
+		record := &presignRecord{Tenant: tenant, ExpiresAt: expiresAt.Add(finalizeWindow)}
+		if err := savePresignRecord(ctx.Context(), h.s3Adapter, key, record); err != nil {
+			log.Errorf("[PresignUploadHandler] Failed save record %v", err)
+			return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_PRESIGN"))
+		}
+
+		signedHeaders := make(map[string]string, len(headers))
+		for name, values := range headers {
+			signedHeaders[http.CanonicalHeaderKey(name)] = strings.Join(values, ",")
+		}
+
+		result = append(result, dtos.PresignUploadResponse{
+			Name:      name,
+			Key:       key,
+			Url:       *link,
+			Method:    fiber.MethodPut,
+			Headers:   signedHeaders,
+			ExpiresAt: expiresAt,
+		})
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(dtos.SuccessResponse(result))
+}
+
+// Purge deletes records expired at now with files of keys which weren't finalized,
+// returns how many records were deleted
+func (h *PresignUploadHandler) Purge(ctx context.Context, now time.Time) (int, error) {
+	var expired []*adapters.S3Obj
+	purged := 0
+
+	query := &adapters.S3ListQuery{Prefix: presignPrefix, Limit: 1000}
+	for {
+		list, err := h.s3Adapter.List(ctx, query)
+		if err != nil {
+			return purged, err
+		}
+
+		for _, obj := range list.Objects {
+			record, err := loadPresignRecord(ctx, h.s3Adapter, obj.Key)
+			if errors.Is(err, adapters.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				log.Errorf("[PresignUploadHandler] Failed load record %v: %v", obj.Key, err)
+				continue
+			}
+			if !record.expired(now) {
+				continue
+			}
+
+			// the file was abandoned by client, finalized ones are left
+			expired = append(expired, &adapters.S3Obj{Key: obj.Key})
+			if record.FinalizedAt == nil {
+				key := strings.TrimSuffix(strings.TrimPrefix(obj.Key, presignPrefix), ".json")
+				expired = append(expired, &adapters.S3Obj{Key: key})
+			}
+			purged++
+		}
+
+		if list.Cursor == "" {
+			break
+		}
+		query.Cursor = list.Cursor
+	}
+
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	if err := h.s3Adapter.DeleteObjs(ctx, expired); err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
+
+// savePresignRecord records that key was presigned or finalized
+func savePresignRecord(ctx context.Context, s3Adapter adapters.IS3Adapter, key string, record *presignRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return s3Adapter.PutObj(ctx, &adapters.S3Obj{
+		Key:           presignKey(key),
+		Bytes:         b,
+		ContentType:   fiber.MIMEApplicationJSON,
+		ContentLength: int64(len(b)),
+	})
+}
+
+// loadPresignRecord reads record stored under recordKey
+func loadPresignRecord(ctx context.Context, s3Adapter adapters.IS3Adapter, recordKey string) (*presignRecord, error) {
+	body, err := s3Adapter.GetObj(ctx, &adapters.S3Obj{Key: recordKey})
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var record presignRecord
+	if err := json.NewDecoder(body).Decode(&record); err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// presignKey is storage key of record of presigned key
+func presignKey(key string) string {
+	return presignPrefix + key + ".json"
+}
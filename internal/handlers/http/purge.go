@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// purgeInterval is how often leftovers of abandoned uploads are deleted
+const purgeInterval = time.Hour
+
+// Purger deletes leftovers of uploads abandoned by clients in background:
+// expired presign records with files which weren't finalized and expired
+// tus uploads. Close stops it
+type Purger struct {
+	presignUpload *PresignUploadHandler
+	tus           *TusHandler
+	ctx           context.Context
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
+}
+
+func NewPurger(presignUpload *PresignUploadHandler, tus *TusHandler) *Purger {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Purger{
+		presignUpload: presignUpload,
+		tus:           tus,
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+
+	p.wg.Add(1)
+	go p.purgeEvery(purgeInterval)
+
+	return p
+}
+
+// Close stops purging and waits for running purge to be cancelled
+func (p *Purger) Close() error {
+	p.cancel()
+	p.wg.Wait()
+
+	return nil
+}
+
+func (p *Purger) purgeEvery(interval time.Duration) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.purge(now)
+		}
+	}
+}
+
+func (p *Purger) purge(now time.Time) {
+	purged, err := p.presignUpload.Purge(p.ctx, now)
+	if err != nil {
+		log.Errorf("[Purger] Failed purge presign records: %v", err)
+	}
+	if purged != 0 {
+		log.Infof("[Purger] Purged %v expired presign records", purged)
+	}
+
+	purged, err = p.tus.Purge(p.ctx, now)
+	if err != nil {
+		log.Errorf("[Purger] Failed purge tus uploads: %v", err)
+	}
+	if purged != 0 {
+		log.Infof("[Purger] Purged %v expired tus uploads", purged)
+	}
+}
@@ -1,21 +1,35 @@
 package handlers
 
 import (
-	"io/ioutil"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
 	"mime/multipart"
-	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/cas"
 	"github.com/WildEgor/gImageResizer/internal/configs"
 	dtos "github.com/WildEgor/gImageResizer/internal/dtos"
+	"github.com/WildEgor/gImageResizer/internal/jobs"
+	"github.com/WildEgor/gImageResizer/internal/policy"
+	"github.com/WildEgor/gImageResizer/internal/resizer"
 	"github.com/gofiber/fiber/v2"
 	uuid "github.com/google/uuid"
 )
 
+// measureFactor is how many body limits of request over the limit
+// are read to report sizes of its files
+const measureFactor = 4
+
 type PutObjResult struct {
 	FileName string
 	Status   bool
@@ -23,98 +37,489 @@ type PutObjResult struct {
 }
 
 type SaveFilesHandler struct {
-	appConfig *configs.AppConfig
-	s3Adapter adapters.IS3Adapter
+	appConfig     *configs.AppConfig
+	uploadConfig  *configs.UploadConfig
+	s3Config      *configs.S3Config
+	resizerConfig *configs.ResizerConfig
+	s3Adapter     adapters.IS3Adapter
+	queue         *jobs.Queue
+	resizer       *resizer.Resizer
+	policies      *policy.Policies
+	limits        *policy.Limits
+	store         *cas.Store
 }
 
 func NewSaveFilesHandler(
 	appConfig *configs.AppConfig,
+	uploadConfig *configs.UploadConfig,
+	s3Config *configs.S3Config,
+	resizerConfig *configs.ResizerConfig,
 	s3Adapter adapters.IS3Adapter,
+	queue *jobs.Queue,
+	resizer *resizer.Resizer,
+	policies *policy.Policies,
+	limits *policy.Limits,
+	store *cas.Store,
 ) *SaveFilesHandler {
 	return &SaveFilesHandler{
-		appConfig: appConfig,
-		s3Adapter: s3Adapter,
+		appConfig:     appConfig,
+		uploadConfig:  uploadConfig,
+		s3Config:      s3Config,
+		resizerConfig: resizerConfig,
+		s3Adapter:     s3Adapter,
+		queue:         queue,
+		resizer:       resizer,
+		policies:      policies,
+		limits:        limits,
+		store:         store,
 	}
 }
 
 // SaveFiles godoc
 //
 //		@Summary		Upload any valid files
-//		@Description	Upload files
+//		@Description	Upload files, images get metadata and RESIZER_VARIANTS jobs
+//		@Description	Requests over APP_MAX_FILES, APP_MAX_FILE_SIZE or APP_MAX_REQUEST_SIZE get 413 with sizes of files,
+//		@Description	images over size, resolution or frames limits are rejected.
+//		@Description	With UPLOAD_CONTENT_ADDRESSED files are stored by SHA-256, already stored ones aren't uploaded again,
+//		@Description	every upload gets own key referring to the shared file.
+//		@Description	If any file fails to upload, response is 500 ERR_UPLOAD with results of all files, failed ones have error
 //		@Tags			upload
 //		@Accept			multipart/form-data
 //		@Produce		json
 //	 @Param files formData file true "Files"
+//	 @Param keepMetadata formData bool false "Keep EXIF and orientation of images as is"
 //	 @Param request formData object true "Request Body"
 //		@Router			/api/v1/upload [post]
 func (h *SaveFilesHandler) Handle(ctx *fiber.Ctx) error {
+	body, buffered, err := bufferBody(ctx, h.appConfig.BodyLimit())
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_MULTIPART"))
+	}
+	if !buffered {
+		code, sizes := h.measureForm(ctx, body)
+		// the rest of body isn't read, connection can't be reused
+		ctx.Context().SetConnectionClose()
+		return ctx.Status(fiber.StatusRequestEntityTooLarge).JSON(dtos.ErrDataResponse(code, sizes))
+	}
+
 	form, err := ctx.MultipartForm()
 	if err != nil {
-		ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_MULTIPART"))
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_MULTIPART"))
 	}
 
 	files := form.File["files"]
 	if len(files) == 0 {
-		ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_EMPTY_FILES"))
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_EMPTY_FILES"))
 	}
 
-	wg := sync.WaitGroup{}
+	if code, sizes := h.checkSizes(files); code != "" {
+		return ctx.Status(fiber.StatusRequestEntityTooLarge).JSON(dtos.ErrDataResponse(code, sizes))
+	}
+
+	strip, err := h.stripMetadata(form)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_KEEP_METADATA"))
+	}
+
+	// all files are checked before any of them is uploaded
+	filePolicy := h.policies.For(policy.RouteUpload, ctx.Get(h.uploadConfig.TenantHeader))
+	opened := make([]*uploadFile, 0, len(files))
+	contentTypes := make([]string, 0, len(files))
+	closeAll := func() {
+		for _, file := range opened {
+			file.Close()
+		}
+	}
 
-	successPaths := make([]dtos.UploadFilesResponse, len(files))
-	pathNumber := 0
 	for _, formFile := range files {
+		file, contentType, err := h.openFile(formFile)
+		if err != nil {
+			closeAll()
+			return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_READ_FILE"))
+		}
+		opened = append(opened, file)
+		contentTypes = append(contentTypes, contentType)
+
+		if err := filePolicy.Check(formFile.Filename, contentType); err != nil {
+			closeAll()
+			return policyErr(ctx, err)
+		}
+
+		if err := h.checkLimits(formFile, contentType); err != nil {
+			closeAll()
+			return limitErr(ctx, formFile.Filename, err)
+		}
+	}
+
+	wg := sync.WaitGroup{}
+
+	// every file has own slot, failed ones get error code instead of URL
+	results := make([]dtos.UploadFilesResponse, len(files))
+	for i, formFile := range files {
 		wg.Add(1)
+		go func(i int, formFile *multipart.FileHeader) {
+			defer wg.Done()
+			defer opened[i].Close()
+
+			results[i] = h.saveFile(ctx.Context(), formFile, opened[i], contentTypes[i], strip)
+		}(i, formFile)
+	}
+
+	wg.Wait()
+
+	for _, result := range results {
+		if result.Error != "" {
+			return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrDataResponse("ERR_UPLOAD", results))
+		}
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(dtos.SuccessResponse(results))
+}
+
+// saveFile uploads the file, with UPLOAD_CONTENT_ADDRESSED under its hash,
+// and enqueues jobs of images. Failure is logged and returned as error code
+func (h *SaveFilesHandler) saveFile(
+	ctx context.Context,
+	formFile *multipart.FileHeader,
+	file *uploadFile,
+	contentType string,
+	strip bool,
+) dtos.UploadFilesResponse {
+	resp := dtos.UploadFilesResponse{Name: formFile.Filename}
 
-		binaryFile, err := h.readFile(formFile)
+	key := uuid.New().String() + "-" + formFile.Filename
+	obj := &adapters.S3Obj{
+		Key:           key,
+		Body:          file,
+		ContentType:   contentType,
+		ContentLength: formFile.Size,
+	}
+
+	if strip && isImage(contentType) {
+		data, err := h.sanitize(file)
 		if err != nil {
-			ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_READ_FILE"))
-			break
+			log.Error("[SaveFilesHandler] Failed strip metadata: ", err)
+			resp.Error = "ERR_STRIP_METADATA"
+			return resp
 		}
+		obj.Body = nil
+		obj.Bytes = data
+		obj.ContentLength = int64(len(data))
+	}
 
-		key := uuid.New().String() + "-" + formFile.Filename
-		contentType := http.DetectContentType(binaryFile)
+	// placeholders go to object metadata, so they are computed before upload
+	var placeholders *resizer.Placeholders
+	if isImage(contentType) {
+		placeholders = h.placeholders(formFile, obj.Bytes)
+	}
+	if placeholders != nil {
+		obj.Metadata = placeholders.Metadata()
+	}
 
-		go func(filename string) {
-			defer wg.Done()
-			_, uerr := h.s3Adapter.SessionUpload(ctx.Context(), &adapters.S3Obj{
-				Key:           key,
-				Bytes:         binaryFile,
-				ContentType:   contentType,
-				ContentLength: int64(len(binaryFile)),
-			})
-			if uerr == nil {
-				successPaths[pathNumber] = dtos.UploadFilesResponse{
-					Name:       filename,
-					Url:        h.appConfig.BaseURL + "/" + key,
-					UploadedAt: time.Now(),
-				}
+	// with UPLOAD_CONTENT_ADDRESSED key is alias of the file stored under its hash
+	stored := key
+	if h.uploadConfig.ContentAddressed {
+		if code := h.saveContent(ctx, obj, key); code != "" {
+			resp.Error = code
+			return resp
+		}
+		stored = obj.Key
+	} else if _, err := h.s3Adapter.SessionUpload(ctx, obj); err != nil {
+		log.Error("[SaveFilesHandler] Failed upload file: ", err)
+		resp.Error = "ERR_UPLOAD"
+		return resp
+	}
+
+	resp.Url = h.appConfig.BaseURL + "/" + url.PathEscape(key)
+	resp.UploadedAt = time.Now()
+
+	if placeholders != nil {
+		resp.BlurHash = placeholders.BlurHash
+		resp.Lqip = placeholders.LQIP
+	}
+
+	// jobs of content stored already run again, its previous jobs may be dead
+	if isImage(contentType) {
+		resp.Jobs = enqueueDerived(h.queue, h.resizerConfig.HasVariants(), stored)
+		resp.Variants = h.variantURLs(stored)
+	}
+
+	return resp
+}
+
+// stripMetadata tells if images of the request are sanitized,
+// keepMetadata form field overrides UPLOAD_STRIP_METADATA
+func (h *SaveFilesHandler) stripMetadata(form *multipart.Form) (bool, error) {
+	values := form.Value["keepMetadata"]
+	if len(values) == 0 || values[0] == "" {
+		return h.uploadConfig.StripMetadata, nil
+	}
+
+	keep, err := strconv.ParseBool(values[0])
+	if err != nil {
+		return false, err
+	}
+
+	return !keep, nil
+}
+
+// checkSizes returns error code and per-file sizes when request exceeds
+// APP_MAX_FILES, APP_MAX_FILE_SIZE or APP_MAX_REQUEST_SIZE
+func (h *SaveFilesHandler) checkSizes(files []*multipart.FileHeader) (string, *dtos.UploadSizeResponse) {
+	sizes := h.sizes(files)
+
+	tooLarge := false
+	for _, file := range sizes.Files {
+		tooLarge = tooLarge || file.TooLarge
+	}
+
+	switch {
+	case len(files) > h.appConfig.MaxFiles:
+		return "ERR_TOO_MANY_FILES", sizes
+	case tooLarge:
+		return "ERR_FILE_TOO_LARGE", sizes
+	case sizes.Size > h.appConfig.MaxRequestSize:
+		return "ERR_REQUEST_TOO_LARGE", sizes
+	}
+
+	return "", nil
+}
+
+func (h *SaveFilesHandler) sizes(files []*multipart.FileHeader) *dtos.UploadSizeResponse {
+	sizes := &dtos.UploadSizeResponse{
+		MaxFiles:       h.appConfig.MaxFiles,
+		MaxFileSize:    h.appConfig.MaxFileSize,
+		MaxRequestSize: h.appConfig.MaxRequestSize,
+		Files:          make([]dtos.UploadFileSize, 0, len(files)),
+	}
+
+	for _, file := range files {
+		sizes.Size += file.Size
+		sizes.Files = append(sizes.Files, dtos.UploadFileSize{
+			Name:     file.Filename,
+			Size:     file.Size,
+			TooLarge: file.Size > h.appConfig.MaxFileSize,
+		})
+	}
+
+	return sizes
+}
+
+// measureForm counts sizes of files of multipart body over the body limit,
+// nothing is kept. Bodies are read up to measureFactor limits, file cut
+// at the end gets the size read so far and later ones aren't listed
+func (h *SaveFilesHandler) measureForm(ctx *fiber.Ctx, body io.Reader) (string, *dtos.UploadSizeResponse) {
+	var files []*multipart.FileHeader
+
+	boundary := string(ctx.Request().Header.MultipartFormBoundary())
+	if boundary != "" {
+		limit := int64(h.appConfig.BodyLimit()) * measureFactor
+		reader := multipart.NewReader(io.LimitReader(body, limit), boundary)
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
 			}
 
-			pathNumber++
-		}(formFile.Filename)
+			size, err := io.Copy(io.Discard, part)
+			if part.FormName() == "files" && part.FileName() != "" {
+				files = append(files, &multipart.FileHeader{Filename: part.FileName(), Size: size})
+			}
+			if err != nil {
+				break
+			}
+		}
 	}
 
-	wg.Wait()
+	// body is over the limit even when files aren't, e.g. with large form fields
+	if code, sizes := h.checkSizes(files); code != "" {
+		return code, sizes
+	}
+
+	return "ERR_REQUEST_TOO_LARGE", h.sizes(files)
+}
+
+// checkLimits rejects images which would blow up on decoding,
+// only image headers are read
+func (h *SaveFilesHandler) checkLimits(formFile *multipart.FileHeader, contentType string) error {
+	if !isImage(contentType) {
+		return nil
+	}
+
+	file, err := formFile.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return h.limits.CheckImage(file)
+}
 
-	ctx.Status(fiber.StatusOK).JSON(dtos.SuccessResponse(successPaths))
+// saveContent stores obj under its SHA-256 and links alias to it, obj.Key
+// is set to the content address. Sanitized data is hashed at once, streamed
+// file is hashed while uploaded under temporary key and moved to its address
+// then, or dropped if the content is stored already. Returns error code on failure
+func (h *SaveFilesHandler) saveContent(ctx context.Context, obj *adapters.S3Obj, alias string) string {
+	hash := sha256.New()
 
-	return nil
+	temp := ""
+	if obj.Body == nil {
+		hash.Write(obj.Bytes)
+	} else {
+		temp = cas.TempKey()
+		obj.Key = temp
+		obj.Body = io.TeeReader(obj.Body, hash)
+
+		if _, err := h.s3Adapter.SessionUpload(ctx, obj); err != nil {
+			log.Error("[SaveFilesHandler] Failed upload file: ", err)
+			return "ERR_UPLOAD"
+		}
+
+		// moved temporary file is gone
+		defer func() {
+			if temp == "" {
+				return
+			}
+			if err := h.s3Adapter.DeleteObj(ctx, &adapters.S3Obj{Key: temp}); err != nil {
+				log.Error("[SaveFilesHandler] Failed delete temporary file: ", err)
+			}
+		}()
+	}
+
+	key := cas.Key(hash.Sum(nil))
+
+	unlock := h.store.Lock(key)
+	defer unlock()
+
+	// the reference goes first, so other instances deleting the content keep it
+	if err := h.store.Link(ctx, alias, key); err != nil {
+		log.Error("[SaveFilesHandler] Failed add reference: ", err)
+		return "ERR_REFERENCE"
+	}
+
+	if code := h.storeContent(ctx, obj, key, &temp); code != "" {
+		if err := h.store.Unlink(ctx, map[string]string{alias: key}); err != nil {
+			log.Error("[SaveFilesHandler] Failed remove reference: ", err)
+		}
+		return code
+	}
+	obj.Key = key
+
+	return ""
 }
 
-func (h *SaveFilesHandler) readFile(file *multipart.FileHeader) ([]byte, error) {
-	openedFile, _ := file.Open()
+// storeContent puts file to its content address unless it's stored already,
+// temporary file is moved there and temp is cleared
+func (h *SaveFilesHandler) storeContent(ctx context.Context, obj *adapters.S3Obj, key string, temp *string) string {
+	stored, err := h.store.Exists(ctx, key)
+	if err != nil {
+		log.Error("[SaveFilesHandler] Failed stat file: ", err)
+		return "ERR_UPLOAD"
+	}
+
+	switch {
+	case stored:
+		// the same content is uploaded already, temporary file is deleted
+	case *temp != "":
+		if err := h.s3Adapter.MoveObj(ctx, &adapters.S3Obj{Key: *temp}, key); err != nil {
+			log.Error("[SaveFilesHandler] Failed move file to its content address: ", err)
+			return "ERR_UPLOAD"
+		}
+		*temp = ""
+	default:
+		obj.Key = key
+		if _, err := h.s3Adapter.SessionUpload(ctx, obj); err != nil {
+			log.Error("[SaveFilesHandler] Failed upload file: ", err)
+			return "ERR_UPLOAD"
+		}
+	}
+
+	return ""
+}
 
-	binaryFile, err := ioutil.ReadAll(openedFile)
+// sanitize reads the whole image to strip its metadata and fix orientation
+func (h *SaveFilesHandler) sanitize(file io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(file)
 	if err != nil {
 		return nil, err
 	}
 
-	defer func(openedFile multipart.File) {
-		err := openedFile.Close()
+	return resizer.Sanitize(data)
+}
+
+// placeholders decodes image from data, or from another reader of the file
+// when it's streamed. Nil if it can't be decoded
+func (h *SaveFilesHandler) placeholders(formFile *multipart.FileHeader, data []byte) *resizer.Placeholders {
+	var src io.Reader = bytes.NewReader(data)
+	if data == nil {
+		file, err := formFile.Open()
 		if err != nil {
-			log.Fatalf("Failed closing file %v", file.Filename)
+			log.Error("[SaveFilesHandler] Failed reopen file: ", err)
+			return nil
 		}
-	}(openedFile)
+		defer file.Close()
+		src = file
+	}
+
+	img, _, err := resizer.Decode(src)
+	if err != nil {
+		return nil
+	}
+
+	placeholders, err := h.resizer.Placeholders(img)
+	if err != nil {
+		log.Error("[SaveFilesHandler] Failed make placeholders: ", err)
+		return nil
+	}
+
+	return placeholders
+}
+
+// variantURLs are URLs variants will have once variants job is done
+func (h *SaveFilesHandler) variantURLs(key string) map[string]string {
+	if !h.resizerConfig.HasVariants() {
+		return nil
+	}
+
+	variants := make(map[string]string, len(h.resizerConfig.Variants))
+	for _, preset := range h.resizerConfig.Variants {
+		variants[preset] = h.s3Config.ObjectURL(resizer.VariantKey(key, preset))
+	}
+
+	return variants
+}
 
-	return binaryFile, nil
+func isImage(contentType string) bool {
+	return strings.HasPrefix(contentType, "image/")
+}
+
+// openFile opens multipart file for streaming and detects its content type
+// by magic bytes without reading the whole file
+func (h *SaveFilesHandler) openFile(file *multipart.FileHeader) (*uploadFile, string, error) {
+	openedFile, err := file.Open()
+	if err != nil {
+		return nil, "", err
+	}
+
+	reader := bufio.NewReaderSize(openedFile, policy.SniffLen)
+	head, err := reader.Peek(policy.SniffLen)
+	if err != nil && err != io.EOF {
+		openedFile.Close()
+		return nil, "", err
+	}
+
+	return &uploadFile{Reader: reader, file: openedFile}, policy.Detect(head), nil
+}
+
+// uploadFile reads multipart file through a sniffing buffer
+type uploadFile struct {
+	io.Reader
+	file multipart.File
+}
+
+func (f *uploadFile) Close() {
+	if err := f.file.Close(); err != nil {
+		log.Errorf("Failed closing file %v", err)
+	}
 }
@@ -0,0 +1,500 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/cas"
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/WildEgor/gImageResizer/internal/dtos"
+	"github.com/WildEgor/gImageResizer/internal/imgproxy"
+	"github.com/WildEgor/gImageResizer/internal/jobs"
+	"github.com/WildEgor/gImageResizer/internal/metadata"
+	"github.com/WildEgor/gImageResizer/internal/policy"
+	"github.com/WildEgor/gImageResizer/internal/resizer"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestSaveFilesUploadsImage(t *testing.T) {
+	s := newTestServer(t)
+	data := testPNG(t, 32, 24)
+
+	var files []dtos.UploadFilesResponse
+	resp, _ := s.do(t, uploadRequest(t, map[string][]byte{"a.png": data}), &files)
+
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+	if len(files) != 1 || files[0].Name != "a.png" {
+		t.Fatalf("got files %+v", files)
+	}
+	if !strings.HasPrefix(files[0].Url, testBaseURL+"/") || !strings.HasSuffix(files[0].Url, "-a.png") {
+		t.Errorf("got url %q", files[0].Url)
+	}
+	if files[0].BlurHash == "" || files[0].Lqip == "" {
+		t.Errorf("image has no placeholders: %+v", files[0])
+	}
+	if len(files[0].Jobs) != 1 {
+		t.Errorf("got jobs %v, want metadata job", files[0].Jobs)
+	}
+
+	sessions := s.storage.Sessions()
+	if len(sessions) != 1 {
+		t.Fatalf("got %d session uploads, want 1", len(sessions))
+	}
+	session := sessions[0]
+	if session.Bucket != "test" || session.ContentType != "image/png" {
+		t.Errorf("got upload to %q of %q", session.Bucket, session.ContentType)
+	}
+	if !bytes.Equal(session.Bytes, data) {
+		t.Errorf("stored %d bytes, want %d", len(session.Bytes), len(data))
+	}
+	if session.Metadata["blurhash"] != files[0].BlurHash {
+		t.Errorf("got blurhash metadata %q", session.Metadata["blurhash"])
+	}
+	if got := testBaseURL + "/" + session.Key; got != files[0].Url {
+		t.Errorf("stored as %q, response url is %q", session.Key, files[0].Url)
+	}
+
+	if puts := s.storage.Puts(); len(puts) != 0 {
+		t.Errorf("got %d puts, files go by session upload", len(puts))
+	}
+}
+
+func TestSaveFilesVariantURLs(t *testing.T) {
+	appConfig := &configs.AppConfig{BaseURL: testBaseURL, MaxFileSize: 1 << 20, MaxRequestSize: 1 << 20, MaxFiles: 1}
+	uploadConfig := &configs.UploadConfig{TenantHeader: "X-Tenant-ID"}
+	s3Config := &configs.S3Config{Bucket: "test", PublicURL: "https://cdn.example.com"}
+	resizerConfig := &configs.ResizerConfig{Engine: "imgproxy", Filter: "lanczos", Variants: []string{"small", "_medium"}}
+
+	memory := adapters.NewMemoryAdapter(s3Config)
+	imgResizer := resizer.NewResizer(resizerConfig)
+	limits := policy.NewLimits(appConfig, uploadConfig)
+	presets := imgproxy.NewPresets(&configs.ImgProxyConfig{DefaultPreset: "medium"})
+	tasks := jobs.NewTasks(s3Config, resizerConfig, memory, presets, imgResizer, limits)
+	queue := jobs.NewQueue(&configs.JobsConfig{MaxAttempts: 1}, jobs.NewMemoryStore(), tasks)
+	saveFiles := NewSaveFilesHandler(
+		appConfig, uploadConfig, s3Config, resizerConfig, memory, queue, imgResizer,
+		policy.NewPolicies(uploadConfig), limits, cas.NewStore(uploadConfig, memory),
+	)
+
+	app := fiber.New()
+	app.Post("/api/v1/upload", saveFiles.Handle)
+	s := &testServer{app: app, storage: memory}
+
+	var files []dtos.UploadFilesResponse
+	resp, message := s.do(t, uploadRequest(t, map[string][]byte{"a b.png": testPNG(t, 32, 24)}), &files)
+	if resp.StatusCode != fiber.StatusOK || len(files) != 1 {
+		t.Fatalf("got %d %q", resp.StatusCode, message)
+	}
+	if len(files[0].Jobs) != 2 {
+		t.Errorf("got jobs %v, want metadata and variants", files[0].Jobs)
+	}
+
+	// the job run on the uploaded file writes variants where the response points
+	key := memory.Sessions()[0].Key
+	result, err := tasks.Variants(context.Background(), &jobs.Job{Type: jobs.TypeVariants, Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files[0].Variants, result) {
+		t.Errorf("response has variants %v, job wrote %v", files[0].Variants, result)
+	}
+	for _, preset := range resizerConfig.Variants {
+		variantKey := resizer.VariantKey(key, preset)
+		if want := s3Config.ObjectURL(variantKey); files[0].Variants[preset] != want {
+			t.Errorf("got %s url %q, want %q", preset, files[0].Variants[preset], want)
+		}
+		if memory.Object("test", variantKey) == nil {
+			t.Errorf("no %s at %q", preset, variantKey)
+		}
+	}
+}
+
+func TestSaveFilesDetectsContentType(t *testing.T) {
+	s := newTestServer(t)
+
+	var files []dtos.UploadFilesResponse
+	resp, _ := s.do(t, uploadRequest(t, map[string][]byte{
+		"doc.pdf": []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n"),
+	}), &files)
+
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+	if len(files) != 1 || len(files[0].Jobs) != 0 || files[0].BlurHash != "" {
+		t.Errorf("non-image got derived assets: %+v", files)
+	}
+
+	sessions := s.storage.Sessions()
+	if len(sessions) != 1 || sessions[0].ContentType != "application/pdf" {
+		t.Fatalf("got sessions %+v", sessions)
+	}
+}
+
+func TestSaveFilesRejects(t *testing.T) {
+	s := newTestServer(t, func(c *configs.UploadConfig) {
+		c.AllowedTypes = []string{"image/*"}
+	})
+
+	tests := []struct {
+		name    string
+		req     *http.Request
+		status  int
+		message string
+	}{
+		{"not multipart", httpRequest(http.MethodPost, "/api/v1/upload/"), fiber.StatusBadRequest, "ERR_MULTIPART"},
+		{"no files", uploadRequest(t, nil), fiber.StatusBadRequest, "ERR_EMPTY_FILES"},
+		{"type", uploadRequest(t, map[string][]byte{"a.txt": []byte("hello")}), fiber.StatusUnsupportedMediaType, "ERR_TYPE_NOT_ALLOWED"},
+		{"svg", uploadRequest(t, map[string][]byte{"a.svg": []byte(`<svg onload="alert(1)"/>`)}), fiber.StatusUnsupportedMediaType, "ERR_TYPE_NOT_ALLOWED"},
+		{"mismatch", uploadRequest(t, map[string][]byte{"a.html": testPNG(t, 1, 1)}), fiber.StatusUnsupportedMediaType, "ERR_EXTENSION_MISMATCH"},
+	}
+
+	for _, tt := range tests {
+		resp, message := s.do(t, tt.req, nil)
+		if resp.StatusCode != tt.status || message != tt.message {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, resp.StatusCode, message, tt.status, tt.message)
+		}
+	}
+
+	if sessions := s.storage.Sessions(); len(sessions) != 0 {
+		t.Errorf("rejected files were uploaded: %+v", sessions)
+	}
+}
+
+func TestSaveFilesRejectsSizes(t *testing.T) {
+	const mb = 1024 * 1024
+
+	s := newLimitedTestServer(t, nil, func(c *configs.AppConfig) {
+		c.MaxFiles = 2
+		c.MaxFileSize = 16
+		c.MaxRequestSize = 25
+	})
+
+	// files are keyed by name, sizes are the expected ones
+	tests := []struct {
+		name    string
+		files   map[string]int
+		chunked bool
+		message string
+	}{
+		{"too many files", map[string]int{"a": 1, "b": 1, "c": 1}, false, "ERR_TOO_MANY_FILES"},
+		{"file too large", map[string]int{"a": 10, "b": 17}, false, "ERR_FILE_TOO_LARGE"},
+		{"request too large", map[string]int{"a": 10, "b": 16}, false, "ERR_REQUEST_TOO_LARGE"},
+		// bodies over the body limit are measured while streamed
+		{"body over limit", map[string]int{"a": 10, "b": 2 * mb}, false, "ERR_FILE_TOO_LARGE"},
+		{"chunked body over limit", map[string]int{"a": 10, "b": 2 * mb}, true, "ERR_FILE_TOO_LARGE"},
+	}
+
+	for _, tt := range tests {
+		files := make(map[string][]byte, len(tt.files))
+		var size int64
+		for name, n := range tt.files {
+			files[name] = bytes.Repeat([]byte("x"), n)
+			size += int64(n)
+		}
+
+		req := uploadRequest(t, files)
+		if tt.chunked {
+			chunkedRequest(req)
+		}
+
+		var sizes dtos.UploadSizeResponse
+		status, message := s.fail(t, req, &sizes)
+		if status != fiber.StatusRequestEntityTooLarge || message != tt.message {
+			t.Errorf("%s: got %d %q, want 413 %q", tt.name, status, message, tt.message)
+			continue
+		}
+
+		if sizes.MaxFiles != 2 || sizes.MaxFileSize != 16 || sizes.MaxRequestSize != 25 {
+			t.Errorf("%s: got limits %+v", tt.name, sizes)
+		}
+		if sizes.Size != size || len(sizes.Files) != len(tt.files) {
+			t.Errorf("%s: got size %d of %d files, want %d of %d", tt.name, sizes.Size, len(sizes.Files), size, len(tt.files))
+		}
+		for _, file := range sizes.Files {
+			want := int64(tt.files[file.Name])
+			if file.Size != want || file.TooLarge != (want > 16) {
+				t.Errorf("%s: got %+v, want size %d", tt.name, file, want)
+			}
+		}
+	}
+
+	if sessions := s.storage.Sessions(); len(sessions) != 0 {
+		t.Errorf("rejected files were uploaded: %+v", sessions)
+	}
+}
+
+func TestSaveFilesRejectsImageLimits(t *testing.T) {
+	s := newTestServer(t, func(c *configs.UploadConfig) {
+		c.MaxWidth = 16
+		c.MaxResolution = 0.0002
+		c.MaxAnimationFrames = 2
+	})
+
+	tests := []struct {
+		name    string
+		data    []byte
+		message string
+		limit   string
+		max     float64
+		actual  float64
+	}{
+		{"width", testPNG(t, 32, 4), "ERR_IMAGE_WIDTH", policy.LimitWidth, 16, 32},
+		{"resolution", testPNG(t, 16, 16), "ERR_IMAGE_RESOLUTION", policy.LimitResolution, 0.0002, 0.000256},
+		{"frames", testGIF(t, 3), "ERR_ANIMATION_FRAMES", policy.LimitFrames, 2, 3},
+	}
+
+	for _, tt := range tests {
+		var limit dtos.UploadLimitResponse
+		status, message := s.fail(t, uploadRequest(t, map[string][]byte{"a.png": testPNG(t, 4, 4), "b": tt.data}), &limit)
+		if status != fiber.StatusUnprocessableEntity || message != tt.message {
+			t.Errorf("%s: got %d %q, want 422 %q", tt.name, status, message, tt.message)
+			continue
+		}
+
+		want := dtos.UploadLimitResponse{Name: "b", Limit: tt.limit, Max: tt.max, Actual: tt.actual}
+		if limit != want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, limit, want)
+		}
+	}
+
+	// no file of rejected request is stored
+	if sessions := s.storage.Sessions(); len(sessions) != 0 {
+		t.Errorf("rejected files were uploaded: %+v", sessions)
+	}
+}
+
+// keepMetadataRequest uploads a.png with keepMetadata field unless keep is empty
+func keepMetadataRequest(t *testing.T, data []byte, keep string) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("files", "a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	if keep != "" {
+		form.WriteField("keepMetadata", keep)
+	}
+	form.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/upload/", &body)
+	req.Header.Set(fiber.HeaderContentType, form.FormDataContentType())
+
+	return req
+}
+
+func TestSaveFilesStripsMetadata(t *testing.T) {
+	s := newTestServer(t, func(c *configs.UploadConfig) {
+		c.StripMetadata = true
+	})
+	// PNG shown rotated by EXIF orientation
+	data := metadata.Strip(testPNG(t, 16, 8), 6)
+
+	tests := []struct {
+		keep   string
+		strip  bool
+		width  int
+		height int
+	}{
+		{"", true, 8, 16},
+		{"false", true, 8, 16},
+		{"true", false, 16, 8},
+	}
+
+	for _, tt := range tests {
+		var files []dtos.UploadFilesResponse
+		if resp, message := s.do(t, keepMetadataRequest(t, data, tt.keep), &files); resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("keepMetadata=%q: got %d %q", tt.keep, resp.StatusCode, message)
+		}
+
+		sessions := s.storage.Sessions()
+		stored := sessions[len(sessions)-1].Bytes
+		if bytes.Equal(stored, data) == tt.strip {
+			t.Errorf("keepMetadata=%q: file is stored as is %v, want %v", tt.keep, !tt.strip, !tt.strip)
+		}
+
+		// orientation is baked in when stripped
+		config, err := png.DecodeConfig(bytes.NewReader(stored))
+		if err != nil {
+			t.Fatalf("keepMetadata=%q: %v", tt.keep, err)
+		}
+		if config.Width != tt.width || config.Height != tt.height {
+			t.Errorf("keepMetadata=%q: got %dx%d, want %dx%d", tt.keep, config.Width, config.Height, tt.width, tt.height)
+		}
+		if want := map[bool]int{true: 1, false: 6}[tt.strip]; metadata.Orientation(stored) != want {
+			t.Errorf("keepMetadata=%q: got orientation %d, want %d", tt.keep, metadata.Orientation(stored), want)
+		}
+	}
+
+	resp, message := s.do(t, keepMetadataRequest(t, data, "maybe"), nil)
+	if resp.StatusCode != fiber.StatusBadRequest || message != "ERR_KEEP_METADATA" {
+		t.Errorf("bad keepMetadata: got %d %q, want 400 ERR_KEEP_METADATA", resp.StatusCode, message)
+	}
+}
+
+// chunkedRequest sends body of unknown size
+func chunkedRequest(req *http.Request) {
+	req.ContentLength = -1
+	req.TransferEncoding = []string{"chunked"}
+}
+
+func TestLimitBodyRejectsLargeBodies(t *testing.T) {
+	s := newLimitedTestServer(t, nil, func(c *configs.AppConfig) {
+		c.MaxRequestSize = 1024
+	})
+
+	body := dtos.DeleteFilesRequest{Keys: []string{strings.Repeat("a", 2*1024*1024)}}
+	for _, chunked := range []bool{false, true} {
+		req := jsonRequest(t, http.MethodPost, "/api/v1/upload/delete", body, nil)
+		if chunked {
+			chunkedRequest(req)
+		}
+
+		resp, message := s.do(t, req, nil)
+		if resp.StatusCode != fiber.StatusRequestEntityTooLarge || message != "ERR_REQUEST_TOO_LARGE" {
+			t.Errorf("chunked=%v: got %d %q, want 413 ERR_REQUEST_TOO_LARGE", chunked, resp.StatusCode, message)
+		}
+	}
+
+	// bodies under the limit are read as usual
+	req := jsonRequest(t, http.MethodPost, "/api/v1/upload/delete", dtos.DeleteFilesRequest{Keys: []string{"a.png"}}, nil)
+	chunkedRequest(req)
+	if resp, _ := s.do(t, req, nil); resp.StatusCode != fiber.StatusOK {
+		t.Errorf("got status %d, want 200", resp.StatusCode)
+	}
+}
+
+func httpRequest(method, target string) *http.Request {
+	req, _ := http.NewRequest(method, target, nil)
+	return req
+}
+
+// failingUploads is storage failing to upload files named suffix
+type failingUploads struct {
+	*adapters.MemoryAdapter
+	suffix string
+}
+
+func (f failingUploads) SessionUpload(ctx context.Context, obj *adapters.S3Obj) (*string, error) {
+	if strings.HasSuffix(obj.Key, f.suffix) {
+		return nil, errors.New("upload failed")
+	}
+
+	return f.MemoryAdapter.SessionUpload(ctx, obj)
+}
+
+func TestSaveFilesReportsFailedFiles(t *testing.T) {
+	s := newWrappedTestServer(t, func(memory *adapters.MemoryAdapter) adapters.IS3Adapter {
+		return failingUploads{MemoryAdapter: memory, suffix: "-b.png"}
+	})
+
+	resp, err := s.app.Test(uploadRequest(t, map[string][]byte{
+		"a.png": testPNG(t, 8, 8),
+		"b.png": testPNG(t, 8, 8),
+	}), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Message string                     `json:"message"`
+		Data    []dtos.UploadFilesResponse `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != fiber.StatusInternalServerError || body.Message != "ERR_UPLOAD" {
+		t.Fatalf("got %d %q, want 500 ERR_UPLOAD", resp.StatusCode, body.Message)
+	}
+
+	results := make(map[string]dtos.UploadFilesResponse)
+	for _, file := range body.Data {
+		results[file.Name] = file
+	}
+	if a := results["a.png"]; a.Error != "" || a.Url == "" {
+		t.Errorf("uploaded file got %+v", a)
+	}
+	if b := results["b.png"]; b.Error != "ERR_UPLOAD" || b.Url != "" {
+		t.Errorf("failed file got %+v", b)
+	}
+}
+
+func TestSaveFilesContentAddressed(t *testing.T) {
+	s := newTestServer(t, func(c *configs.UploadConfig) {
+		c.ContentAddressed = true
+	})
+	data := testPNG(t, 8, 8)
+	sum := sha256.Sum256(data)
+	key := cas.Key(sum[:])
+
+	keys := make(map[string]bool)
+	for _, name := range []string{"a.png", "b.png"} {
+		var files []dtos.UploadFilesResponse
+		resp, _ := s.do(t, uploadRequest(t, map[string][]byte{name: data}), &files)
+		if resp.StatusCode != fiber.StatusOK || len(files) != 1 {
+			t.Fatalf("upload of %s: got %d %+v", name, resp.StatusCode, files)
+		}
+
+		// every upload has own key without slashes, so it's one path segment
+		alias := strings.TrimPrefix(files[0].Url, testBaseURL+"/")
+		if !strings.HasSuffix(alias, "-"+name) || strings.Contains(alias, "%2F") {
+			t.Errorf("%s got url %q", name, files[0].Url)
+		}
+		keys[alias] = true
+
+		// jobs of the same content are enqueued again, the first ones may be dead
+		if len(files[0].Jobs) != 1 {
+			t.Errorf("%s got jobs %v, want metadata job", name, files[0].Jobs)
+		}
+
+		if target, err := s.store.Target(context.Background(), alias); err != nil || target != key {
+			t.Errorf("%s refers to %q %v, want %q", name, target, err, key)
+		}
+
+		resp, _ = s.do(t, httpRequest(http.MethodGet, "/api/v1/upload/"+alias), nil)
+		if location := resp.Header.Get(fiber.HeaderLocation); resp.StatusCode != fiber.StatusFound || !strings.HasSuffix(location, "/plain/s3://test/"+key) {
+			t.Errorf("%s: got %d redirect to %q", name, resp.StatusCode, location)
+		}
+	}
+	if len(keys) != 2 {
+		t.Errorf("uploads share key: %v", keys)
+	}
+
+	// every file is streamed once, to a temporary key
+	sessions := s.storage.Sessions()
+	if len(sessions) != 2 {
+		t.Fatalf("got %d session uploads, want 2", len(sessions))
+	}
+	for _, session := range sessions {
+		if !strings.HasPrefix(session.Key, cas.TempPrefix) {
+			t.Errorf("streamed to %q, not a temporary key", session.Key)
+		}
+		if s.storage.Object("test", session.Key) != nil {
+			t.Errorf("temporary %q is left", session.Key)
+		}
+	}
+
+	obj := s.storage.Object("test", key)
+	if obj == nil || !bytes.Equal(obj.Bytes, data) || obj.ContentType != "image/png" {
+		t.Fatalf("got %+v at the content address", obj)
+	}
+	if obj.Metadata["blurhash"] == "" {
+		t.Errorf("placeholders are lost on move")
+	}
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"testing"
+
+	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/cas"
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/WildEgor/gImageResizer/internal/imgproxy"
+	"github.com/WildEgor/gImageResizer/internal/jobs"
+	"github.com/WildEgor/gImageResizer/internal/policy"
+	"github.com/WildEgor/gImageResizer/internal/resizer"
+	"github.com/gofiber/fiber/v2"
+)
+
+const testBaseURL = "http://localhost:8888/api/v1/upload"
+
+// testServer runs upload, direct upload, download and delete handlers on MemoryAdapter,
+// jobs are queued but not run
+type testServer struct {
+	app     *fiber.App
+	storage *adapters.MemoryAdapter
+	store   *cas.Store
+	presign *PresignUploadHandler
+}
+
+func newTestServer(t *testing.T, configure ...func(*configs.UploadConfig)) *testServer {
+	t.Helper()
+
+	return newWrappedTestServer(t, nil, configure...)
+}
+
+// newWrappedTestServer runs handlers on storage wrapping MemoryAdapter, e.g. failing some calls
+func newWrappedTestServer(
+	t *testing.T,
+	wrap func(*adapters.MemoryAdapter) adapters.IS3Adapter,
+	configure ...func(*configs.UploadConfig),
+) *testServer {
+	t.Helper()
+
+	return newLimitedTestServer(t, wrap, nil, configure...)
+}
+
+// newLimitedTestServer runs handlers with APP_MAX_* limits set by limit
+func newLimitedTestServer(
+	t *testing.T,
+	wrap func(*adapters.MemoryAdapter) adapters.IS3Adapter,
+	limit func(*configs.AppConfig),
+	configure ...func(*configs.UploadConfig),
+) *testServer {
+	t.Helper()
+
+	appConfig := &configs.AppConfig{
+		BaseURL:        testBaseURL,
+		MaxFileSize:    50 * 1024 * 1024,
+		MaxRequestSize: 100 * 1024 * 1024,
+		MaxFiles:       20,
+	}
+	if limit != nil {
+		limit(appConfig)
+	}
+	uploadConfig := &configs.UploadConfig{
+		TenantHeader:       "X-Tenant-ID",
+		MaxResolution:      50,
+		MaxAnimationFrames: 64,
+	}
+	for _, c := range configure {
+		c(uploadConfig)
+	}
+	s3Config := &configs.S3Config{Bucket: "test"}
+	resizerConfig := &configs.ResizerConfig{Engine: "imgproxy", Filter: "lanczos"}
+	storageConfig := &configs.StorageConfig{Driver: "s3", DownloadMode: "presign"}
+	imgProxyConfig := &configs.ImgProxyConfig{BaseURL: "http://imgproxy", DefaultPreset: "medium"}
+	jobsConfig := &configs.JobsConfig{MaxAttempts: 1}
+
+	memory := adapters.NewMemoryAdapter(s3Config)
+	var storage adapters.IS3Adapter = memory
+	if wrap != nil {
+		storage = wrap(memory)
+	}
+	presets := imgproxy.NewPresets(imgProxyConfig)
+	imgResizer := resizer.NewResizer(resizerConfig)
+	tasks := jobs.NewTasks(s3Config, resizerConfig, storage, presets, imgResizer, policy.NewLimits(appConfig, uploadConfig))
+	queue := jobs.NewQueue(jobsConfig, jobs.NewMemoryStore(), tasks)
+	store := cas.NewStore(uploadConfig, storage)
+
+	saveFiles := NewSaveFilesHandler(
+		appConfig, uploadConfig, s3Config, resizerConfig, storage, queue, imgResizer,
+		policy.NewPolicies(uploadConfig), policy.NewLimits(appConfig, uploadConfig), store,
+	)
+	downloadFile := NewDownloadFileHandler(
+		imgProxyConfig, appConfig, storageConfig, s3Config, resizerConfig, storage, presets, imgResizer,
+		policy.NewLimits(appConfig, uploadConfig), store,
+	)
+
+	presignUpload := NewPresignUploadHandler(s3Config, uploadConfig, storage, policy.NewPolicies(uploadConfig), policy.NewLimits(appConfig, uploadConfig))
+	finalizeUpload := NewFinalizeUploadHandler(
+		appConfig, resizerConfig, uploadConfig, storage, queue,
+		policy.NewPolicies(uploadConfig), policy.NewLimits(appConfig, uploadConfig),
+	)
+	deleteFile := NewDeleteFileHandler(storage, tasks, store)
+
+	// bodies are streamed as in the app
+	app := fiber.New(fiber.Config{
+		BodyLimit:                    appConfig.BodyLimit(),
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
+	})
+	app.Use(LimitBody(appConfig.BodyLimit()))
+	upload := app.Group("/api/v1/upload")
+	upload.Post("/", saveFiles.Handle)
+	upload.Post("/presign", presignUpload.Handle)
+	upload.Post("/finalize", finalizeUpload.Handle)
+	upload.Post("/delete", deleteFile.Batch)
+	upload.Get("/", downloadFile.List)
+	upload.Head("/:key", downloadFile.Head)
+	upload.Get("/:key", downloadFile.Handle)
+	upload.Delete("/:key", deleteFile.Handle)
+
+	return &testServer{app: app, storage: memory, store: store, presign: presignUpload}
+}
+
+// do sends request to the app and decodes data of successful JSON response,
+// returns message of GenericResponse
+func (s *testServer) do(t *testing.T, req *http.Request, data interface{}) (*http.Response, string) {
+	t.Helper()
+
+	resp, err := s.app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.Method, req.URL, err)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	message := ""
+	if resp.Header.Get(fiber.HeaderContentType) == fiber.MIMEApplicationJSON {
+		var generic struct {
+			Message string          `json:"message"`
+			Data    json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(body, &generic); err != nil {
+			t.Fatalf("%s %s: bad json %s", req.Method, req.URL, body)
+		}
+		message = generic.Message
+		// errors have details of their own
+		if data != nil && resp.StatusCode < 300 {
+			if err := json.Unmarshal(generic.Data, data); err != nil {
+				t.Fatalf("%s %s: bad data %s", req.Method, req.URL, generic.Data)
+			}
+		}
+	}
+
+	return resp, message
+}
+
+// fail sends request expected to fail and decodes data of error response,
+// returns status and message
+func (s *testServer) fail(t *testing.T, req *http.Request, data interface{}) (int, string) {
+	t.Helper()
+
+	resp, err := s.app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.Method, req.URL, err)
+	}
+	defer resp.Body.Close()
+
+	body := struct {
+		Message string      `json:"message"`
+		Data    interface{} `json:"data"`
+	}{Data: data}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("%s %s: bad json: %v", req.Method, req.URL, err)
+	}
+
+	return resp.StatusCode, body.Message
+}
+
+// uploadRequest makes multipart request with files by name
+func uploadRequest(t *testing.T, files map[string][]byte) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, data := range files {
+		part, err := form.CreateFormFile("files", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(data)
+	}
+	form.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/upload/", &body)
+	req.Header.Set(fiber.HeaderContentType, form.FormDataContentType())
+
+	return req
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// testGIF is animation of frames 4x4 images
+func testGIF(t *testing.T, frames int) []byte {
+	t.Helper()
+
+	anim := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+		frame.SetColorIndex(i%4, i%4, 1)
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// jsonRequest makes request with JSON body
+func jsonRequest(t *testing.T, method, target string, body interface{}, headers map[string]string) *http.Request {
+	t.Helper()
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest(method, target, bytes.NewReader(b))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	return req
+}
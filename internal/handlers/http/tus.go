@@ -0,0 +1,821 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/WildEgor/gImageResizer/internal/dtos"
+	"github.com/WildEgor/gImageResizer/internal/jobs"
+	"github.com/WildEgor/gImageResizer/internal/locks"
+	"github.com/WildEgor/gImageResizer/internal/policy"
+	"github.com/gofiber/fiber/v2"
+	uuid "github.com/google/uuid"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration,checksum"
+	tusPrefix     = ".tus/"
+	// statusChecksumMismatch is tus status of chunk not matching Upload-Checksum
+	statusChecksumMismatch = 460
+)
+
+var (
+	errTusExpired           = errors.New("[TusHandler] Upload expired")
+	errTusChecksum          = errors.New("[TusHandler] Bad Upload-Checksum")
+	errTusChecksumMismatch  = errors.New("[TusHandler] Chunk doesn't match Upload-Checksum")
+	errTusChecksumAlgorithm = errors.New("[TusHandler] Unsupported checksum algorithm")
+)
+
+// tusChecksums are algorithms of Upload-Checksum, tus requires sha1
+var tusChecksums = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// tusUpload is a state of tus upload. It's stored as <id>.info object next to
+// the S3 multipart upload, and upload offset is derived from uploaded parts,
+// so uploads survive restarts. Multipart upload is created with the first
+// chunk, so the file gets content type detected by magic bytes
+type tusUpload struct {
+	ID          string    `json:"id"`
+	Key         string    `json:"key"`
+	UploadID    string    `json:"uploadId"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"contentType"`
+	Length      int64     `json:"length"`
+	Metadata    string    `json:"metadata"`
+	Tenant      string    `json:"tenant,omitempty"`
+	Completed   bool      `json:"completed"`
+	CompletedAt time.Time `json:"completedAt"`
+	// ExpiresAt is when unfinished upload is deleted, completed one loses only its info
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (u *tusUpload) expired(now time.Time) bool {
+	return !now.Before(u.ExpiresAt)
+}
+
+type TusHandler struct {
+	appConfig     *configs.AppConfig
+	s3Config      *configs.S3Config
+	resizerConfig *configs.ResizerConfig
+	uploadConfig  *configs.UploadConfig
+	s3Adapter     adapters.IS3Adapter
+	queue         *jobs.Queue
+	policies      *policy.Policies
+	limits        *policy.Limits
+	locks         locks.Keyed
+}
+
+func NewTusHandler(
+	appConfig *configs.AppConfig,
+	s3Config *configs.S3Config,
+	resizerConfig *configs.ResizerConfig,
+	uploadConfig *configs.UploadConfig,
+	s3Adapter adapters.IS3Adapter,
+	queue *jobs.Queue,
+	policies *policy.Policies,
+	limits *policy.Limits,
+) *TusHandler {
+	return &TusHandler{
+		appConfig:     appConfig,
+		s3Config:      s3Config,
+		resizerConfig: resizerConfig,
+		uploadConfig:  uploadConfig,
+		s3Adapter:     s3Adapter,
+		queue:         queue,
+		policies:      policies,
+		limits:        limits,
+	}
+}
+
+// Resumable checks tus protocol version of every request except OPTIONS
+func (h *TusHandler) Resumable(ctx *fiber.Ctx) error {
+	ctx.Set("Tus-Resumable", tusVersion)
+
+	if ctx.Method() != fiber.MethodOptions && ctx.Get("Tus-Resumable") != tusVersion {
+		ctx.Set("Tus-Version", tusVersion)
+		return ctx.Status(fiber.StatusPreconditionFailed).JSON(dtos.ErrResponse("ERR_TUS_VERSION"))
+	}
+
+	return ctx.Next()
+}
+
+// Options godoc
+//
+//	@Summary		tus server capabilities
+//	@Tags			tus
+//	@Router			/api/v1/tus [options]
+func (h *TusHandler) Options(ctx *fiber.Ctx) error {
+	ctx.Set("Tus-Version", tusVersion)
+	ctx.Set("Tus-Extension", tusExtensions)
+	ctx.Set("Tus-Max-Size", strconv.FormatInt(h.maxSize(), 10))
+	ctx.Set("Tus-Checksum-Algorithm", "md5,sha1,sha256")
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// Create godoc
+//
+//	@Summary		Create tus upload
+//	@Description	Starts resumable upload, Upload-Metadata may contain filename and filetype
+//	@Tags			tus
+//	@Param			Upload-Length	header	int		true	"Upload size"
+//	@Param			Upload-Metadata	header	string	false	"tus metadata"
+//	@Router			/api/v1/tus [post]
+func (h *TusHandler) Create(ctx *fiber.Ctx) error {
+	length, err := strconv.ParseInt(ctx.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_TUS_LENGTH"))
+	}
+
+	if length > h.maxSize() {
+		return ctx.Status(fiber.StatusRequestEntityTooLarge).JSON(dtos.ErrResponse("ERR_TUS_MAX_SIZE"))
+	}
+
+	metadata := parseTusMetadata(ctx.Get("Upload-Metadata"))
+
+	name := path.Base("/" + strings.ReplaceAll(metadata["filename"], "\\", "/"))
+	if name == "/" {
+		name = "file"
+	}
+
+	tenant := ctx.Get(h.uploadConfig.TenantHeader)
+	filePolicy := h.policies.For(policy.RouteTus, tenant)
+
+	// declared type is checked here, magic bytes are checked with the first chunk
+	contentType := metadata["filetype"]
+	if contentType == "" {
+		contentType = fiber.MIMEOctetStream
+		if !filePolicy.AllowsExtension(name) {
+			return policyErr(ctx, policy.ErrExtensionNotAllowed)
+		}
+	} else if err := filePolicy.Check(name, contentType); err != nil {
+		return policyErr(ctx, err)
+	}
+
+	upload := &tusUpload{
+		ID:          uuid.New().String(),
+		Key:         uuid.New().String() + "-" + name,
+		Name:        name,
+		ContentType: contentType,
+		Length:      length,
+		Metadata:    ctx.Get("Upload-Metadata"),
+		Tenant:      tenant,
+		ExpiresAt:   time.Now().Add(h.uploadConfig.TusExpiry),
+	}
+
+	// Nothing to wait for, multipart upload needs at least one part
+	if length == 0 {
+		upload.ContentType = fiber.MIMEOctetStream
+		if err := h.create(ctx.Context(), upload); err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_TUS_CREATE"))
+		}
+		if err := h.uploadPart(ctx.Context(), upload, 1, nil); err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_TUS_UPLOAD"))
+		}
+		if err := h.finish(ctx.Context(), upload); err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_TUS_UPLOAD"))
+		}
+	} else if err := h.save(ctx.Context(), upload); err != nil {
+		log.Errorf("[TusHandler] Failed save upload %v", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_TUS_CREATE"))
+	}
+
+	ctx.Location(ctx.BaseURL() + strings.TrimSuffix(ctx.Path(), "/") + "/" + upload.ID)
+	setUploadExpires(ctx, upload)
+
+	return ctx.SendStatus(fiber.StatusCreated)
+}
+
+// Head godoc
+//
+//	@Summary		Get tus upload offset
+//	@Description	Unfinished uploads expire after UPLOAD_TUS_EXPIRY, expired ones get 410
+//	@Tags			tus
+//	@Param			id	path	string	true	"Upload id"
+//	@Router			/api/v1/tus/{id} [head]
+func (h *TusHandler) Head(ctx *fiber.Ctx) error {
+	upload, err := h.load(ctx.Context(), ctx.Params("id"))
+	if err != nil {
+		return ctx.SendStatus(tusErrStatus(err))
+	}
+
+	if !upload.Completed && upload.expired(time.Now()) {
+		return ctx.SendStatus(tusErrStatus(errTusExpired))
+	}
+
+	offset, _, _, err := h.offset(ctx.Context(), upload)
+	if err != nil {
+		return ctx.SendStatus(tusErrStatus(err))
+	}
+	setUploadExpires(ctx, upload)
+
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	ctx.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	ctx.Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		ctx.Set("Upload-Metadata", upload.Metadata)
+	}
+
+	return ctx.SendStatus(fiber.StatusOK)
+}
+
+// Patch godoc
+//
+//	@Summary		Append data to tus upload
+//	@Description	Body is buffered up to S3 part size, last chunk completes the upload.
+//	@Description	Chunk not matching Upload-Checksum gets 460 and is dropped
+//	@Tags			tus
+//	@Accept			application/offset+octet-stream
+//	@Param			id				path	string	true	"Upload id"
+//	@Param			Upload-Offset	header	int		true	"Current offset"
+//	@Param			Upload-Checksum	header	string	false	"Algorithm and base64 checksum of the chunk, e.g. sha1 ..."
+//	@Router			/api/v1/tus/{id} [patch]
+func (h *TusHandler) Patch(ctx *fiber.Ctx) error {
+	if ctx.Get(fiber.HeaderContentType) != "application/offset+octet-stream" {
+		return ctx.Status(fiber.StatusUnsupportedMediaType).JSON(dtos.ErrResponse("ERR_TUS_CONTENT_TYPE"))
+	}
+
+	clientOffset, err := strconv.ParseInt(ctx.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_TUS_OFFSET"))
+	}
+
+	unlock := h.lock(ctx.Params("id"))
+	defer unlock()
+
+	upload, err := h.load(ctx.Context(), ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(tusErrStatus(err)).JSON(dtos.ErrResponse("ERR_TUS_UPLOAD"))
+	}
+
+	if !upload.Completed && upload.expired(time.Now()) {
+		return ctx.Status(tusErrStatus(errTusExpired)).JSON(dtos.ErrResponse("ERR_TUS_EXPIRED"))
+	}
+
+	offset, parts, tail, err := h.offset(ctx.Context(), upload)
+	if err != nil {
+		return ctx.Status(tusErrStatus(err)).JSON(dtos.ErrResponse("ERR_TUS_UPLOAD"))
+	}
+
+	if clientOffset != offset {
+		return ctx.Status(fiber.StatusConflict).JSON(dtos.ErrResponse("ERR_TUS_OFFSET"))
+	}
+
+	body := ctx.Body()
+
+	// nothing is stored until the chunk is verified
+	if err := checkTusChecksum(ctx.Get("Upload-Checksum"), body); err != nil {
+		switch err {
+		case errTusChecksumMismatch:
+			return ctx.Status(statusChecksumMismatch).JSON(dtos.ErrResponse("ERR_TUS_CHECKSUM_MISMATCH"))
+		case errTusChecksumAlgorithm:
+			return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_TUS_CHECKSUM_ALGORITHM"))
+		default:
+			return ctx.Status(fiber.StatusBadRequest).JSON(dtos.ErrResponse("ERR_TUS_CHECKSUM"))
+		}
+	}
+
+	newOffset := offset + int64(len(body))
+	if newOffset > upload.Length {
+		return ctx.Status(fiber.StatusRequestEntityTooLarge).JSON(dtos.ErrResponse("ERR_TUS_LENGTH"))
+	}
+	final := newOffset == upload.Length
+
+	// first chunk should have at least policy.SniffLen bytes for detection
+	if upload.UploadID == "" && len(body) > 0 {
+		contentType := policy.Detect(body)
+		filePolicy := h.policies.For(policy.RouteTus, upload.Tenant)
+		if err := filePolicy.Check(upload.Name, contentType); err != nil {
+			return policyErr(ctx, err)
+		}
+
+		upload.ContentType = contentType
+		if err := h.create(ctx.Context(), upload); err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_TUS_UPLOAD"))
+		}
+		if err := h.save(ctx.Context(), upload); err != nil {
+			log.Errorf("[TusHandler] Failed save upload %v", err)
+			return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_TUS_UPLOAD"))
+		}
+	}
+
+	// zero length chunk before the first one has nothing to upload
+	if upload.UploadID == "" {
+		ctx.Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+		setUploadExpires(ctx, upload)
+		return ctx.SendStatus(fiber.StatusNoContent)
+	}
+
+	partNumber := nextPart(parts)
+
+	// Data not yet uploaded as a part is kept in <id>.<part number>.part object,
+	// so every non-final part has full S3 part size
+	data := append(tail, body...)
+	hadTail := len(tail) > 0
+	for len(data) > 0 && !upload.Completed {
+		n := int64(len(data))
+		if n > h.s3Config.PartSize {
+			n = h.s3Config.PartSize
+		}
+		if n < h.s3Config.PartSize && !final {
+			break
+		}
+
+		if err := h.uploadPart(ctx.Context(), upload, partNumber, data[:n]); err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_TUS_UPLOAD"))
+		}
+
+		// First part already contains the tail. Tail of uploaded part isn't
+		// counted in offset, so it's fine to leave it when delete fails
+		if hadTail {
+			if err := h.s3Adapter.DeleteObj(ctx.Context(), &adapters.S3Obj{Key: tailKey(upload.ID, partNumber)}); err != nil {
+				log.Warnf("[TusHandler] Failed delete tail %v", err)
+			}
+			hadTail = false
+		}
+
+		partNumber++
+		data = data[n:]
+	}
+
+	if len(data) > 0 {
+		if err := h.s3Adapter.PutObj(ctx.Context(), &adapters.S3Obj{
+			Key:           tailKey(upload.ID, partNumber),
+			Bytes:         data,
+			ContentType:   fiber.MIMEOctetStream,
+			ContentLength: int64(len(data)),
+		}); err != nil {
+			log.Errorf("[TusHandler] Failed save tail %v", err)
+			return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_TUS_UPLOAD"))
+		}
+	}
+
+	if final && !upload.Completed {
+		if err := h.finish(ctx.Context(), upload); err != nil {
+			if isLimitErr(err) {
+				return limitErr(ctx, upload.Name, err)
+			}
+			return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_TUS_UPLOAD"))
+		}
+	}
+
+	ctx.Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	setUploadExpires(ctx, upload)
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// Get godoc
+//
+//	@Summary		Get uploaded file of completed tus upload
+//	@Tags			tus
+//	@Produce		json
+//	@Param			id	path	string	true	"Upload id"
+//	@Router			/api/v1/tus/{id} [get]
+func (h *TusHandler) Get(ctx *fiber.Ctx) error {
+	upload, err := h.load(ctx.Context(), ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(tusErrStatus(err)).JSON(dtos.ErrResponse("ERR_TUS_UPLOAD"))
+	}
+
+	if !upload.Completed {
+		return ctx.Status(fiber.StatusConflict).JSON(dtos.ErrResponse("ERR_TUS_INCOMPLETE"))
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(dtos.SuccessResponse(dtos.UploadFilesResponse{
+		Name:       upload.Name,
+		Url:        h.appConfig.BaseURL + "/" + url.PathEscape(upload.Key),
+		UploadedAt: upload.CompletedAt,
+	}))
+}
+
+// Terminate godoc
+//
+//	@Summary		Terminate tus upload
+//	@Tags			tus
+//	@Param			id	path	string	true	"Upload id"
+//	@Router			/api/v1/tus/{id} [delete]
+func (h *TusHandler) Terminate(ctx *fiber.Ctx) error {
+	unlock := h.lock(ctx.Params("id"))
+	defer unlock()
+
+	upload, err := h.load(ctx.Context(), ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(tusErrStatus(err)).JSON(dtos.ErrResponse("ERR_TUS_UPLOAD"))
+	}
+
+	if !upload.Completed && upload.UploadID != "" {
+		err := h.s3Adapter.AbortMultipart(ctx.Context(), &adapters.S3Obj{
+			Key:      upload.Key,
+			UploadID: upload.UploadID,
+		})
+		if err != nil && !errors.Is(err, adapters.ErrNotFound) {
+			log.Errorf("[TusHandler] Failed abort upload %v", err)
+			return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_TUS_TERMINATE"))
+		}
+	}
+
+	if err := h.cleanup(ctx.Context(), upload, false); err != nil {
+		log.Errorf("[TusHandler] Failed delete upload %v", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(dtos.ErrResponse("ERR_TUS_TERMINATE"))
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// Purge deletes uploads expired at now: unfinished ones are aborted with their
+// tails and info, completed ones lose only info. Tails without info are deleted
+// once older than expiry. Returns how many uploads were deleted. Multipart
+// uploads whose info is lost are left to bucket lifecycle rules
+func (h *TusHandler) Purge(ctx context.Context, now time.Time) (int, error) {
+	var ids []string
+	infos := make(map[string]bool)
+	var tails []adapters.S3Obj
+
+	query := &adapters.S3ListQuery{Prefix: tusPrefix, Limit: 1000}
+	for {
+		list, err := h.s3Adapter.List(ctx, query)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, obj := range list.Objects {
+			name := strings.TrimPrefix(obj.Key, tusPrefix)
+			if id, ok := strings.CutSuffix(name, ".info"); ok {
+				ids = append(ids, id)
+				infos[id] = true
+			} else if strings.HasSuffix(name, ".part") {
+				tails = append(tails, obj)
+			}
+		}
+
+		if list.Cursor == "" {
+			break
+		}
+		query.Cursor = list.Cursor
+	}
+
+	purged := 0
+	for _, id := range ids {
+		ok, err := h.purge(ctx, id, now)
+		if err != nil {
+			log.Errorf("[TusHandler] Failed purge upload %v: %v", id, err)
+			continue
+		}
+		if ok {
+			purged++
+		}
+	}
+
+	var orphans []*adapters.S3Obj
+	for _, tail := range tails {
+		id, _, _ := strings.Cut(strings.TrimPrefix(tail.Key, tusPrefix), ".")
+		if !infos[id] && now.Sub(tail.LastModified) > h.uploadConfig.TusExpiry {
+			orphans = append(orphans, &adapters.S3Obj{Key: tail.Key})
+		}
+	}
+	if len(orphans) == 0 {
+		return purged, nil
+	}
+
+	return purged, h.s3Adapter.DeleteObjs(ctx, orphans)
+}
+
+// purge deletes upload if it's expired at now, requests to it wait
+func (h *TusHandler) purge(ctx context.Context, id string, now time.Time) (bool, error) {
+	unlock := h.lock(id)
+	defer unlock()
+
+	upload, err := h.load(ctx, id)
+	if errors.Is(err, adapters.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if !upload.expired(now) {
+		return false, nil
+	}
+
+	if !upload.Completed && upload.UploadID != "" {
+		err := h.s3Adapter.AbortMultipart(ctx, &adapters.S3Obj{
+			Key:      upload.Key,
+			UploadID: upload.UploadID,
+		})
+		if err != nil && !errors.Is(err, adapters.ErrNotFound) {
+			return false, err
+		}
+	}
+
+	return true, h.cleanup(ctx, upload, false)
+}
+
+// offset sums uploaded parts and the tail of the next part, tails of
+// uploaded parts left by failed deletes aren't counted
+func (h *TusHandler) offset(
+	ctx context.Context,
+	upload *tusUpload,
+) (int64, []adapters.S3Part, []byte, error) {
+	if upload.Completed {
+		return upload.Length, nil, nil, nil
+	}
+
+	if upload.UploadID == "" {
+		return 0, nil, nil, nil
+	}
+
+	parts, err := h.s3Adapter.ListParts(ctx, &adapters.S3Obj{
+		Key:      upload.Key,
+		UploadID: upload.UploadID,
+	})
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	var offset int64
+	for _, p := range parts {
+		offset += p.Size
+	}
+
+	r, err := h.s3Adapter.GetObj(ctx, &adapters.S3Obj{Key: tailKey(upload.ID, nextPart(parts))})
+	if errors.Is(err, adapters.ErrNotFound) {
+		return offset, parts, nil, nil
+	}
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer r.Close()
+
+	tail, err := io.ReadAll(r)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	return offset + int64(len(tail)), parts, tail, nil
+}
+
+// create starts multipart upload of the file
+func (h *TusHandler) create(ctx context.Context, upload *tusUpload) error {
+	uploadID, err := h.s3Adapter.CreateMultipart(ctx, &adapters.S3Obj{
+		Key:         upload.Key,
+		ContentType: upload.ContentType,
+	})
+	if err != nil {
+		log.Errorf("[TusHandler] Failed create upload %v", err)
+		return err
+	}
+
+	upload.UploadID = uploadID
+
+	return nil
+}
+
+func (h *TusHandler) uploadPart(
+	ctx context.Context,
+	upload *tusUpload,
+	partNumber int64,
+	data []byte,
+) error {
+	err := h.s3Adapter.UploadPart(ctx, &adapters.S3Obj{
+		Key:           upload.Key,
+		UploadID:      upload.UploadID,
+		PartNumber:    partNumber,
+		Bytes:         data,
+		ContentLength: int64(len(data)),
+	})
+	if err != nil {
+		log.Errorf("[TusHandler] Failed upload part #%v %v", partNumber, err)
+	}
+
+	return err
+}
+
+// finish completes multipart upload, the file ends up at its key. Images
+// over limits are deleted with the upload and policy.LimitError is returned
+func (h *TusHandler) finish(ctx context.Context, upload *tusUpload) error {
+	_, err := h.s3Adapter.CompleteMultipart(ctx, &adapters.S3Obj{
+		Key:      upload.Key,
+		UploadID: upload.UploadID,
+	})
+	if err != nil {
+		log.Errorf("[TusHandler] Failed complete upload %v", err)
+		return err
+	}
+
+	// the whole image is needed to count frames, so it's checked once completed
+	err = checkStored(ctx, h.s3Adapter, h.limits, upload.Key, upload.Length, upload.ContentType)
+	if isLimitErr(err) {
+		if derr := h.s3Adapter.DeleteObj(ctx, &adapters.S3Obj{Key: upload.Key}); derr != nil {
+			log.Errorf("[TusHandler] Failed delete rejected file %v", derr)
+		} else if derr := h.cleanup(ctx, upload, false); derr != nil {
+			log.Warnf("[TusHandler] Failed delete upload %v", derr)
+		}
+		return err
+	}
+	if err != nil {
+		log.Errorf("[TusHandler] Failed check upload %v", err)
+		return err
+	}
+
+	upload.Completed = true
+	upload.CompletedAt = time.Now()
+
+	if err := h.save(ctx, upload); err != nil {
+		log.Errorf("[TusHandler] Failed save upload %v", err)
+		return err
+	}
+
+	if err := h.cleanup(ctx, upload, true); err != nil {
+		log.Warnf("[TusHandler] Failed delete tails %v", err)
+	}
+
+	// content type is detected from the first chunk, jobs skip files that aren't images
+	enqueueDerived(h.queue, h.resizerConfig.HasVariants(), upload.Key)
+
+	return nil
+}
+
+// cleanup deletes tails of the upload, and its info unless keepInfo
+func (h *TusHandler) cleanup(ctx context.Context, upload *tusUpload, keepInfo bool) error {
+	query := &adapters.S3ListQuery{Prefix: tusPrefix + upload.ID + ".", Limit: 1000}
+
+	for {
+		list, err := h.s3Adapter.List(ctx, query)
+		if err != nil {
+			return err
+		}
+
+		objs := make([]*adapters.S3Obj, 0, len(list.Objects))
+		for _, obj := range list.Objects {
+			if keepInfo && obj.Key == tusPrefix+upload.ID+".info" {
+				continue
+			}
+			objs = append(objs, &adapters.S3Obj{Key: obj.Key})
+		}
+
+		if err := h.s3Adapter.DeleteObjs(ctx, objs); err != nil {
+			return err
+		}
+
+		if list.Cursor == "" {
+			return nil
+		}
+		query.Cursor = list.Cursor
+	}
+}
+
+func (h *TusHandler) load(ctx context.Context, id string) (*tusUpload, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, adapters.ErrNotFound
+	}
+
+	r, err := h.s3Adapter.GetObj(ctx, &adapters.S3Obj{Key: tusPrefix + id + ".info"})
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var upload tusUpload
+	if err := json.NewDecoder(r).Decode(&upload); err != nil {
+		return nil, err
+	}
+
+	return &upload, nil
+}
+
+func (h *TusHandler) save(ctx context.Context, upload *tusUpload) error {
+	b, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+
+	return h.s3Adapter.PutObj(ctx, &adapters.S3Obj{
+		Key:           tusPrefix + upload.ID + ".info",
+		Bytes:         b,
+		ContentType:   fiber.MIMEApplicationJSON,
+		ContentLength: int64(len(b)),
+	})
+}
+
+// lock serializes requests to the same upload
+func (h *TusHandler) lock(id string) func() {
+	return h.locks.Lock(id)
+}
+
+// maxSize is APP_MAX_FILE_SIZE unless multipart upload of PartSize parts can't be that large
+func (h *TusHandler) maxSize() int64 {
+	size := h.s3Config.PartSize * adapters.MaxParts
+	if h.appConfig.MaxFileSize > 0 && h.appConfig.MaxFileSize < size {
+		return h.appConfig.MaxFileSize
+	}
+
+	return size
+}
+
+// setUploadExpires tells client when unfinished upload expires
+func setUploadExpires(ctx *fiber.Ctx, upload *tusUpload) {
+	if !upload.Completed {
+		ctx.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// checkTusChecksum verifies chunk against Upload-Checksum "<algorithm> <base64 sum>",
+// empty header skips the check
+func checkTusChecksum(header string, body []byte) error {
+	if header == "" {
+		return nil
+	}
+
+	algorithm, encoded, ok := strings.Cut(header, " ")
+	if !ok {
+		return errTusChecksum
+	}
+
+	newHash, ok := tusChecksums[algorithm]
+	if !ok {
+		return errTusChecksumAlgorithm
+	}
+
+	sum, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return errTusChecksum
+	}
+
+	h := newHash()
+	h.Write(body)
+	if !bytes.Equal(h.Sum(nil), sum) {
+		return errTusChecksumMismatch
+	}
+
+	return nil
+}
+
+// tailKey is storage key of data of partNumber not uploaded yet
+func tailKey(id string, partNumber int64) string {
+	return tusPrefix + id + "." + strconv.FormatInt(partNumber, 10) + ".part"
+}
+
+// nextPart is number of the part after uploaded ones
+func nextPart(parts []adapters.S3Part) int64 {
+	if len(parts) == 0 {
+		return 1
+	}
+
+	return parts[len(parts)-1].PartNumber + 1
+}
+
+// parseTusMetadata decodes "key base64value,key2 base64value2" header
+func parseTusMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+
+	for _, pair := range strings.Split(header, ",") {
+		kv := strings.Fields(pair)
+		if len(kv) == 0 {
+			continue
+		}
+
+		var value []byte
+		if len(kv) > 1 {
+			value, _ = base64.StdEncoding.DecodeString(kv[1])
+		}
+		metadata[kv[0]] = string(value)
+	}
+
+	return metadata
+}
+
+func tusErrStatus(err error) int {
+	if errors.Is(err, adapters.ErrNotFound) {
+		return fiber.StatusNotFound
+	}
+
+	if errors.Is(err, errTusExpired) {
+		return fiber.StatusGone
+	}
+
+	log.Errorf("[TusHandler] Failed %v", err)
+	return fiber.StatusInternalServerError
+}
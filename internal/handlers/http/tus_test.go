@@ -0,0 +1,469 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/WildEgor/gImageResizer/internal/dtos"
+	"github.com/WildEgor/gImageResizer/internal/imgproxy"
+	"github.com/WildEgor/gImageResizer/internal/jobs"
+	"github.com/WildEgor/gImageResizer/internal/policy"
+	"github.com/WildEgor/gImageResizer/internal/resizer"
+	"github.com/gofiber/fiber/v2"
+)
+
+// failingDeletes is storage failing to delete single objects
+type failingDeletes struct {
+	*adapters.MemoryAdapter
+}
+
+func (f failingDeletes) DeleteObj(ctx context.Context, obj *adapters.S3Obj) error {
+	return errors.New("delete failed")
+}
+
+func newTusTestApp(
+	t *testing.T,
+	storage adapters.IS3Adapter,
+	configure ...func(*configs.UploadConfig),
+) (*fiber.App, *TusHandler) {
+	t.Helper()
+
+	appConfig := &configs.AppConfig{BaseURL: testBaseURL, MaxFileSize: 1024}
+	uploadConfig := &configs.UploadConfig{TenantHeader: "X-Tenant-ID", TusExpiry: time.Hour}
+	for _, c := range configure {
+		c(uploadConfig)
+	}
+	// tiny parts to get several of them
+	s3Config := &configs.S3Config{Bucket: "test", PartSize: 8}
+	resizerConfig := &configs.ResizerConfig{Engine: "imgproxy", Filter: "lanczos"}
+	presets := imgproxy.NewPresets(&configs.ImgProxyConfig{DefaultPreset: "medium"})
+	tasks := jobs.NewTasks(s3Config, resizerConfig, storage, presets, resizer.NewResizer(resizerConfig), policy.NewLimits(appConfig, uploadConfig))
+	queue := jobs.NewQueue(&configs.JobsConfig{MaxAttempts: 1}, jobs.NewMemoryStore(), tasks)
+
+	tus := NewTusHandler(
+		appConfig, s3Config, resizerConfig, uploadConfig, storage, queue,
+		policy.NewPolicies(uploadConfig), policy.NewLimits(appConfig, uploadConfig),
+	)
+
+	app := fiber.New()
+	group := app.Group("/api/v1/tus", tus.Resumable)
+	group.Options("/", tus.Options)
+	group.Post("/", tus.Create)
+	group.Head("/:id", tus.Head)
+	group.Patch("/:id", tus.Patch)
+	group.Get("/:id", tus.Get)
+	group.Delete("/:id", tus.Terminate)
+
+	return app, tus
+}
+
+func tusRequest(method, target string, body []byte, headers map[string]string) *http.Request {
+	req, _ := http.NewRequest(method, target, bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	return req
+}
+
+func TestTusUpload(t *testing.T) {
+	memory := adapters.NewMemoryAdapter(&configs.S3Config{Bucket: "test"})
+	app, tus := newTusTestApp(t, failingDeletes{memory})
+	s := &testServer{app: app, storage: memory}
+
+	data := append([]byte("\x89PNG\r\n\x1a\n"), []byte("0123456789ab")...)
+	// no filetype, the file gets one sniffed from the first chunk
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte("a b.png"))
+
+	resp, _ := s.do(t, tusRequest(http.MethodPost, "/api/v1/tus/", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(len(data)),
+		"Upload-Metadata": metadata,
+	}), nil)
+	if resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("create: got status %d", resp.StatusCode)
+	}
+	target := resp.Header.Get(fiber.HeaderLocation)
+	target = target[strings.Index(target, "/api/"):]
+
+	// chunks shorter than a part go to tail, a part takes the tail
+	// whose delete fails, it mustn't be counted again
+	offset := 0
+	for _, n := range []int{9, 8} {
+		resp, message := s.do(t, tusRequest(http.MethodPatch, target, data[offset:offset+n], map[string]string{
+			fiber.HeaderContentType: "application/offset+octet-stream",
+			"Upload-Offset":         strconv.Itoa(offset),
+		}), nil)
+		if resp.StatusCode != fiber.StatusNoContent {
+			t.Fatalf("patch at %d: got %d %q", offset, resp.StatusCode, message)
+		}
+		offset += n
+	}
+
+	resp, _ = s.do(t, tusRequest(http.MethodHead, target, nil, nil), nil)
+	if got := resp.Header.Get("Upload-Offset"); got != strconv.Itoa(offset) {
+		t.Fatalf("got offset %s, want %d", got, offset)
+	}
+
+	resp, message := s.do(t, tusRequest(http.MethodPatch, target, data[offset:], map[string]string{
+		fiber.HeaderContentType: "application/offset+octet-stream",
+		"Upload-Offset":         strconv.Itoa(offset),
+	}), nil)
+	if resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("final patch: got %d %q", resp.StatusCode, message)
+	}
+
+	var file dtos.UploadFilesResponse
+	resp, _ = s.do(t, tusRequest(http.MethodGet, target, nil, nil), &file)
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("get: got status %d", resp.StatusCode)
+	}
+	if !strings.HasSuffix(file.Url, "-a%20b.png") {
+		t.Errorf("url %q isn't escaped", file.Url)
+	}
+
+	key := strings.TrimSuffix(file.Url[len(testBaseURL)+1:], "a%20b.png") + "a b.png"
+	obj := memory.Object("test", key)
+	if obj == nil {
+		t.Fatalf("no file at %q", key)
+	}
+	if !bytes.Equal(obj.Bytes, data) {
+		t.Errorf("stored %q, want %q", obj.Bytes, data)
+	}
+	if obj.ContentType != "image/png" {
+		t.Errorf("stored as %q, want sniffed image/png", obj.ContentType)
+	}
+
+	if n := tus.locks.Len(); n != 0 {
+		t.Errorf("%d upload locks left", n)
+	}
+}
+
+func TestTusUploadMaxSize(t *testing.T) {
+	memory := adapters.NewMemoryAdapter(&configs.S3Config{Bucket: "test"})
+	app, _ := newTusTestApp(t, memory)
+	s := &testServer{app: app, storage: memory}
+
+	// APP_MAX_FILE_SIZE is under the size 10000 parts can have
+	resp, _ := s.do(t, tusRequest(http.MethodOptions, "/api/v1/tus/", nil, nil), nil)
+	if got := resp.Header.Get("Tus-Max-Size"); got != "1024" {
+		t.Errorf("got Tus-Max-Size %q, want 1024", got)
+	}
+
+	resp, message := s.do(t, tusRequest(http.MethodPost, "/api/v1/tus/", nil, map[string]string{
+		"Upload-Length": "1025",
+	}), nil)
+	if resp.StatusCode != fiber.StatusRequestEntityTooLarge || message != "ERR_TUS_MAX_SIZE" {
+		t.Errorf("got %d %q, want 413 ERR_TUS_MAX_SIZE", resp.StatusCode, message)
+	}
+}
+
+func TestTusUploadRejectsImageLimits(t *testing.T) {
+	memory := adapters.NewMemoryAdapter(&configs.S3Config{Bucket: "test"})
+	app, _ := newTusTestApp(t, memory, func(c *configs.UploadConfig) {
+		c.MaxAnimationFrames = 2
+	})
+	s := &testServer{app: app, storage: memory}
+
+	// frames are counted once the whole file is uploaded
+	data := testGIF(t, 3)
+	resp, _ := s.do(t, tusRequest(http.MethodPost, "/api/v1/tus/", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(len(data)),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("a.gif")),
+	}), nil)
+	if resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("create: got status %d", resp.StatusCode)
+	}
+	target := resp.Header.Get(fiber.HeaderLocation)
+	target = target[strings.Index(target, "/api/"):]
+
+	var limit dtos.UploadLimitResponse
+	status, message := s.fail(t, tusRequest(http.MethodPatch, target, data, map[string]string{
+		fiber.HeaderContentType: "application/offset+octet-stream",
+		"Upload-Offset":         "0",
+	}), &limit)
+	if status != fiber.StatusUnprocessableEntity || message != "ERR_ANIMATION_FRAMES" {
+		t.Fatalf("got %d %q, want 422 ERR_ANIMATION_FRAMES", status, message)
+	}
+	if limit.Name != "a.gif" || limit.Actual != 3 {
+		t.Errorf("got %+v", limit)
+	}
+
+	// the file is deleted with the upload
+	list, err := memory.List(context.Background(), &adapters.S3ListQuery{Limit: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Objects) != 0 {
+		t.Errorf("got %d objects after rejected upload", len(list.Objects))
+	}
+	if resp, _ := s.do(t, tusRequest(http.MethodHead, target, nil, nil), nil); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("head: got status %d, want 404", resp.StatusCode)
+	}
+}
+
+// createTus starts tus upload of length bytes, returns path of the upload
+func createTus(t *testing.T, s *testServer, name string, length int) string {
+	t.Helper()
+
+	resp, message := s.do(t, tusRequest(http.MethodPost, "/api/v1/tus/", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte(name)),
+	}), nil)
+	if resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("create: got %d %q", resp.StatusCode, message)
+	}
+	target := resp.Header.Get(fiber.HeaderLocation)
+
+	return target[strings.Index(target, "/api/"):]
+}
+
+func patchTus(t *testing.T, s *testServer, target string, offset int, chunk []byte, headers map[string]string) (*http.Response, string) {
+	t.Helper()
+
+	h := map[string]string{
+		fiber.HeaderContentType: "application/offset+octet-stream",
+		"Upload-Offset":         strconv.Itoa(offset),
+	}
+	for k, v := range headers {
+		h[k] = v
+	}
+
+	return s.do(t, tusRequest(http.MethodPatch, target, chunk, h), nil)
+}
+
+func tusOffset(t *testing.T, s *testServer, target string) string {
+	t.Helper()
+
+	resp, _ := s.do(t, tusRequest(http.MethodHead, target, nil, nil), nil)
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("head: got status %d", resp.StatusCode)
+	}
+
+	return resp.Header.Get("Upload-Offset")
+}
+
+// tusObjects lists keys of tus state
+func tusObjects(t *testing.T, memory *adapters.MemoryAdapter) []string {
+	t.Helper()
+
+	list, err := memory.List(context.Background(), &adapters.S3ListQuery{Prefix: tusPrefix, Limit: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make([]string, 0, len(list.Objects))
+	for _, obj := range list.Objects {
+		keys = append(keys, obj.Key)
+	}
+
+	return keys
+}
+
+func TestTusUploadResumes(t *testing.T) {
+	memory := adapters.NewMemoryAdapter(&configs.S3Config{Bucket: "test"})
+	app, _ := newTusTestApp(t, memory)
+	s := &testServer{app: app, storage: memory}
+
+	data := append([]byte("\x89PNG\r\n\x1a\n"), []byte("0123456789abcdef")...)
+	target := createTus(t, s, "a.png", len(data))
+
+	// a part of 8 bytes is uploaded, a byte is left in the tail
+	if resp, message := patchTus(t, s, target, 0, data[:9], nil); resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("patch: got %d %q", resp.StatusCode, message)
+	}
+
+	// after restart offset is read from parts and the tail
+	app, _ = newTusTestApp(t, memory)
+	s = &testServer{app: app, storage: memory}
+	if got := tusOffset(t, s, target); got != "9" {
+		t.Fatalf("got offset %s after restart, want 9", got)
+	}
+
+	for _, offset := range []int{0, 8, 10} {
+		resp, message := patchTus(t, s, target, offset, data[9:], nil)
+		if resp.StatusCode != fiber.StatusConflict || message != "ERR_TUS_OFFSET" {
+			t.Errorf("patch at %d: got %d %q, want 409 ERR_TUS_OFFSET", offset, resp.StatusCode, message)
+		}
+	}
+	if got := tusOffset(t, s, target); got != "9" {
+		t.Fatalf("got offset %s after conflicts, want 9", got)
+	}
+
+	if resp, message := patchTus(t, s, target, 9, data[9:], nil); resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("final patch: got %d %q", resp.StatusCode, message)
+	}
+
+	var file dtos.UploadFilesResponse
+	s.do(t, tusRequest(http.MethodGet, target, nil, nil), &file)
+	key := file.Url[len(testBaseURL)+1:]
+	if obj := memory.Object("test", key); obj == nil || !bytes.Equal(obj.Bytes, data) {
+		t.Errorf("stored %v, want %q", obj, data)
+	}
+}
+
+func TestTusUploadChecksum(t *testing.T) {
+	memory := adapters.NewMemoryAdapter(&configs.S3Config{Bucket: "test"})
+	app, _ := newTusTestApp(t, memory)
+	s := &testServer{app: app, storage: memory}
+
+	resp, _ := s.do(t, tusRequest(http.MethodOptions, "/api/v1/tus/", nil, nil), nil)
+	if got := resp.Header.Get("Tus-Extension"); !strings.Contains(got, "checksum") || !strings.Contains(got, "expiration") {
+		t.Errorf("got Tus-Extension %q", got)
+	}
+	if got := resp.Header.Get("Tus-Checksum-Algorithm"); !strings.Contains(got, "sha1") {
+		t.Errorf("got Tus-Checksum-Algorithm %q without sha1", got)
+	}
+
+	chunk := append([]byte("\x89PNG\r\n\x1a\n"), []byte("01")...)
+	target := createTus(t, s, "a.png", len(chunk)+4)
+
+	sha1Sum := sha1.Sum(chunk)
+	otherSum := sha1.Sum([]byte("other"))
+	tests := []struct {
+		name     string
+		checksum string
+		status   int
+		message  string
+	}{
+		{"mismatch", "sha1 " + base64.StdEncoding.EncodeToString(otherSum[:]), statusChecksumMismatch, "ERR_TUS_CHECKSUM_MISMATCH"},
+		{"algorithm", "crc32 AAAAAA==", fiber.StatusBadRequest, "ERR_TUS_CHECKSUM_ALGORITHM"},
+		{"no sum", "sha1", fiber.StatusBadRequest, "ERR_TUS_CHECKSUM"},
+		{"not base64", "sha1 !!!", fiber.StatusBadRequest, "ERR_TUS_CHECKSUM"},
+	}
+
+	for _, tt := range tests {
+		resp, message := patchTus(t, s, target, 0, chunk, map[string]string{"Upload-Checksum": tt.checksum})
+		if resp.StatusCode != tt.status || message != tt.message {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, resp.StatusCode, message, tt.status, tt.message)
+		}
+	}
+
+	// rejected chunks aren't stored
+	if got := tusOffset(t, s, target); got != "0" {
+		t.Fatalf("got offset %s, want 0", got)
+	}
+	if sessions := tusObjects(t, memory); len(sessions) != 1 {
+		t.Errorf("got %v, want only info", sessions)
+	}
+
+	resp, message := patchTus(t, s, target, 0, chunk, map[string]string{"Upload-Checksum": "sha1 " + base64.StdEncoding.EncodeToString(sha1Sum[:])})
+	if resp.StatusCode != fiber.StatusNoContent || resp.Header.Get("Upload-Offset") != strconv.Itoa(len(chunk)) {
+		t.Errorf("got %d %q with offset %s", resp.StatusCode, message, resp.Header.Get("Upload-Offset"))
+	}
+}
+
+func TestTusTerminate(t *testing.T) {
+	memory := adapters.NewMemoryAdapter(&configs.S3Config{Bucket: "test"})
+	app, tus := newTusTestApp(t, memory)
+	s := &testServer{app: app, storage: memory}
+
+	data := append([]byte("\x89PNG\r\n\x1a\n"), []byte("0123456789abcdef")...)
+	target := createTus(t, s, "a.png", len(data))
+	if resp, message := patchTus(t, s, target, 0, data[:9], nil); resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("patch: got %d %q", resp.StatusCode, message)
+	}
+
+	upload, err := tus.load(context.Background(), target[strings.LastIndex(target, "/")+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, message := s.do(t, tusRequest(http.MethodDelete, target, nil, nil), nil)
+	if resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("terminate: got %d %q", resp.StatusCode, message)
+	}
+
+	uploads, err := memory.ListMultipart(context.Background(), &adapters.S3Obj{Key: upload.Key})
+	if err != nil || len(uploads) != 0 {
+		t.Errorf("got multipart uploads %v, %v, want aborted", uploads, err)
+	}
+	if keys := tusObjects(t, memory); len(keys) != 0 {
+		t.Errorf("got %v after terminate", keys)
+	}
+	if resp, _ := s.do(t, tusRequest(http.MethodHead, target, nil, nil), nil); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("head: got status %d, want 404", resp.StatusCode)
+	}
+	if resp, _ := s.do(t, tusRequest(http.MethodDelete, target, nil, nil), nil); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("second terminate: got status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestTusUploadExpires(t *testing.T) {
+	memory := adapters.NewMemoryAdapter(&configs.S3Config{Bucket: "test"})
+	app, tus := newTusTestApp(t, memory)
+	s := &testServer{app: app, storage: memory}
+
+	data := append([]byte("\x89PNG\r\n\x1a\n"), []byte("0123456789abcdef")...)
+
+	start := time.Now()
+	resp, _ := s.do(t, tusRequest(http.MethodPost, "/api/v1/tus/", nil, map[string]string{
+		"Upload-Length": strconv.Itoa(len(data)),
+	}), nil)
+	expires, err := http.ParseTime(resp.Header.Get("Upload-Expires"))
+	if err != nil || expires.Before(start.Add(time.Hour).Truncate(time.Second)) || expires.After(time.Now().Add(time.Hour)) {
+		t.Errorf("got Upload-Expires %q, want in an hour", resp.Header.Get("Upload-Expires"))
+	}
+
+	unfinished := createTus(t, s, "a.png", len(data))
+	if resp, message := patchTus(t, s, unfinished, 0, data[:9], nil); resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("patch: got %d %q", resp.StatusCode, message)
+	}
+	completed := createTus(t, s, "b.png", len(data))
+	if resp, message := patchTus(t, s, completed, 0, data, nil); resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("patch: got %d %q", resp.StatusCode, message)
+	}
+	var file dtos.UploadFilesResponse
+	s.do(t, tusRequest(http.MethodGet, completed, nil, nil), &file)
+
+	upload, err := tus.load(context.Background(), unfinished[strings.LastIndex(unfinished, "/")+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tail left by upload whose info is lost
+	orphan := tailKey("00000000-0000-0000-0000-000000000000", 2)
+	memory.PutObj(context.Background(), &adapters.S3Obj{Key: orphan, Bytes: []byte("x"), ContentType: "application/octet-stream"})
+
+	if purged, err := tus.Purge(context.Background(), time.Now()); err != nil || purged != 0 {
+		t.Fatalf("got %d purged, %v before expiry", purged, err)
+	}
+
+	// expired upload is gone before it's purged
+	upload.ExpiresAt = time.Now().Add(-time.Second)
+	if err := tus.save(context.Background(), upload); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := s.do(t, tusRequest(http.MethodHead, unfinished, nil, nil), nil); resp.StatusCode != fiber.StatusGone {
+		t.Errorf("head: got status %d, want 410", resp.StatusCode)
+	}
+	if resp, message := patchTus(t, s, unfinished, 9, data[9:], nil); resp.StatusCode != fiber.StatusGone || message != "ERR_TUS_EXPIRED" {
+		t.Errorf("patch: got %d %q, want 410 ERR_TUS_EXPIRED", resp.StatusCode, message)
+	}
+
+	purged, err := tus.Purge(context.Background(), time.Now().Add(2*time.Hour))
+	if err != nil || purged != 3 {
+		t.Fatalf("got %d purged, %v, want 3", purged, err)
+	}
+
+	if keys := tusObjects(t, memory); len(keys) != 0 {
+		t.Errorf("got %v after purge", keys)
+	}
+	uploads, err := memory.ListMultipart(context.Background(), &adapters.S3Obj{Key: upload.Key})
+	if err != nil || len(uploads) != 0 {
+		t.Errorf("got multipart uploads %v, %v, want aborted", uploads, err)
+	}
+	if memory.Object("test", file.Url[len(testBaseURL)+1:]) == nil {
+		t.Errorf("file of completed upload is deleted")
+	}
+}
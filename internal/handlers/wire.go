@@ -8,4 +8,12 @@ import (
 var HandlersSet = wire.NewSet(
 	http_handlers.NewSaveFilesHandler,
 	http_handlers.NewDownloadFileHandler,
+	http_handlers.NewTusHandler,
+	http_handlers.NewPresignUploadHandler,
+	http_handlers.NewFinalizeUploadHandler,
+	http_handlers.NewJobsHandler,
+	http_handlers.NewFileMetaHandler,
+	http_handlers.NewDeleteFileHandler,
+	http_handlers.NewPresignDownloadHandler,
+	http_handlers.NewPurger,
 )
@@ -0,0 +1,90 @@
+package imgproxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// Builder makes imgproxy URL paths:
+// /<signature>/<options>/plain/<source>@<format> or /<signature>/<options>/<base64 source>.<format>
+type Builder struct {
+	key    []byte
+	salt   []byte
+	encode bool
+}
+
+// NewBuilder takes hex encoded key and salt like IMGPROXY_KEY and IMGPROXY_SALT,
+// empty both make unsigned URLs, one of them is a misconfiguration.
+// Source URLs are base64 encoded if encode is set
+func NewBuilder(key, salt string, encode bool) (*Builder, error) {
+	if (key == "") != (salt == "") {
+		return nil, errors.New("[imgproxy] Key and salt must be set together")
+	}
+
+	k, err := hex.DecodeString(key)
+	if err != nil {
+		return nil, errors.New("[imgproxy] Bad key")
+	}
+
+	s, err := hex.DecodeString(salt)
+	if err != nil {
+		return nil, errors.New("[imgproxy] Bad salt")
+	}
+
+	return &Builder{
+		key:    k,
+		salt:   s,
+		encode: encode,
+	}, nil
+}
+
+// Path returns signed path of source processed with opts
+func (b *Builder) Path(opts *Options, source string) string {
+	path := "/"
+	if o := opts.String(); o != "" {
+		path += o + "/"
+	}
+
+	if b.encode {
+		path += base64.RawURLEncoding.EncodeToString([]byte(source))
+		if opts.Format != "" {
+			path += "." + opts.Format
+		}
+	} else {
+		path += "plain/" + escapeSource(source)
+		if opts.Format != "" {
+			path += "@" + opts.Format
+		}
+	}
+
+	return "/" + b.Sign(path) + path
+}
+
+// Sign makes URL-safe base64 HMAC-SHA256 of salt and path,
+// without key imgproxy accepts any signature
+func (b *Builder) Sign(path string) string {
+	if len(b.key) == 0 {
+		return "insecure"
+	}
+
+	mac := hmac.New(sha256.New, b.key)
+	mac.Write(b.salt)
+	mac.Write([]byte(path))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// escapeSource escapes plain source URL segments, "@" separates output format
+func escapeSource(source string) string {
+	segments := strings.Split(source, "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(url.PathEscape(segment), "@", "%40")
+	}
+
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,131 @@
+package imgproxy
+
+import (
+	"testing"
+)
+
+func TestNewBuilderKeyAndSalt(t *testing.T) {
+	tests := []struct {
+		key, salt string
+		ok        bool
+	}{
+		{"", "", true},
+		{"736563726574", "68656c6c6f", true},
+		{"736563726574", "", false},
+		{"", "68656c6c6f", false},
+		{"not hex", "68656c6c6f", false},
+	}
+
+	for _, tt := range tests {
+		_, err := NewBuilder(tt.key, tt.salt, false)
+		if (err == nil) != tt.ok {
+			t.Errorf("NewBuilder(%q, %q) error = %v", tt.key, tt.salt, err)
+		}
+	}
+}
+
+// golden option strings of default presets, nginx maps used to hold them
+func TestDefaultPresetStrings(t *testing.T) {
+	golden := map[string]string{
+		"blurry": "size:320:320:1:0/blur:10/quality:50",
+		"small":  "size:320:320:0:0/sharpen:0.3",
+		"medium": "size:640:640:0:0",
+		"thumb":  "size:160:160:1:1/bg:ffffff/resizing_type:fill/sharpen:0.3",
+		"square": "size:500:500:0:1/bg:ffffff/resizing_type:fill",
+	}
+
+	if len(DefaultPresets) != len(golden) {
+		t.Errorf("got %d default presets, golden has %d", len(DefaultPresets), len(golden))
+	}
+
+	for name, want := range golden {
+		opts, ok := DefaultPresets[name]
+		if !ok {
+			t.Errorf("no %s preset", name)
+			continue
+		}
+		if got := opts.String(); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestOptionsString(t *testing.T) {
+	opts := &Options{
+		ResizeType: ResizeFit,
+		Width:      100,
+		Gravity:    GravitySmart,
+		Quality:    80,
+		Format:     "webp",
+		Blur:       1.5,
+		Background: "#000000",
+		Crop:       &Crop{Width: 50, Height: 60, Gravity: GravityNorth},
+		Watermark:  &Watermark{Opacity: 0.5, Position: GravitySouthEast, XOffset: 10, YOffset: 20, Scale: 0.25},
+	}
+
+	want := "crop:50:60:no/size:100:0:0:0/gravity:sm/blur:1.5/quality:80/bg:000000/resizing_type:fit/" +
+		"watermark:0.5:soea:10:20:0.25"
+	if got := opts.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got := (&Options{}).String(); got != "" {
+		t.Errorf("empty options = %q", got)
+	}
+}
+
+func TestBuilderPath(t *testing.T) {
+	const source = "s3://test/a b@c.png"
+
+	// signatures are HMAC-SHA256 computed independently of the builder
+	// with key "secret" and salt "hello"
+	tests := []struct {
+		name   string
+		key    string
+		salt   string
+		encode bool
+		preset string
+		want   string
+	}{
+		{
+			name:   "plain unsigned",
+			preset: "thumb",
+			want:   "/insecure/size:160:160:1:1/bg:ffffff/resizing_type:fill/sharpen:0.3/plain/s3://test/a%20b%40c.png@webp",
+		},
+		{
+			name:   "plain signed",
+			key:    "736563726574",
+			salt:   "68656c6c6f",
+			preset: "thumb",
+			want:   "/0m92ZSFucwjA9M8NRRAxZ-swYJL4_vChjPBklJAKZDY/size:160:160:1:1/bg:ffffff/resizing_type:fill/sharpen:0.3/plain/s3://test/a%20b%40c.png@webp",
+		},
+		{
+			name:   "base64 unsigned",
+			encode: true,
+			preset: "medium",
+			want:   "/insecure/size:640:640:0:0/czM6Ly90ZXN0L2EgYkBjLnBuZw.webp",
+		},
+		{
+			name:   "base64 signed",
+			key:    "736563726574",
+			salt:   "68656c6c6f",
+			encode: true,
+			preset: "medium",
+			want:   "/Mys4R8ATmVLxp9NL1jvREUn7jcByF9GLXcmUgBmrMLc/size:640:640:0:0/czM6Ly90ZXN0L2EgYkBjLnBuZw.webp",
+		},
+	}
+
+	for _, tt := range tests {
+		b, err := NewBuilder(tt.key, tt.salt, tt.encode)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		opts := *DefaultPresets[tt.preset]
+		opts.Format = "webp"
+
+		if got := b.Path(&opts, source); got != tt.want {
+			t.Errorf("%s:\n got %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+}
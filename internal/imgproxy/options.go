@@ -0,0 +1,148 @@
+package imgproxy
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ResizeType is imgproxy resizing_type option
+type ResizeType string
+
+const (
+	ResizeFit      ResizeType = "fit"
+	ResizeFill     ResizeType = "fill"
+	ResizeFillDown ResizeType = "fill-down"
+	ResizeForce    ResizeType = "force"
+	ResizeAuto     ResizeType = "auto"
+)
+
+// Gravity is a position of crop, extend or watermark
+type Gravity string
+
+const (
+	GravityCenter    Gravity = "ce"
+	GravityNorth     Gravity = "no"
+	GravitySouth     Gravity = "so"
+	GravityEast      Gravity = "ea"
+	GravityWest      Gravity = "we"
+	GravityNorthEast Gravity = "noea"
+	GravityNorthWest Gravity = "nowe"
+	GravitySouthEast Gravity = "soea"
+	GravitySouthWest Gravity = "sowe"
+	GravitySmart     Gravity = "sm"
+)
+
+type Crop struct {
+	Width   int     `json:"width"`
+	Height  int     `json:"height"`
+	Gravity Gravity `json:"gravity"`
+}
+
+type Watermark struct {
+	Opacity  float64 `json:"opacity"`
+	Position Gravity `json:"position"`
+	XOffset  int     `json:"xOffset"`
+	YOffset  int     `json:"yOffset"`
+	Scale    float64 `json:"scale"`
+}
+
+// Options are imgproxy processing options, zero values are omitted,
+// see https://docs.imgproxy.net/generating_the_url
+type Options struct {
+	ResizeType ResizeType `json:"resizeType"`
+	Width      int        `json:"width"`
+	Height     int        `json:"height"`
+	Enlarge    bool       `json:"enlarge"`
+	Extend     bool       `json:"extend"`
+	Gravity    Gravity    `json:"gravity"`
+	Quality    int        `json:"quality"`
+	Format     string     `json:"format"`
+	Blur       float64    `json:"blur"`
+	Sharpen    float64    `json:"sharpen"`
+	Background string     `json:"background"`
+	Crop       *Crop      `json:"crop"`
+	Watermark  *Watermark `json:"watermark"`
+}
+
+// Clone returns a copy of options not sharing crop and watermark
+func (o *Options) Clone() *Options {
+	c := *o
+
+	if o.Crop != nil {
+		crop := *o.Crop
+		c.Crop = &crop
+	}
+
+	if o.Watermark != nil {
+		watermark := *o.Watermark
+		c.Watermark = &watermark
+	}
+
+	return &c
+}
+
+// String serializes options to path segment in a fixed order:
+// crop, size, gravity, blur, quality, bg, resizing_type, sharpen, watermark.
+// Format isn't part of it, it's added as source extension
+func (o *Options) String() string {
+	var parts []string
+
+	if o.Crop != nil {
+		crop := "crop:" + itoa(o.Crop.Width) + ":" + itoa(o.Crop.Height)
+		if o.Crop.Gravity != "" {
+			crop += ":" + string(o.Crop.Gravity)
+		}
+		parts = append(parts, crop)
+	}
+
+	if o.Width != 0 || o.Height != 0 || o.Enlarge || o.Extend {
+		parts = append(parts, "size:"+itoa(o.Width)+":"+itoa(o.Height)+":"+btoa(o.Enlarge)+":"+btoa(o.Extend))
+	}
+
+	if o.Gravity != "" {
+		parts = append(parts, "gravity:"+string(o.Gravity))
+	}
+
+	if o.Blur != 0 {
+		parts = append(parts, "blur:"+ftoa(o.Blur))
+	}
+
+	if o.Quality != 0 {
+		parts = append(parts, "quality:"+itoa(o.Quality))
+	}
+
+	if o.Background != "" {
+		parts = append(parts, "bg:"+strings.TrimPrefix(o.Background, "#"))
+	}
+
+	if o.ResizeType != "" {
+		parts = append(parts, "resizing_type:"+string(o.ResizeType))
+	}
+
+	if o.Sharpen != 0 {
+		parts = append(parts, "sharpen:"+ftoa(o.Sharpen))
+	}
+
+	if o.Watermark != nil {
+		parts = append(parts, "watermark:"+ftoa(o.Watermark.Opacity)+":"+string(o.Watermark.Position)+":"+
+			itoa(o.Watermark.XOffset)+":"+itoa(o.Watermark.YOffset)+":"+ftoa(o.Watermark.Scale))
+	}
+
+	return strings.Join(parts, "/")
+}
+
+func itoa(i int) string {
+	return strconv.Itoa(i)
+}
+
+func ftoa(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func btoa(b bool) string {
+	if b {
+		return "1"
+	}
+
+	return "0"
+}
@@ -0,0 +1,109 @@
+package imgproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	log "github.com/sirupsen/logrus"
+)
+
+var ErrUnknownPreset = errors.New("[imgproxy] Unknown preset")
+
+// DefaultPresets are used when no presets are configured
+var DefaultPresets = map[string]*Options{
+	"blurry": {Width: 320, Height: 320, Enlarge: true, Blur: 10, Quality: 50},
+	"small":  {Width: 320, Height: 320, Sharpen: 0.3},
+	"medium": {Width: 640, Height: 640},
+	"thumb":  {Width: 160, Height: 160, Enlarge: true, Extend: true, Background: "ffffff", ResizeType: ResizeFill, Sharpen: 0.3},
+	"square": {Width: 500, Height: 500, Extend: true, Background: "ffffff", ResizeType: ResizeFill},
+}
+
+// Presets is a registry of named processing options
+type Presets struct {
+	presets map[string]*Options
+	def     string
+}
+
+// NewPresets loads presets from IMG_PROXY_PRESETS json or IMG_PROXY_PRESETS_FILE,
+// falling back to DefaultPresets
+func NewPresets(config *configs.ImgProxyConfig) *Presets {
+	presets := DefaultPresets
+
+	raw := []byte(config.Presets)
+	if len(raw) == 0 && config.PresetsFile != "" {
+		b, err := os.ReadFile(config.PresetsFile)
+		if err != nil {
+			log.Error(err)
+			log.Fatal("[Presets] Failed read presets file")
+		}
+		raw = b
+	}
+
+	if len(raw) != 0 {
+		presets = make(map[string]*Options)
+		if err := json.Unmarshal(raw, &presets); err != nil {
+			log.Error(err)
+			log.Fatal("[Presets] Bad presets")
+		}
+	}
+
+	p, err := NewPresetsFrom(presets, config.DefaultPreset)
+	if err != nil {
+		log.Fatalf("[Presets] Bad default preset %v", config.DefaultPreset)
+	}
+
+	log.Infof("[Presets] Loaded %v", strings.Join(p.Names(), ", "))
+
+	return p
+}
+
+func NewPresetsFrom(presets map[string]*Options, def string) (*Presets, error) {
+	p := &Presets{
+		presets: make(map[string]*Options, len(presets)),
+		def:     def,
+	}
+
+	for name, opts := range presets {
+		if opts == nil {
+			opts = &Options{}
+		}
+		p.presets[strings.TrimPrefix(name, "_")] = opts
+	}
+
+	if _, err := p.Get(def); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Get returns a deep copy of preset options, so callers may change it.
+// Empty name means default preset.
+// Names may start with "_" like in nginx paths: _small == small
+func (p *Presets) Get(name string) (*Options, error) {
+	if name == "" {
+		name = p.def
+	}
+
+	opts, ok := p.presets[strings.TrimPrefix(name, "_")]
+	if !ok {
+		return nil, ErrUnknownPreset
+	}
+
+	return opts.Clone(), nil
+}
+
+// Names returns sorted preset names
+func (p *Presets) Names() []string {
+	names := make([]string, 0, len(p.presets))
+	for name := range p.presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
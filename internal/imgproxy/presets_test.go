@@ -0,0 +1,44 @@
+package imgproxy
+
+import (
+	"testing"
+)
+
+func TestPresetsGetReturnsCopy(t *testing.T) {
+	presets, err := NewPresetsFrom(map[string]*Options{
+		"cropped": {
+			Width:     100,
+			Crop:      &Crop{Width: 50, Height: 50, Gravity: GravityCenter},
+			Watermark: &Watermark{Opacity: 0.5, Position: GravitySouthEast},
+		},
+	}, "cropped")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := presets.Get("_cropped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := opts.String()
+
+	opts.Width = 1
+	opts.Crop.Width = 1
+	opts.Watermark.Opacity = 1
+
+	again, _ := presets.Get("")
+	if got := again.String(); got != want {
+		t.Errorf("preset changed by caller: got %q, want %q", got, want)
+	}
+}
+
+func TestPresetsGetUnknown(t *testing.T) {
+	presets, err := NewPresetsFrom(DefaultPresets, "medium")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := presets.Get("huge"); err != ErrUnknownPreset {
+		t.Errorf("got error %v, want ErrUnknownPreset", err)
+	}
+}
@@ -0,0 +1,9 @@
+package imgproxy
+
+import (
+	"github.com/google/wire"
+)
+
+var ImgProxySet = wire.NewSet(
+	NewPresets,
+)
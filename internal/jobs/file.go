@@ -0,0 +1,108 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// FileStore keeps every job as <dir>/<id>.json
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// Save writes job to temp file and renames it, so a crash never leaves half written job
+func (s *FileStore) Save(job *Job) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, job.ID+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path(job.ID))
+}
+
+func (s *FileStore) Get(id string) (*Job, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrJobNotFound
+	}
+
+	b, err := os.ReadFile(s.path(id))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+
+	var job Job
+	if err := json.Unmarshal(b, &job); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+func (s *FileStore) List() ([]*Job, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0, len(entries))
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+
+		job, err := s.Get(id)
+		if err != nil {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+func (s *FileStore) Delete(id string) error {
+	err := os.Remove(s.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
@@ -0,0 +1,68 @@
+package jobs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestFileStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "jobs")
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	job := &Job{ID: uuid.New().String(), Type: TypeMetadata, Key: "a.png", Status: StatusQueued, MaxAttempts: 3, RunAt: now}
+	if err := store.Save(job); err != nil {
+		t.Fatal(err)
+	}
+
+	job.Status = StatusRunning
+	job.Attempts = 1
+	if err := store.Save(job); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.Get(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != StatusRunning || got.Attempts != 1 || got.Key != "a.png" || !got.RunAt.Equal(now) {
+		t.Errorf("got %+v, want %+v", got, job)
+	}
+
+	// temp files of saves are renamed, files that aren't jobs are skipped
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0o644)
+	os.WriteFile(filepath.Join(dir, "bad.json"), []byte("{"), 0o644)
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("got %d files, want job and 2 others", len(entries))
+	}
+
+	jobs, err := store.List()
+	if err != nil || len(jobs) != 1 || jobs[0].ID != job.ID {
+		t.Errorf("got %v, %v, want the job", jobs, err)
+	}
+
+	for _, id := range []string{"../notes", "bad", uuid.New().String()} {
+		if _, err := store.Get(id); !errors.Is(err, ErrJobNotFound) {
+			t.Errorf("%s: got %v, want %v", id, err, ErrJobNotFound)
+		}
+	}
+
+	if err := store.Delete(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(job.ID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("deleted job: got %v", err)
+	}
+	if err := store.Delete(job.ID); err != nil {
+		t.Errorf("second delete: %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package jobs
+
+import "time"
+
+// Status of a job: queued -> running -> done,
+// or back to queued for retry, or dead when attempts are over
+type Status string
+
+const (
+	StatusQueued  Status = "queued"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusDead    Status = "dead"
+)
+
+// Job types
+const (
+	TypeVariants = "variants"
+	TypeMetadata = "metadata"
+)
+
+// Job is a unit of background work on an uploaded file
+type Job struct {
+	ID          string            `json:"id"`
+	Type        string            `json:"type"`
+	Key         string            `json:"key"`
+	Status      Status            `json:"status"`
+	Attempts    int               `json:"attempts"`
+	MaxAttempts int               `json:"maxAttempts"`
+	Error       string            `json:"error,omitempty"`
+	Result      map[string]string `json:"result,omitempty"`
+	RunAt       time.Time         `json:"runAt"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	UpdatedAt   time.Time         `json:"updatedAt"`
+}
+
+// Finished tells if job won't run anymore
+func (j *Job) Finished() bool {
+	return j.Status == StatusDone || j.Status == StatusDead
+}
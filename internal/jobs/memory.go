@@ -0,0 +1,58 @@
+package jobs
+
+import "sync"
+
+// MemoryStore keeps jobs in memory, they are lost on restart
+type MemoryStore struct {
+	mu   sync.RWMutex
+	jobs map[string]Job
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		jobs: make(map[string]Job),
+	}
+}
+
+func (s *MemoryStore) Save(job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs[job.ID] = *job
+
+	return nil
+}
+
+func (s *MemoryStore) Get(id string) (*Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+
+	return &job, nil
+}
+
+func (s *MemoryStore) List() ([]*Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		job := job
+		jobs = append(jobs, &job)
+	}
+
+	return jobs, nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.jobs, id)
+
+	return nil
+}
@@ -0,0 +1,388 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+var ErrNotDead = errors.New("[Jobs] Only dead jobs can be retried")
+
+// purgeInterval is how often finished jobs past retention are deleted
+const purgeInterval = time.Hour
+
+// HandlerFunc runs a job, returned error schedules a retry
+type HandlerFunc func(ctx context.Context, job *Job) (map[string]string, error)
+
+// Queue runs jobs by workers in background. Every state change is saved
+// to the store first, so queued, running and retried jobs are picked up
+// again after restart. Close stops workers and cancels running jobs
+type Queue struct {
+	config   *configs.JobsConfig
+	store    IJobStore
+	handlers map[string]HandlerFunc
+	ready    chan string
+	mu       sync.Mutex
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func NewQueue(
+	config *configs.JobsConfig,
+	store IJobStore,
+	tasks *Tasks,
+) *Queue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		config:   config,
+		store:    store,
+		handlers: tasks.Handlers(),
+		ready:    make(chan string, 1024),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	if err := q.recover(); err != nil {
+		log.Error(err)
+		log.Fatal("[Jobs] Failed restore jobs")
+	}
+
+	q.wg.Add(config.Workers + 1)
+	for i := 0; i < config.Workers; i++ {
+		go q.work()
+	}
+	go q.purgeEvery(purgeInterval)
+
+	return q
+}
+
+// Close cancels running jobs and waits for workers to stop. Interrupted jobs
+// stay unfinished in the store and run again after restart
+func (q *Queue) Close() error {
+	q.cancel()
+	q.wg.Wait()
+
+	return nil
+}
+
+// Enqueue saves new job of jobType for the file and schedules it
+func (q *Queue) Enqueue(jobType, key string) (*Job, error) {
+	now := time.Now()
+	job := &Job{
+		ID:          uuid.New().String(),
+		Type:        jobType,
+		Key:         key,
+		Status:      StatusQueued,
+		MaxAttempts: q.config.MaxAttempts,
+		RunAt:       now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if err := q.store.Save(job); err != nil {
+		return nil, err
+	}
+
+	q.schedule(job)
+
+	return job, nil
+}
+
+func (q *Queue) Get(id string) (*Job, error) {
+	return q.store.Get(id)
+}
+
+// Dead returns dead letters, jobs failed MaxAttempts times, oldest first
+func (q *Queue) Dead() ([]*Job, error) {
+	jobs, err := q.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	dead := make([]*Job, 0)
+	for _, job := range jobs {
+		if job.Status == StatusDead {
+			dead = append(dead, job)
+		}
+	}
+
+	sort.Slice(dead, func(i, j int) bool {
+		return dead[i].UpdatedAt.Before(dead[j].UpdatedAt)
+	})
+
+	return dead, nil
+}
+
+// Retry puts dead job back to the queue with fresh attempts
+func (q *Queue) Retry(id string) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, err := q.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if job.Status != StatusDead {
+		return nil, ErrNotDead
+	}
+
+	job.Status = StatusQueued
+	job.Attempts = 0
+	job.RunAt = time.Now()
+	job.UpdatedAt = job.RunAt
+
+	if err := q.store.Save(job); err != nil {
+		return nil, err
+	}
+
+	q.schedule(job)
+
+	return job, nil
+}
+
+// recover schedules unfinished jobs and purges finished ones.
+// Running jobs were interrupted by restart, so they run again
+func (q *Queue) recover() error {
+	q.purge(time.Now())
+
+	jobs, err := q.store.List()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].RunAt.Before(jobs[j].RunAt)
+	})
+
+	restored := 0
+	for _, job := range jobs {
+		if job.Finished() {
+			continue
+		}
+
+		if job.Status == StatusRunning {
+			job.Status = StatusQueued
+			job.UpdatedAt = time.Now()
+			if err := q.store.Save(job); err != nil {
+				return err
+			}
+		}
+		q.schedule(job)
+		restored++
+	}
+
+	if restored != 0 {
+		log.Infof("[Jobs] Restored %v jobs", restored)
+	}
+
+	return nil
+}
+
+// purgeEvery purges finished jobs every interval until the queue is closed
+func (q *Queue) purgeEvery(interval time.Duration) {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case now := <-ticker.C:
+			q.purge(now)
+		}
+	}
+}
+
+// purge deletes done jobs older than Retention and dead ones older than DeadRetention
+func (q *Queue) purge(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobs, err := q.store.List()
+	if err != nil {
+		log.Errorf("[Jobs] Failed list jobs: %v", err)
+		return
+	}
+
+	purged := 0
+	for _, job := range jobs {
+		retention := q.config.Retention
+		if job.Status == StatusDead {
+			retention = q.config.DeadRetention
+		}
+
+		if !job.Finished() || retention <= 0 || now.Sub(job.UpdatedAt) <= retention {
+			continue
+		}
+
+		if err := q.store.Delete(job.ID); err != nil {
+			log.Errorf("[Jobs] Failed delete job %v: %v", job.ID, err)
+			continue
+		}
+		purged++
+	}
+
+	if purged != 0 {
+		log.Infof("[Jobs] Purged %v finished jobs", purged)
+	}
+}
+
+// schedule pushes job to workers at its RunAt, closed queue drops it,
+// the job stays queued in the store
+func (q *Queue) schedule(job *Job) {
+	id := job.ID
+	push := func() {
+		select {
+		case q.ready <- id:
+		case <-q.ctx.Done():
+		}
+	}
+
+	delay := time.Until(job.RunAt)
+	if delay <= 0 {
+		go push()
+		return
+	}
+
+	time.AfterFunc(delay, push)
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case id := <-q.ready:
+			q.run(id)
+		}
+	}
+}
+
+func (q *Queue) run(id string) {
+	job, err := q.start(id)
+	if err != nil || job == nil {
+		return
+	}
+
+	// panicking handler mustn't kill the worker or leave the job running forever
+	defer func() {
+		if r := recover(); r != nil {
+			q.fail(job, fmt.Errorf("panic: %v", r), job.Attempts >= job.MaxAttempts)
+		}
+	}()
+
+	handler, ok := q.handlers[job.Type]
+	if !ok {
+		q.fail(job, errors.New("unknown job type"), true)
+		return
+	}
+
+	result, err := handler(q.ctx, job)
+	if err != nil && q.ctx.Err() != nil {
+		q.interrupt(job)
+		return
+	}
+	if err != nil {
+		q.fail(job, err, job.Attempts >= job.MaxAttempts)
+		return
+	}
+
+	job.Status = StatusDone
+	job.Result = result
+	job.Error = ""
+	job.UpdatedAt = time.Now()
+
+	if err := q.store.Save(job); err != nil {
+		log.Errorf("[Jobs] Failed save job %v: %v", job.ID, err)
+	}
+}
+
+// start marks queued job as running, nil job means it was already taken
+func (q *Queue) start(id string) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, err := q.store.Get(id)
+	if err != nil {
+		log.Errorf("[Jobs] Failed load job %v: %v", id, err)
+		return nil, err
+	}
+
+	if job.Status != StatusQueued {
+		return nil, nil
+	}
+
+	job.Status = StatusRunning
+	job.Attempts++
+	job.UpdatedAt = time.Now()
+
+	if err := q.store.Save(job); err != nil {
+		log.Errorf("[Jobs] Failed save job %v: %v", job.ID, err)
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// interrupt puts job cancelled by Close back to the queue without spending
+// an attempt, it runs after restart
+func (q *Queue) interrupt(job *Job) {
+	job.Status = StatusQueued
+	job.Attempts--
+	job.UpdatedAt = time.Now()
+
+	if err := q.store.Save(job); err != nil {
+		log.Errorf("[Jobs] Failed save job %v: %v", job.ID, err)
+	}
+}
+
+// fail schedules retry with exponential backoff or moves job to dead letters
+func (q *Queue) fail(job *Job, err error, dead bool) {
+	job.Error = err.Error()
+	job.UpdatedAt = time.Now()
+
+	if dead {
+		job.Status = StatusDead
+		log.Errorf("[Jobs] Job %v %v of %v is dead: %v", job.ID, job.Type, job.Key, err)
+	} else {
+		job.Status = StatusQueued
+		job.RunAt = job.UpdatedAt.Add(q.backoff(job.Attempts))
+		log.Warnf("[Jobs] Job %v %v of %v failed, attempt %v: %v", job.ID, job.Type, job.Key, job.Attempts, err)
+	}
+
+	if err := q.store.Save(job); err != nil {
+		log.Errorf("[Jobs] Failed save job %v: %v", job.ID, err)
+		return
+	}
+
+	if !dead {
+		q.schedule(job)
+	}
+}
+
+// backoff is Backoff doubled on every attempt, capped by MaxBackoff
+func (q *Queue) backoff(attempts int) time.Duration {
+	delay := q.config.Backoff
+	for i := 1; i < attempts && delay < q.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > q.config.MaxBackoff {
+		delay = q.config.MaxBackoff
+	}
+
+	return delay
+}
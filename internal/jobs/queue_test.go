@@ -0,0 +1,269 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/google/uuid"
+)
+
+// newTestQueue runs one worker with handlers instead of tasks
+func newTestQueue(t *testing.T, config *configs.JobsConfig, handlers map[string]HandlerFunc) (*Queue, *MemoryStore) {
+	t.Helper()
+
+	store := NewMemoryStore()
+
+	return newStoreTestQueue(t, config, store, handlers), store
+}
+
+// newStoreTestQueue restores jobs of store and runs them by one worker with handlers
+func newStoreTestQueue(t *testing.T, config *configs.JobsConfig, store IJobStore, handlers map[string]HandlerFunc) *Queue {
+	t.Helper()
+
+	q := NewQueue(config, store, &Tasks{})
+	q.handlers = handlers
+
+	q.wg.Add(1)
+	go q.work()
+	t.Cleanup(func() { q.Close() })
+
+	return q
+}
+
+// waitStatus polls the job until it gets status
+func waitStatus(t *testing.T, q *Queue, id string, status Status) *Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := q.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == status {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %v is %v, want %v", id, job.Status, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestQueueRecoversPanic(t *testing.T) {
+	q, _ := newTestQueue(t, &configs.JobsConfig{MaxAttempts: 1}, map[string]HandlerFunc{
+		"panic": func(ctx context.Context, job *Job) (map[string]string, error) {
+			panic("boom")
+		},
+		"ok": func(ctx context.Context, job *Job) (map[string]string, error) {
+			return map[string]string{"ok": "1"}, nil
+		},
+	})
+
+	job, _ := q.Enqueue("panic", "a.png")
+	job = waitStatus(t, q, job.ID, StatusDead)
+	if !strings.Contains(job.Error, "boom") {
+		t.Errorf("got error %q", job.Error)
+	}
+
+	// the worker survived
+	job, _ = q.Enqueue("ok", "a.png")
+	waitStatus(t, q, job.ID, StatusDone)
+}
+
+func TestQueueCloseInterruptsJobs(t *testing.T) {
+	started := make(chan struct{})
+	q, store := newTestQueue(t, &configs.JobsConfig{MaxAttempts: 3}, map[string]HandlerFunc{
+		"slow": func(ctx context.Context, job *Job) (map[string]string, error) {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	})
+
+	job, _ := q.Enqueue("slow", "a.png")
+	<-started
+	q.Close()
+
+	job, _ = store.Get(job.ID)
+	if job.Status != StatusQueued || job.Attempts != 0 {
+		t.Errorf("interrupted job is %v after %v attempts, want queued without attempts", job.Status, job.Attempts)
+	}
+}
+
+func TestQueuePurge(t *testing.T) {
+	q, store := newTestQueue(t, &configs.JobsConfig{
+		MaxAttempts:   1,
+		Retention:     time.Hour,
+		DeadRetention: 24 * time.Hour,
+	}, nil)
+
+	now := time.Now()
+	tests := []struct {
+		job  *Job
+		kept bool
+	}{
+		{&Job{ID: "old done", Status: StatusDone, UpdatedAt: now.Add(-2 * time.Hour)}, false},
+		{&Job{ID: "new done", Status: StatusDone, UpdatedAt: now}, true},
+		{&Job{ID: "old dead", Status: StatusDead, UpdatedAt: now.Add(-48 * time.Hour)}, false},
+		{&Job{ID: "new dead", Status: StatusDead, UpdatedAt: now.Add(-2 * time.Hour)}, true},
+		{&Job{ID: "old queued", Status: StatusQueued, UpdatedAt: now.Add(-48 * time.Hour)}, true},
+	}
+	for _, tt := range tests {
+		store.Save(tt.job)
+	}
+
+	q.purge(now)
+
+	for _, tt := range tests {
+		_, err := store.Get(tt.job.ID)
+		if kept := err == nil; kept != tt.kept {
+			t.Errorf("%v: kept %v, want %v", tt.job.ID, kept, tt.kept)
+		}
+	}
+}
+
+func newTestFileStore(t *testing.T) *FileStore {
+	t.Helper()
+
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+func TestQueueRecoversRunningJobs(t *testing.T) {
+	store := newTestFileStore(t)
+
+	// saved as running by the queue killed mid job
+	now := time.Now()
+	running := &Job{ID: uuid.New().String(), Type: "ok", Key: "a.png", Status: StatusRunning, Attempts: 1, MaxAttempts: 3, RunAt: now, UpdatedAt: now}
+	done := &Job{ID: uuid.New().String(), Type: "ok", Key: "b.png", Status: StatusDone, Attempts: 1, MaxAttempts: 3, RunAt: now, UpdatedAt: now.Add(-2 * time.Hour)}
+	for _, job := range []*Job{running, done} {
+		if err := store.Save(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q := newStoreTestQueue(t, &configs.JobsConfig{MaxAttempts: 3, Retention: time.Hour}, store, map[string]HandlerFunc{
+		"ok": func(ctx context.Context, job *Job) (map[string]string, error) {
+			return map[string]string{"key": job.Key}, nil
+		},
+	})
+
+	job := waitStatus(t, q, running.ID, StatusDone)
+	if job.Attempts != 2 || job.Result["key"] != "a.png" {
+		t.Errorf("got %+v, want done at the second attempt", job)
+	}
+
+	// finished jobs past retention are purged on start
+	if _, err := store.Get(done.ID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("old done job is kept: %v", err)
+	}
+}
+
+func TestQueueBackoff(t *testing.T) {
+	q := &Queue{config: &configs.JobsConfig{Backoff: time.Second, MaxBackoff: 5 * time.Second}}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{10, 5 * time.Second},
+		// doubling stops at the cap, so it doesn't overflow
+		{1000, 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := q.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestQueueDeadLetters(t *testing.T) {
+	store := newTestFileStore(t)
+
+	var mu sync.Mutex
+	var runs []time.Time
+	failing := true
+	q := newStoreTestQueue(t, &configs.JobsConfig{
+		MaxAttempts: 3,
+		Backoff:     20 * time.Millisecond,
+		MaxBackoff:  30 * time.Millisecond,
+	}, store, map[string]HandlerFunc{
+		"flaky": func(ctx context.Context, job *Job) (map[string]string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			runs = append(runs, time.Now())
+			if failing {
+				return nil, errors.New("storage is down")
+			}
+			return nil, nil
+		},
+	})
+
+	job, err := q.Enqueue("flaky", "a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job = waitStatus(t, q, job.ID, StatusDead)
+	if job.Attempts != 3 || job.Error != "storage is down" {
+		t.Errorf("got dead job %+v, want 3 attempts with error", job)
+	}
+
+	// retries wait 20ms, then 40ms capped to 30ms
+	mu.Lock()
+	if len(runs) != 3 {
+		t.Fatalf("got %d runs, want 3", len(runs))
+	}
+	for i, want := range []time.Duration{20 * time.Millisecond, 30 * time.Millisecond} {
+		if got := runs[i+1].Sub(runs[i]); got < want {
+			t.Errorf("retry %d after %v, want at least %v", i+1, got, want)
+		}
+	}
+	failing = false
+	mu.Unlock()
+
+	dead, err := q.Dead()
+	if err != nil || len(dead) != 1 || dead[0].ID != job.ID {
+		t.Fatalf("got dead letters %v, %v", dead, err)
+	}
+
+	if _, err := q.Retry(uuid.New().String()); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("retry of unknown job: got %v", err)
+	}
+
+	retried, err := q.Retry(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retried.Status != StatusQueued || retried.Attempts != 0 {
+		t.Errorf("got retried %+v, want queued without attempts", retried)
+	}
+
+	job = waitStatus(t, q, job.ID, StatusDone)
+	if job.Attempts != 1 || job.Error != "" {
+		t.Errorf("got %+v, want done at the first attempt", job)
+	}
+	if _, err := q.Retry(job.ID); !errors.Is(err, ErrNotDead) {
+		t.Errorf("retry of done job: got %v, want %v", err, ErrNotDead)
+	}
+
+	if dead, _ := q.Dead(); len(dead) != 0 {
+		t.Errorf("got dead letters %v after retry", dead)
+	}
+}
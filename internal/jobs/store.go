@@ -0,0 +1,37 @@
+package jobs
+
+import (
+	"errors"
+
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	log "github.com/sirupsen/logrus"
+)
+
+var ErrJobNotFound = errors.New("[Jobs] Job not found")
+
+// IJobStore persists jobs, queue state is restored from it on start
+type IJobStore interface {
+	Save(job *Job) error
+	Get(id string) (*Job, error)
+	List() ([]*Job, error)
+	Delete(id string) error
+}
+
+// NewJobStore picks store by JOBS_STORE
+func NewJobStore(config *configs.JobsConfig) IJobStore {
+	switch config.Store {
+	case "file":
+		store, err := NewFileStore(config.Dir)
+		if err != nil {
+			log.Error(err)
+			log.Fatal("[Jobs] Failed init file store")
+		}
+		return store
+	case "memory":
+		return NewMemoryStore()
+	}
+
+	log.Fatalf("[Jobs] Unknown store %v", config.Store)
+
+	return nil
+}
@@ -0,0 +1,240 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/WildEgor/gImageResizer/internal/imgproxy"
+	"github.com/WildEgor/gImageResizer/internal/metadata"
+	"github.com/WildEgor/gImageResizer/internal/policy"
+	"github.com/WildEgor/gImageResizer/internal/resizer"
+	log "github.com/sirupsen/logrus"
+)
+
+// headerSize is read from images for metadata, it fits headers and EXIF
+const headerSize = 512 << 10
+
+// Tasks make derived assets of uploaded files
+type Tasks struct {
+	s3Config      *configs.S3Config
+	resizerConfig *configs.ResizerConfig
+	s3Adapter     adapters.IS3Adapter
+	presets       *imgproxy.Presets
+	resizer       *resizer.Resizer
+	limits        *policy.Limits
+}
+
+func NewTasks(
+	s3Config *configs.S3Config,
+	resizerConfig *configs.ResizerConfig,
+	s3Adapter adapters.IS3Adapter,
+	presets *imgproxy.Presets,
+	resizer *resizer.Resizer,
+	limits *policy.Limits,
+) *Tasks {
+	for _, variant := range resizerConfig.Variants {
+		if _, err := presets.Get(variant); err != nil {
+			log.Fatalf("[Jobs] Unknown variant preset %v", variant)
+		}
+	}
+
+	return &Tasks{
+		s3Config:      s3Config,
+		resizerConfig: resizerConfig,
+		s3Adapter:     s3Adapter,
+		presets:       presets,
+		resizer:       resizer,
+		limits:        limits,
+	}
+}
+
+func (t *Tasks) Handlers() map[string]HandlerFunc {
+	return map[string]HandlerFunc{
+		TypeVariants: t.Variants,
+		TypeMetadata: t.Metadata,
+	}
+}
+
+// Variants renders RESIZER_VARIANTS presets of the image and stores them
+// under variant keys, result maps preset names to public URLs.
+// Files that aren't images or were deleted are skipped
+func (t *Tasks) Variants(ctx context.Context, job *Job) (map[string]string, error) {
+	body, err := t.s3Adapter.GetObj(ctx, &adapters.S3Obj{Key: job.Key})
+	if errors.Is(err, adapters.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	img, format, err := resizer.Decode(body)
+	if err != nil {
+		if errors.Is(err, resizer.ErrUnsupportedImage) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	variants := make(map[string]string, len(t.resizerConfig.Variants))
+	for _, preset := range t.resizerConfig.Variants {
+		opts, err := t.presets.Get(preset)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := t.resizer.ProcessImage(img, format, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		variantKey := resizer.VariantKey(job.Key, preset)
+		if err := t.s3Adapter.PutObj(ctx, &adapters.S3Obj{
+			Key:           variantKey,
+			Bytes:         result.Bytes,
+			ContentType:   result.ContentType,
+			ContentLength: int64(len(result.Bytes)),
+		}); err != nil {
+			return nil, err
+		}
+
+		variants[preset] = t.s3Config.ObjectURL(variantKey)
+	}
+
+	return variants, nil
+}
+
+// Metadata stores metadata sidecar of the file, deleted files are skipped
+func (t *Tasks) Metadata(ctx context.Context, job *Job) (map[string]string, error) {
+	_, err := t.ExtractMetadata(ctx, job.Key)
+	if errors.Is(err, adapters.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{"key": metadata.Key(job.Key)}, nil
+}
+
+// ExtractMetadata saves metadata of the file with placeholders as sidecar,
+// so clients don't need to download the original. Only image headers are read,
+// the whole image is decoded only for placeholders and within upload limits
+func (t *Tasks) ExtractMetadata(ctx context.Context, key string) (*metadata.Metadata, error) {
+	stat, err := t.s3Adapter.Stat(ctx, &adapters.S3Obj{Key: key})
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &metadata.Metadata{
+		Key:         key,
+		ContentType: stat.ContentType,
+		Size:        stat.ContentLength,
+		ExtractedAt: time.Now(),
+	}
+
+	// non images keep only content type and size
+	if !strings.HasPrefix(stat.ContentType, "image/") {
+		if err := t.saveMetadata(ctx, meta); err != nil {
+			return nil, err
+		}
+		return meta, nil
+	}
+
+	// multipart uploads have placeholders in object metadata already
+	placeholders := resizer.PlaceholdersOf(stat.Metadata)
+	whole := placeholders == nil && t.limits.CheckSize(stat.ContentLength) == nil
+
+	size := int64(headerSize)
+	if whole {
+		size = stat.ContentLength
+	}
+
+	body, err := t.s3Adapter.GetObj(ctx, &adapters.S3Obj{Key: key})
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, size))
+	if err != nil {
+		return nil, err
+	}
+
+	if meta.Inspect(data) {
+		// frames of animation are spread over the whole file, they aren't counted by header
+		if int64(len(data)) < stat.ContentLength && (meta.Format == "gif" || meta.Format == "webp") {
+			meta.Frames = 0
+		}
+
+		if whole && t.limits.CheckImage(bytes.NewReader(data)) == nil {
+			if img, _, err := resizer.Decode(bytes.NewReader(data)); err == nil {
+				placeholders, err = t.resizer.Placeholders(img)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if placeholders != nil {
+			meta.BlurHash = placeholders.BlurHash
+			meta.LQIP = placeholders.LQIP
+		}
+	}
+
+	if err := t.saveMetadata(ctx, meta); err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+func (t *Tasks) saveMetadata(ctx context.Context, meta *metadata.Metadata) error {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return t.s3Adapter.PutObj(ctx, &adapters.S3Obj{
+		Key:           metadata.Key(meta.Key),
+		Bytes:         b,
+		ContentType:   "application/json",
+		ContentLength: int64(len(b)),
+	})
+}
+
+// LoadMetadata reads metadata sidecar, adapters.ErrNotFound if it isn't extracted yet
+func (t *Tasks) LoadMetadata(ctx context.Context, key string) (*metadata.Metadata, error) {
+	body, err := t.s3Adapter.GetObj(ctx, &adapters.S3Obj{Key: metadata.Key(key)})
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var meta metadata.Metadata
+	if err := json.NewDecoder(body).Decode(&meta); err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
+
+// DerivedKeys are keys of everything made from the file: variants of all
+// presets, since RESIZER_VARIANTS may have changed since upload, and metadata
+func (t *Tasks) DerivedKeys(key string) []string {
+	names := t.presets.Names()
+
+	keys := make([]string, 0, len(names)+1)
+	for _, preset := range names {
+		keys = append(keys, resizer.VariantKey(key, preset))
+	}
+
+	return append(keys, metadata.Key(key))
+}
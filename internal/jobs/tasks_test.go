@@ -0,0 +1,246 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math/rand"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/WildEgor/gImageResizer/internal/imgproxy"
+	"github.com/WildEgor/gImageResizer/internal/policy"
+	"github.com/WildEgor/gImageResizer/internal/resizer"
+)
+
+// countingStorage counts bytes read from objects
+type countingStorage struct {
+	*adapters.MemoryAdapter
+	gets int
+	read int64
+}
+
+func (c *countingStorage) GetObj(ctx context.Context, obj *adapters.S3Obj) (io.ReadCloser, error) {
+	body, err := c.MemoryAdapter.GetObj(ctx, obj)
+	if err != nil {
+		return nil, err
+	}
+	c.gets++
+
+	return &countingBody{ReadCloser: body, storage: c}, nil
+}
+
+type countingBody struct {
+	io.ReadCloser
+	storage *countingStorage
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.storage.read += int64(n)
+
+	return n, err
+}
+
+func newTestTasks(t *testing.T, maxFileSize int64) (*Tasks, *countingStorage) {
+	t.Helper()
+
+	s3Config := &configs.S3Config{Bucket: "test"}
+	resizerConfig := &configs.ResizerConfig{Engine: "imgproxy", Filter: "lanczos"}
+	storage := &countingStorage{MemoryAdapter: adapters.NewMemoryAdapter(s3Config)}
+
+	tasks := NewTasks(
+		s3Config, resizerConfig, storage, imgproxy.NewPresets(&configs.ImgProxyConfig{DefaultPreset: "medium"}),
+		resizer.NewResizer(resizerConfig), policy.NewLimits(&configs.AppConfig{MaxFileSize: maxFileSize}, &configs.UploadConfig{}),
+	)
+
+	return tasks, storage
+}
+
+// noisePNG doesn't compress, so its size is about 3 bytes per pixel
+func noisePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	rnd := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(rnd.Intn(256)), G: uint8(rnd.Intn(256)), B: uint8(rnd.Intn(256)), A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestExtractMetadataSkipsNonImages(t *testing.T) {
+	tasks, storage := newTestTasks(t, 0)
+
+	storage.PutObj(context.Background(), &adapters.S3Obj{
+		Key:         "doc.pdf",
+		Bytes:       []byte("%PDF-1.4\n"),
+		ContentType: "application/pdf",
+	})
+
+	meta, err := tasks.ExtractMetadata(context.Background(), "doc.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Size != 9 || meta.ContentType != "application/pdf" || meta.Width != 0 {
+		t.Errorf("got %+v", meta)
+	}
+	if storage.gets != 0 {
+		t.Errorf("file was read %d times", storage.gets)
+	}
+}
+
+func TestExtractMetadataReadsHeaderOfLargeImage(t *testing.T) {
+	data := noisePNG(t, 600, 400)
+	if len(data) <= headerSize {
+		t.Fatalf("image of %d bytes fits header", len(data))
+	}
+	tasks, storage := newTestTasks(t, headerSize)
+
+	storage.PutObj(context.Background(), &adapters.S3Obj{
+		Key:         "large.png",
+		Bytes:       data,
+		ContentType: "image/png",
+	})
+
+	meta, err := tasks.ExtractMetadata(context.Background(), "large.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Width != 600 || meta.Height != 400 || meta.Size != int64(len(data)) {
+		t.Errorf("got %+v", meta)
+	}
+	if meta.BlurHash != "" {
+		t.Errorf("image over limits got placeholders")
+	}
+	if storage.read > headerSize {
+		t.Errorf("read %d bytes of %d", storage.read, len(data))
+	}
+}
+
+func TestExtractMetadataPlaceholders(t *testing.T) {
+	tasks, storage := newTestTasks(t, headerSize)
+
+	storage.PutObj(context.Background(), &adapters.S3Obj{
+		Key:         "small.png",
+		Bytes:       noisePNG(t, 32, 24),
+		ContentType: "image/png",
+	})
+
+	meta, err := tasks.ExtractMetadata(context.Background(), "small.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Width != 32 || meta.BlurHash == "" || meta.LQIP == "" {
+		t.Errorf("got %+v", meta)
+	}
+}
+
+func TestVariants(t *testing.T) {
+	s3Config := &configs.S3Config{Bucket: "test", PublicURL: "https://cdn.example.com/files/"}
+	resizerConfig := &configs.ResizerConfig{Engine: "imgproxy", Filter: "lanczos", Variants: []string{"_small", "medium", "_blurry"}}
+	storage := adapters.NewMemoryAdapter(s3Config)
+	tasks := NewTasks(
+		s3Config, resizerConfig, storage, imgproxy.NewPresets(&configs.ImgProxyConfig{DefaultPreset: "medium"}),
+		resizer.NewResizer(resizerConfig), policy.NewLimits(&configs.AppConfig{}, &configs.UploadConfig{}),
+	)
+
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, image.NewRGBA(image.Rect(0, 0, 800, 400)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	type size struct{ width, height int }
+	tests := []struct {
+		key         string
+		data        []byte
+		contentType string
+		sizes       map[string]size
+	}{
+		// fit into 320 and 640 squares, blurry is enlarged
+		{"dir/a b ü.jpg", jpg.Bytes(), "image/jpeg", map[string]size{
+			"_small": {320, 160}, "medium": {640, 320}, "_blurry": {320, 160},
+		}},
+		{"small.png", noisePNG(t, 200, 100), "image/png", map[string]size{
+			"_small": {200, 100}, "medium": {200, 100}, "_blurry": {320, 160},
+		}},
+	}
+
+	for _, tt := range tests {
+		storage.PutObj(context.Background(), &adapters.S3Obj{Key: tt.key, Bytes: tt.data, ContentType: tt.contentType})
+
+		result, err := tasks.Variants(context.Background(), &Job{Type: TypeVariants, Key: tt.key})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.key, err)
+		}
+		if len(result) != len(tt.sizes) {
+			t.Errorf("%s: got %v", tt.key, result)
+		}
+
+		for preset, want := range tt.sizes {
+			// upload response lists the same URLs before the job is done
+			variantKey := resizer.VariantKey(tt.key, preset)
+			if got, want := result[preset], s3Config.ObjectURL(variantKey); got != want {
+				t.Errorf("%s: got %s url %q, want %q", tt.key, preset, got, want)
+			}
+
+			// the URL points to the object the job wrote
+			u, err := url.Parse(result[preset])
+			if err != nil {
+				t.Fatalf("%s: %v", tt.key, err)
+			}
+			if got := strings.TrimPrefix(u.Path, "/files/"); got != variantKey {
+				t.Errorf("%s: %s url points to %q, want %q", tt.key, preset, got, variantKey)
+			}
+
+			obj := storage.Object("test", variantKey)
+			if obj == nil {
+				t.Errorf("%s: no %s at %q", tt.key, preset, variantKey)
+				continue
+			}
+			if obj.ContentType != tt.contentType {
+				t.Errorf("%s: got %s of %s, want %s", tt.key, preset, obj.ContentType, tt.contentType)
+			}
+
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(obj.Bytes))
+			if err != nil {
+				t.Fatalf("%s: %s: %v", tt.key, preset, err)
+			}
+			if cfg.Width != want.width || cfg.Height != want.height {
+				t.Errorf("%s: got %s of %dx%d, want %dx%d", tt.key, preset, cfg.Width, cfg.Height, want.width, want.height)
+			}
+		}
+	}
+}
+
+func TestVariantsSkipsFiles(t *testing.T) {
+	tasks, storage := newTestTasks(t, 0)
+	tasks.resizerConfig = &configs.ResizerConfig{Variants: []string{"small"}}
+
+	storage.PutObj(context.Background(), &adapters.S3Obj{Key: "doc.pdf", Bytes: []byte("%PDF-1.4\n"), ContentType: "application/pdf"})
+
+	for _, key := range []string{"doc.pdf", "deleted.png"} {
+		result, err := tasks.Variants(context.Background(), &Job{Type: TypeVariants, Key: key})
+		if err != nil || result != nil {
+			t.Errorf("%s: got %v, %v", key, result, err)
+		}
+	}
+	if storage.Object("test", resizer.VariantKey("doc.pdf", "small")) != nil {
+		t.Errorf("variant of pdf is stored")
+	}
+}
@@ -0,0 +1,11 @@
+package jobs
+
+import (
+	"github.com/google/wire"
+)
+
+var JobsSet = wire.NewSet(
+	NewJobStore,
+	NewTasks,
+	NewQueue,
+)
@@ -0,0 +1,54 @@
+package locks
+
+import (
+	"sync"
+)
+
+// Keyed serializes work on the same key. A mutex of the key exists only
+// while it's held or awaited, so keys don't pile up. Zero value is ready to use
+type Keyed struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	// refs are holders and waiters of the lock
+	refs int
+}
+
+// Lock locks key and returns unlock
+func (k *Keyed) Lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyLock)
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+
+		k.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// Len is number of keys locked or awaited
+func (k *Keyed) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return len(k.locks)
+}
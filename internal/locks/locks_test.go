@@ -0,0 +1,53 @@
+package locks
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestKeyedSerializesKey(t *testing.T) {
+	var k Keyed
+
+	counter := 0
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			unlock := k.Lock("a")
+			defer unlock()
+
+			// racy without the lock, go test -race catches it
+			counter++
+		}()
+	}
+	wg.Wait()
+
+	if counter != 100 {
+		t.Errorf("got counter %d, want 100", counter)
+	}
+	if n := k.Len(); n != 0 {
+		t.Errorf("%d locks left after unlock", n)
+	}
+}
+
+func TestKeyedRemovesUnlocked(t *testing.T) {
+	var k Keyed
+
+	unlockA := k.Lock("a")
+	unlockB := k.Lock("b")
+	if n := k.Len(); n != 2 {
+		t.Fatalf("got %d locks, want 2", n)
+	}
+
+	unlockA()
+	if n := k.Len(); n != 1 {
+		t.Errorf("got %d locks after unlock of a, want 1", n)
+	}
+
+	unlockB()
+	if n := k.Len(); n != 0 {
+		t.Errorf("got %d locks after unlock of b, want 0", n)
+	}
+}
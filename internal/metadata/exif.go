@@ -0,0 +1,340 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+var errBadExif = errors.New("[Metadata] Bad EXIF")
+
+// Exif are camera and GPS fields of EXIF
+type Exif struct {
+	Make         string  `json:"make,omitempty"`
+	Model        string  `json:"model,omitempty"`
+	LensModel    string  `json:"lensModel,omitempty"`
+	Software     string  `json:"software,omitempty"`
+	DateTime     string  `json:"dateTime,omitempty"`
+	ExposureTime string  `json:"exposureTime,omitempty"`
+	FNumber      float64 `json:"fNumber,omitempty"`
+	ISO          int     `json:"iso,omitempty"`
+	FocalLength  float64 `json:"focalLength,omitempty"`
+	GPS          *GPS    `json:"gps,omitempty"`
+	// Orientation is 1..8, 1 is normal
+	Orientation int `json:"-"`
+}
+
+type GPS struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude,omitempty"`
+}
+
+// EXIF tags
+const (
+	tagMake             = 0x010f
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagSoftware         = 0x0131
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagExposureTime     = 0x829a
+	tagFNumber          = 0x829d
+	tagISO              = 0x8827
+	tagDateTimeOriginal = 0x9003
+	tagFocalLength      = 0x920a
+	tagLensModel        = 0xa434
+
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+	tagGPSAltitudeRef  = 0x0005
+	tagGPSAltitude     = 0x0006
+)
+
+// EXIF field types
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeUndefined = 7
+	typeSLong     = 9
+	typeSRational = 10
+)
+
+var typeSizes = map[uint16]int{
+	typeByte:      1,
+	typeASCII:     1,
+	typeShort:     2,
+	typeLong:      4,
+	typeRational:  8,
+	typeUndefined: 1,
+	typeSLong:     4,
+	typeSRational: 8,
+}
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// FindExif returns raw TIFF structured EXIF of JPEG, PNG or WebP file, nil if there is none
+func FindExif(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return jpegExif(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return pngChunk(data, "eXIf")
+	case isWebP(data):
+		return bytes.TrimPrefix(riffChunk(data, "EXIF"), exifHeader)
+	}
+
+	return nil
+}
+
+// jpegExif walks JPEG segments up to image data looking for APP1 Exif
+func jpegExif(data []byte) []byte {
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xff {
+			return nil
+		}
+
+		marker := data[i+1]
+		// start of scan, image data follows
+		if marker == 0xda {
+			return nil
+		}
+
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			return nil
+		}
+
+		segment := data[i+4 : i+2+size]
+		if marker == 0xe1 && bytes.HasPrefix(segment, exifHeader) {
+			return segment[len(exifHeader):]
+		}
+
+		i += 2 + size
+	}
+
+	return nil
+}
+
+// ParseExif reads fields of TIFF structured EXIF
+func ParseExif(tiff []byte) (*Exif, error) {
+	if len(tiff) < 8 {
+		return nil, errBadExif
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errBadExif
+	}
+
+	r := &exifReader{tiff: tiff, order: order}
+	e := &Exif{}
+
+	ifd0, err := r.ifd(order.Uint32(tiff[4:]))
+	if err != nil {
+		return nil, err
+	}
+
+	e.Make = r.string(ifd0[tagMake])
+	e.Model = r.string(ifd0[tagModel])
+	e.Software = r.string(ifd0[tagSoftware])
+	e.DateTime = r.string(ifd0[tagDateTime])
+	e.Orientation = r.int(ifd0[tagOrientation])
+
+	if entry, ok := ifd0[tagExifIFD]; ok {
+		if sub, err := r.ifd(uint32(r.int(entry))); err == nil {
+			if dt := r.string(sub[tagDateTimeOriginal]); dt != "" {
+				e.DateTime = dt
+			}
+			e.ExposureTime = r.fraction(sub[tagExposureTime])
+			e.FNumber = r.rational(sub[tagFNumber], 0)
+			e.ISO = r.int(sub[tagISO])
+			e.FocalLength = r.rational(sub[tagFocalLength], 0)
+			e.LensModel = r.string(sub[tagLensModel])
+		}
+	}
+
+	if entry, ok := ifd0[tagGPSIFD]; ok {
+		if gps, err := r.ifd(uint32(r.int(entry))); err == nil {
+			e.GPS = r.gps(gps)
+		}
+	}
+
+	return e, nil
+}
+
+type exifEntry struct {
+	typ   uint16
+	count uint32
+	// value is 4 bytes of value or offset of it
+	value []byte
+}
+
+type exifReader struct {
+	tiff  []byte
+	order binary.ByteOrder
+}
+
+func (r *exifReader) ifd(offset uint32) (map[uint16]exifEntry, error) {
+	if int(offset)+2 > len(r.tiff) {
+		return nil, errBadExif
+	}
+
+	n := int(r.order.Uint16(r.tiff[offset:]))
+	start := int(offset) + 2
+	if start+n*12 > len(r.tiff) {
+		return nil, errBadExif
+	}
+
+	entries := make(map[uint16]exifEntry, n)
+	for i := 0; i < n; i++ {
+		b := r.tiff[start+i*12:]
+		entries[r.order.Uint16(b)] = exifEntry{
+			typ:   r.order.Uint16(b[2:]),
+			count: r.order.Uint32(b[4:]),
+			value: b[8:12],
+		}
+	}
+
+	return entries, nil
+}
+
+// data returns bytes of entry values, inline or by offset
+func (r *exifReader) data(e exifEntry) []byte {
+	size, ok := typeSizes[e.typ]
+	if !ok || e.count == 0 || e.count > 1<<16 {
+		return nil
+	}
+
+	n := size * int(e.count)
+	if n <= 4 {
+		return e.value[:n]
+	}
+
+	offset := int(r.order.Uint32(e.value))
+	if offset+n > len(r.tiff) {
+		return nil
+	}
+
+	return r.tiff[offset : offset+n]
+}
+
+func (r *exifReader) string(e exifEntry) string {
+	if e.typ != typeASCII && e.typ != typeUndefined {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimRight(string(r.data(e)), "\x00"))
+}
+
+func (r *exifReader) int(e exifEntry) int {
+	b := r.data(e)
+
+	switch {
+	case e.typ == typeShort && len(b) >= 2:
+		return int(r.order.Uint16(b))
+	case (e.typ == typeLong || e.typ == typeSLong) && len(b) >= 4:
+		return int(r.order.Uint32(b))
+	case e.typ == typeByte && len(b) >= 1:
+		return int(b[0])
+	}
+
+	return 0
+}
+
+// rational returns i-th rational value of entry
+func (r *exifReader) rational(e exifEntry, i int) float64 {
+	num, den := r.ratio(e, i)
+	if den == 0 {
+		return 0
+	}
+
+	return float64(num) / float64(den)
+}
+
+func (r *exifReader) ratio(e exifEntry, i int) (int64, int64) {
+	if e.typ != typeRational && e.typ != typeSRational {
+		return 0, 0
+	}
+
+	b := r.data(e)
+	if len(b) < (i+1)*8 {
+		return 0, 0
+	}
+
+	b = b[i*8:]
+	if e.typ == typeSRational {
+		return int64(int32(r.order.Uint32(b))), int64(int32(r.order.Uint32(b[4:])))
+	}
+
+	return int64(r.order.Uint32(b)), int64(r.order.Uint32(b[4:]))
+}
+
+// fraction formats exposure time like cameras do: 1/250 or 2
+func (r *exifReader) fraction(e exifEntry) string {
+	num, den := r.ratio(e, 0)
+	if num == 0 || den == 0 {
+		return ""
+	}
+
+	if num >= den {
+		return formatFloat(float64(num) / float64(den))
+	}
+
+	return "1/" + formatFloat(float64(den)/float64(num))
+}
+
+func (r *exifReader) gps(ifd map[uint16]exifEntry) *GPS {
+	lat, ok := r.coordinate(ifd[tagGPSLatitude])
+	if !ok {
+		return nil
+	}
+
+	lon, ok := r.coordinate(ifd[tagGPSLongitude])
+	if !ok {
+		return nil
+	}
+
+	if r.string(ifd[tagGPSLatitudeRef]) == "S" {
+		lat = -lat
+	}
+
+	if r.string(ifd[tagGPSLongitudeRef]) == "W" {
+		lon = -lon
+	}
+
+	gps := &GPS{
+		Latitude:  lat,
+		Longitude: lon,
+		Altitude:  r.rational(ifd[tagGPSAltitude], 0),
+	}
+
+	// 1 means below sea level
+	if r.int(ifd[tagGPSAltitudeRef]) == 1 {
+		gps.Altitude = -gps.Altitude
+	}
+
+	return gps
+}
+
+// coordinate converts degrees, minutes and seconds to decimal degrees
+func (r *exifReader) coordinate(e exifEntry) (float64, bool) {
+	if e.count < 3 {
+		return 0, false
+	}
+
+	return r.rational(e, 0) + r.rational(e, 1)/60 + r.rational(e, 2)/3600, true
+}
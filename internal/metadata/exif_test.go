@@ -0,0 +1,226 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// patch returns copy of data with bytes at offset replaced by b
+func patch(data []byte, offset int, b ...byte) []byte {
+	data = append([]byte{}, data...)
+	copy(data[offset:], b)
+
+	return data
+}
+
+func ratio(num, den uint32) []byte {
+	return append(long(num), long(den)...)
+}
+
+// cameraExif is TIFF structured EXIF with every field ParseExif reads
+func cameraExif() []byte {
+	exifIFD := []ifdEntry{
+		{tagDateTimeOriginal, typeASCII, 20, []byte("2023:05:01 10:20:30\x00")},
+		{tagExposureTime, typeRational, 1, ratio(1, 250)},
+		{tagFNumber, typeRational, 1, ratio(28, 10)},
+		{tagISO, typeShort, 1, short(400)},
+		{tagFocalLength, typeRational, 1, ratio(50, 1)},
+		{tagLensModel, typeASCII, 9, []byte("EF50mm  \x00")},
+	}
+	gpsIFD := []ifdEntry{
+		{tagGPSLatitudeRef, typeASCII, 2, []byte("S\x00")},
+		{tagGPSLatitude, typeRational, 3, rationals(33, 51, 36)},
+		{tagGPSLongitudeRef, typeASCII, 2, []byte("W\x00")},
+		{tagGPSLongitude, typeRational, 3, rationals(70, 30, 0)},
+		{tagGPSAltitudeRef, typeByte, 1, []byte{1}},
+		{tagGPSAltitude, typeRational, 1, ratio(25, 2)},
+	}
+	ifd0 := []ifdEntry{
+		{tagMake, typeASCII, 6, []byte("Canon\x00")},
+		{tagModel, typeASCII, 9, []byte("EOS 5D\x00\x00\x00")},
+		{tagOrientation, typeShort, 1, short(8)},
+		{tagSoftware, typeASCII, 4, []byte("1.0\x00")},
+		{tagDateTime, typeASCII, 20, []byte("2023:05:02 00:00:00\x00")},
+		{tagExifIFD, typeLong, 1, nil},
+		{tagGPSIFD, typeLong, 1, nil},
+	}
+	exifOffset := 8 + ifdSize(ifd0)
+	ifd0[5].value = long(exifOffset)
+	ifd0[6].value = long(exifOffset + ifdSize(exifIFD))
+
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8}
+	tiff = appendIFD(tiff, ifd0)
+	tiff = appendIFD(tiff, exifIFD)
+
+	return appendIFD(tiff, gpsIFD)
+}
+
+func TestParseExif(t *testing.T) {
+	exif, err := ParseExif(cameraExif())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Exif{
+		Make:         "Canon",
+		Model:        "EOS 5D",
+		LensModel:    "EF50mm",
+		Software:     "1.0",
+		DateTime:     "2023:05:01 10:20:30",
+		ExposureTime: "1/250",
+		FNumber:      2.8,
+		ISO:          400,
+		FocalLength:  50,
+		Orientation:  8,
+	}
+	gps := exif.GPS
+	exif.GPS = nil
+	if *exif != want {
+		t.Errorf("got %+v, want %+v", exif, want)
+	}
+
+	// south, west and below sea level are negative
+	if gps == nil || math.Abs(gps.Latitude+33.86) > 1e-9 || gps.Longitude != -70.5 || gps.Altitude != -12.5 {
+		t.Errorf("got GPS %+v", gps)
+	}
+}
+
+func TestParseExifLittleEndian(t *testing.T) {
+	tiff := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, tagOrientation)
+	tiff = binary.LittleEndian.AppendUint16(tiff, typeShort)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = append(binary.LittleEndian.AppendUint16(tiff, 3), 0, 0, 0, 0, 0, 0)
+
+	exif, err := ParseExif(tiff)
+	if err != nil || exif.Orientation != 3 {
+		t.Errorf("got %+v, %v, want orientation 3", exif, err)
+	}
+}
+
+func TestParseExifBroken(t *testing.T) {
+	camera := cameraExif()
+	ifd0Size := int(binary.BigEndian.Uint16(camera[8:]))*12 + 2
+
+	// with is camera with bytes at offset replaced by b
+	with := func(offset int, b ...byte) []byte {
+		return patch(camera, offset, b...)
+	}
+	// entry is offset of i-th entry of IFD0
+	entry := func(i int) int {
+		return 8 + 2 + i*12
+	}
+
+	tests := []struct {
+		name string
+		tiff []byte
+		// fails is true if ParseExif returns error, otherwise want is checked
+		fails bool
+		want  func(e *Exif) bool
+	}{
+		{"empty", nil, true, nil},
+		{"header only", camera[:7], true, nil},
+		{"byte order", with(0, 'X', 'X'), true, nil},
+		{"IFD0 offset", with(4, 0xff, 0xff, 0xff, 0xff), true, nil},
+		{"IFD0 count", camera[:9], true, nil},
+		{"IFD0 entries", camera[:8+ifd0Size-1], true, nil},
+		{"entry count", with(8, 0xff, 0xff), true, nil},
+		{
+			"values cut", camera[:8+ifd0Size+4],
+			false, func(e *Exif) bool { return e.Make == "" && e.Orientation == 8 && e.GPS == nil && e.ISO == 0 },
+		},
+		{
+			"value offset", with(entry(0)+8, 0xff, 0xff, 0xff, 0xf0),
+			false, func(e *Exif) bool { return e.Make == "" && e.Model == "EOS 5D" },
+		},
+		{
+			"huge count", with(entry(1)+4, 0xff, 0xff, 0xff, 0xff),
+			false, func(e *Exif) bool { return e.Model == "" && e.Make == "Canon" },
+		},
+		{
+			"unknown type", with(entry(2)+2, 0, 99),
+			false, func(e *Exif) bool { return e.Orientation == 0 },
+		},
+		{
+			"string as int", with(entry(2)+2, 0, typeASCII),
+			false, func(e *Exif) bool { return e.Orientation == 0 },
+		},
+		{
+			"sub IFD offsets", patch(with(entry(5)+8, 0xff, 0xff, 0xff, 0xff), entry(6)+8, 0xff, 0xff, 0xff, 0xff),
+			false, func(e *Exif) bool { return e.ISO == 0 && e.GPS == nil && e.Make == "Canon" },
+		},
+	}
+
+	for _, tt := range tests {
+		exif, err := ParseExif(tt.tiff)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("%s: got %+v, want error", tt.name, exif)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !tt.want(exif) {
+			t.Errorf("%s: got %+v %+v", tt.name, exif, exif.GPS)
+		}
+	}
+}
+
+func TestFindExifTruncated(t *testing.T) {
+	data := testJPEG(t, 8, 8, jpegSegment(0xe1, append(append([]byte{}, exifHeader...), cameraExif()...)))
+
+	// every prefix of the file is read without panics, EXIF is found once it's whole
+	found := false
+	for n := 0; n <= len(data); n++ {
+		tiff := FindExif(data[:n])
+		if tiff == nil {
+			continue
+		}
+
+		if !bytes.Equal(tiff, cameraExif()) {
+			t.Fatalf("%d bytes: got %d bytes of EXIF", n, len(tiff))
+		}
+		found = true
+		if _, err := ParseExif(tiff); err != nil {
+			t.Errorf("%d bytes: %v", n, err)
+		}
+	}
+
+	if !found {
+		t.Errorf("EXIF isn't found")
+	}
+}
+
+func FuzzParseExif(f *testing.F) {
+	f.Add(cameraExif())
+	f.Add(testExif(6))
+	f.Add(orientationExif(3))
+	f.Add([]byte("II*\x00\x08\x00\x00\x00\xff\xff"))
+
+	f.Fuzz(func(t *testing.T, tiff []byte) {
+		exif, err := ParseExif(tiff)
+		if err == nil && exif == nil {
+			t.Errorf("got nil without error")
+		}
+	})
+}
+
+func FuzzInspect(f *testing.F) {
+	f.Add(testJPEG(f, 4, 4, jpegSegment(0xe1, append(append([]byte{}, exifHeader...), cameraExif()...))))
+	f.Add(testPNG(f, 4, 4, pngChunkOf("eXIf", testExif(6))))
+	f.Add(testWebP(0x08, appendRIFFChunk(nil, "EXIF", testExif(6))))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var m Metadata
+		if m.Inspect(data) && (m.Orientation < 1 || m.Orientation > 8) {
+			t.Errorf("got orientation %d", m.Orientation)
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// Frames counts frames of GIF, PNG (APNG) and WebP without decoding them,
+// other formats and still images have 1 frame
+func Frames(data []byte) int {
+	frames := 0
+
+	switch {
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		frames = gifFrames(data)
+	case bytes.HasPrefix(data, pngSignature):
+		// acTL: num_frames, num_plays
+		if actl := pngChunk(data, "acTL"); len(actl) >= 4 {
+			frames = int(binary.BigEndian.Uint32(actl))
+		}
+	case isWebP(data):
+		frames = riffChunks(data, "ANMF")
+	}
+
+	if frames < 1 {
+		return 1
+	}
+
+	return frames
+}
+
+// gifFrames walks GIF blocks counting image descriptors
+func gifFrames(data []byte) int {
+	if len(data) < 13 {
+		return 0
+	}
+
+	i := 13
+	// global color table
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << (flags&0x07 + 1)
+	}
+
+	frames := 0
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // extension: label, sub-blocks
+			i = skipSubBlocks(data, i+2)
+		case 0x2c: // image descriptor, local color table, LZW code size, sub-blocks
+			if i+10 > len(data) {
+				return frames
+			}
+			frames++
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			i = skipSubBlocks(data, i+1)
+		default: // trailer or garbage
+			return frames
+		}
+	}
+
+	return frames
+}
+
+func skipSubBlocks(data []byte, i int) int {
+	for i < len(data) {
+		size := int(data[i])
+		i++
+		if size == 0 {
+			return i
+		}
+		i += size
+	}
+
+	return i
+}
+
+// pngChunk returns data of the first chunk of type before image data ends
+func pngChunk(data []byte, typ string) []byte {
+	i := len(pngSignature)
+	for i+8 <= len(data) {
+		size := int(binary.BigEndian.Uint32(data[i:]))
+		name := string(data[i+4 : i+8])
+		if size < 0 || i+12+size > len(data) {
+			return nil
+		}
+
+		if name == typ {
+			return data[i+8 : i+8+size]
+		}
+		if name == "IEND" {
+			return nil
+		}
+
+		i += 12 + size
+	}
+
+	return nil
+}
+
+func isWebP(data []byte) bool {
+	return len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// riffChunk returns data of the first WebP chunk of type
+func riffChunk(data []byte, typ string) []byte {
+	var found []byte
+	walkRIFF(data, func(name string, chunk []byte) bool {
+		if name == typ {
+			found = chunk
+			return false
+		}
+		return true
+	})
+
+	return found
+}
+
+// riffChunks counts WebP chunks of type
+func riffChunks(data []byte, typ string) int {
+	n := 0
+	walkRIFF(data, func(name string, chunk []byte) bool {
+		if name == typ {
+			n++
+		}
+		return true
+	})
+
+	return n
+}
+
+func walkRIFF(data []byte, fn func(name string, chunk []byte) bool) {
+	i := 12
+	for i+8 <= len(data) {
+		name := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if size < 0 || i+8+size > len(data) {
+			return
+		}
+
+		if !fn(name, data[i+8:i+8+size]) {
+			return
+		}
+
+		// chunks are padded to even size
+		i += 8 + size + size&1
+	}
+}
@@ -0,0 +1,99 @@
+package metadata
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strconv"
+	"time"
+)
+
+// Prefix of metadata sidecars, internal keys start with "."
+const Prefix = ".meta/"
+
+// Metadata of uploaded file, stored as .meta/<key>.json sidecar
+type Metadata struct {
+	Key         string `json:"key"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	Format      string `json:"format,omitempty"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+	// Orientation is EXIF orientation 1..8, width and height are as stored, not rotated
+	Orientation int       `json:"orientation,omitempty"`
+	ColorModel  string    `json:"colorModel,omitempty"`
+	Frames      int       `json:"frames,omitempty"`
+	Exif        *Exif     `json:"exif,omitempty"`
+	BlurHash    string    `json:"blurhash,omitempty"`
+	LQIP        string    `json:"lqip,omitempty"`
+	ExtractedAt time.Time `json:"extractedAt"`
+}
+
+// Key is storage key of metadata sidecar of the file
+func Key(key string) string {
+	return Prefix + key + ".json"
+}
+
+// Inspect fills image fields of m from file data, reading headers only.
+// It returns false if data isn't an image
+func (m *Metadata) Inspect(data []byte) bool {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return false
+	}
+
+	m.Format = format
+	m.Width = cfg.Width
+	m.Height = cfg.Height
+	m.ColorModel = colorModelName(cfg.ColorModel)
+	m.Frames = Frames(data)
+	m.Orientation = 1
+
+	if tiff := FindExif(data); tiff != nil {
+		if exif, err := ParseExif(tiff); err == nil {
+			if exif.Orientation >= 1 && exif.Orientation <= 8 {
+				m.Orientation = exif.Orientation
+			}
+			if *exif != (Exif{Orientation: exif.Orientation}) {
+				m.Exif = exif
+			}
+		}
+	}
+
+	return true
+}
+
+func colorModelName(model color.Model) string {
+	switch model {
+	case color.RGBAModel:
+		return "rgba"
+	case color.RGBA64Model:
+		return "rgba64"
+	case color.NRGBAModel:
+		return "nrgba"
+	case color.NRGBA64Model:
+		return "nrgba64"
+	case color.GrayModel:
+		return "gray"
+	case color.Gray16Model:
+		return "gray16"
+	case color.AlphaModel, color.Alpha16Model:
+		return "alpha"
+	case color.YCbCrModel:
+		return "ycbcr"
+	case color.NYCbCrAModel:
+		return "nycbcra"
+	case color.CMYKModel:
+		return "cmyk"
+	}
+
+	if _, ok := model.(color.Palette); ok {
+		return "paletted"
+	}
+
+	return ""
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,151 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+var iccHeader = []byte("ICC_PROFILE\x00")
+
+// pngColor are chunks describing color space of PNG pixels
+var pngColor = map[string]bool{
+	"iCCP": true,
+	"sRGB": true,
+	"gAMA": true,
+	"cHRM": true,
+}
+
+// CopyProfile adds color profile of src to dst re-encoded from it in the same
+// format: ICC segments of JPEG, iCCP, sRGB, gAMA and cHRM chunks of PNG. Decoded
+// pixels keep color space of src, so they look right only with its profile.
+// CMYK profiles aren't copied, such pixels are converted to RGB on encoding
+func CopyProfile(src, dst []byte) []byte {
+	switch {
+	case bytes.HasPrefix(src, []byte{0xff, 0xd8}) && bytes.HasPrefix(dst, []byte{0xff, 0xd8}):
+		return copyJPEGProfile(src, dst)
+	case bytes.HasPrefix(src, pngSignature) && bytes.HasPrefix(dst, pngSignature):
+		return copyPNGProfile(src, dst)
+	}
+
+	return dst
+}
+
+func copyJPEGProfile(src, dst []byte) []byte {
+	var segments []byte
+	cmyk := false
+	walkJPEG(src, func(marker byte, segment []byte) bool {
+		payload := segment[4:]
+		if marker != 0xe2 || !bytes.HasPrefix(payload, iccHeader) {
+			return true
+		}
+
+		// sequence number and count of chunks precede the profile,
+		// the first chunk has profile header with its color space
+		profile := payload[len(iccHeader):]
+		if len(profile) >= 2+20 && profile[0] == 1 && string(profile[2+16:2+20]) == "CMYK" {
+			cmyk = true
+		}
+		segments = append(segments, segment...)
+
+		return true
+	})
+
+	if segments == nil || cmyk {
+		return dst
+	}
+
+	// JFIF must stay the first segment
+	at := 2
+	walkJPEG(dst, func(marker byte, segment []byte) bool {
+		if marker == 0xe0 {
+			at += len(segment)
+		}
+		return false
+	})
+
+	out := make([]byte, 0, len(dst)+len(segments))
+	out = append(out, dst[:at]...)
+	out = append(out, segments...)
+
+	return append(out, dst[at:]...)
+}
+
+func copyPNGProfile(src, dst []byte) []byte {
+	var chunks []byte
+	walkPNG(src, func(name string, chunk []byte) bool {
+		if name == "IDAT" {
+			return false
+		}
+		if pngColor[name] {
+			chunks = append(chunks, chunk...)
+		}
+		return true
+	})
+
+	if chunks == nil {
+		return dst
+	}
+
+	// color chunks follow IHDR, the first chunk
+	at := -1
+	walkPNG(dst, func(name string, chunk []byte) bool {
+		if name == "IHDR" {
+			at = len(pngSignature) + len(chunk)
+		}
+		return false
+	})
+	if at < 0 {
+		return dst
+	}
+
+	out := make([]byte, 0, len(dst)+len(chunks))
+	out = append(out, dst[:at]...)
+	out = append(out, chunks...)
+
+	return append(out, dst[at:]...)
+}
+
+// walkJPEG calls fn with marker and whole segment, with marker and length,
+// of every segment before start of scan until fn returns false
+func walkJPEG(data []byte, fn func(marker byte, segment []byte) bool) {
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xff {
+			return
+		}
+
+		marker := data[i+1]
+		if marker == 0xda {
+			return
+		}
+
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			return
+		}
+
+		if !fn(marker, data[i:i+2+size]) {
+			return
+		}
+
+		i += 2 + size
+	}
+}
+
+// walkPNG calls fn with name and whole chunk, with length and CRC,
+// of every chunk until fn returns false
+func walkPNG(data []byte, fn func(name string, chunk []byte) bool) {
+	i := len(pngSignature)
+	for i+8 <= len(data) {
+		size := int(binary.BigEndian.Uint32(data[i:]))
+		if size < 0 || i+12+size > len(data) {
+			return
+		}
+
+		if !fn(string(data[i+4:i+8]), data[i:i+12+size]) {
+			return
+		}
+
+		i += 12 + size
+	}
+}
@@ -0,0 +1,213 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// Strip removes EXIF, XMP, IPTC and comments from JPEG, PNG and WebP without
+// re-encoding. Color profiles are kept. Orientation above 1 is written back as
+// the only EXIF field, other files are returned as is
+func Strip(data []byte, orientation int) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return stripJPEG(data, orientation)
+	case bytes.HasPrefix(data, pngSignature):
+		return stripPNG(data, orientation)
+	case isWebP(data):
+		return stripWebP(data, orientation)
+	}
+
+	return data
+}
+
+// Orientation reads EXIF orientation of JPEG, PNG or WebP, 1 if there is none
+func Orientation(data []byte) int {
+	tiff := FindExif(data)
+	if tiff == nil {
+		return 1
+	}
+
+	exif, err := ParseExif(tiff)
+	if err != nil || exif.Orientation < 1 || exif.Orientation > 8 {
+		return 1
+	}
+
+	return exif.Orientation
+}
+
+// orientationExif is TIFF structured EXIF with orientation tag only
+func orientationExif(orientation int) []byte {
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1}
+	tiff = binary.BigEndian.AppendUint16(tiff, tagOrientation)
+	tiff = binary.BigEndian.AppendUint16(tiff, typeShort)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, uint16(orientation))
+	// value padding and next IFD offset
+	return append(tiff, 0, 0, 0, 0, 0, 0)
+}
+
+// stripJPEG keeps segments needed to decode and show image: JFIF, ICC profile,
+// Adobe color transform, tables and frame, everything from start of scan is copied
+func stripJPEG(data []byte, orientation int) []byte {
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xff, 0xd8)
+
+	if orientation > 1 {
+		app1 := append(append([]byte{}, exifHeader...), orientationExif(orientation)...)
+		out = append(out, 0xff, 0xe1)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(app1)+2))
+		out = append(out, app1...)
+	}
+
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xff {
+			return data
+		}
+
+		marker := data[i+1]
+		// fill bytes
+		if marker == 0xff {
+			i++
+			continue
+		}
+
+		if marker == 0xda {
+			return append(out, data[i:]...)
+		}
+
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			return data
+		}
+
+		segment := data[i : i+2+size]
+		if keepJPEGSegment(marker, segment[4:]) {
+			out = append(out, segment...)
+		}
+
+		i += 2 + size
+	}
+
+	return data
+}
+
+func keepJPEGSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == 0xe0: // JFIF
+		return true
+	case marker == 0xe2: // ICC profile, not MPF
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE"))
+	case marker == 0xee: // Adobe
+		return true
+	case marker >= 0xe1 && marker <= 0xef: // EXIF, XMP, IPTC and others
+		return false
+	case marker == 0xfe: // comment
+		return false
+	}
+
+	return true
+}
+
+// pngPrivate are chunks with EXIF, text or modification time
+var pngPrivate = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+func stripPNG(data []byte, orientation int) []byte {
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+
+	i := len(pngSignature)
+	for i+8 <= len(data) {
+		size := int(binary.BigEndian.Uint32(data[i:]))
+		name := string(data[i+4 : i+8])
+		if size < 0 || i+12+size > len(data) {
+			return data
+		}
+
+		if !pngPrivate[name] {
+			out = append(out, data[i:i+12+size]...)
+		}
+
+		// eXIf must precede image data
+		if name == "IHDR" && orientation > 1 {
+			out = appendPNGChunk(out, "eXIf", orientationExif(orientation))
+		}
+
+		i += 12 + size
+		if name == "IEND" {
+			return out
+		}
+	}
+
+	return data
+}
+
+func appendPNGChunk(out []byte, name string, payload []byte) []byte {
+	out = binary.BigEndian.AppendUint32(out, uint32(len(payload)))
+	start := len(out)
+	out = append(out, name...)
+	out = append(out, payload...)
+
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(out[start:]))
+}
+
+// VP8X flags of metadata chunks
+const (
+	vp8xExif = 0x08
+	vp8xXMP  = 0x04
+)
+
+func stripWebP(data []byte, orientation int) []byte {
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+
+	vp8x := -1
+	walkRIFF(data, func(name string, chunk []byte) bool {
+		switch name {
+		case "EXIF", "XMP ":
+			return true
+		case "VP8X":
+			if len(chunk) > 0 {
+				vp8x = len(out) + 8
+			}
+		}
+
+		out = appendRIFFChunk(out, name, chunk)
+
+		return true
+	})
+
+	// only extended format has metadata, simple one is left as is
+	if vp8x < 0 {
+		return data
+	}
+
+	out[vp8x] &^= vp8xExif | vp8xXMP
+	if orientation > 1 {
+		out = appendRIFFChunk(out, "EXIF", orientationExif(orientation))
+		out[vp8x] |= vp8xExif
+	}
+
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+
+	return out
+}
+
+func appendRIFFChunk(out []byte, name string, chunk []byte) []byte {
+	out = append(out, name...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(chunk)))
+	out = append(out, chunk...)
+	if len(chunk)&1 == 1 {
+		out = append(out, 0)
+	}
+
+	return out
+}
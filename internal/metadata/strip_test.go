@@ -0,0 +1,379 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// ifdEntry is EXIF field, values over 4 bytes are written after the IFD
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func ifdSize(entries []ifdEntry) uint32 {
+	size := uint32(2 + 12*len(entries) + 4)
+	for _, e := range entries {
+		if len(e.value) > 4 {
+			size += uint32(len(e.value)+1) &^ 1
+		}
+	}
+
+	return size
+}
+
+// appendIFD writes big endian IFD at the end of tiff, data of entries follows it
+func appendIFD(tiff []byte, entries []ifdEntry) []byte {
+	data := uint32(len(tiff) + 2 + 12*len(entries) + 4)
+	var values []byte
+
+	tiff = binary.BigEndian.AppendUint16(tiff, uint16(len(entries)))
+	for _, e := range entries {
+		tiff = binary.BigEndian.AppendUint16(tiff, e.tag)
+		tiff = binary.BigEndian.AppendUint16(tiff, e.typ)
+		tiff = binary.BigEndian.AppendUint32(tiff, e.count)
+		if len(e.value) <= 4 {
+			tiff = append(tiff, append(append([]byte{}, e.value...), make([]byte, 4-len(e.value))...)...)
+			continue
+		}
+
+		tiff = binary.BigEndian.AppendUint32(tiff, data+uint32(len(values)))
+		values = append(values, e.value...)
+		if len(e.value)&1 == 1 {
+			values = append(values, 0)
+		}
+	}
+	tiff = binary.BigEndian.AppendUint32(tiff, 0)
+
+	return append(tiff, values...)
+}
+
+func short(v uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, v)
+}
+
+func long(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func rationals(values ...uint32) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint32(b, v)
+		b = binary.BigEndian.AppendUint32(b, 1)
+	}
+
+	return b
+}
+
+// testSerial is body serial number of testExif, it mustn't survive stripping
+const testSerial = "SERIAL-12345"
+
+// testExif is TIFF structured EXIF with camera, serial number, GPS and orientation
+func testExif(orientation int) []byte {
+	exifIFD := []ifdEntry{
+		{0xa431, typeASCII, uint32(len(testSerial) + 1), []byte(testSerial + "\x00")},
+	}
+	gpsIFD := []ifdEntry{
+		{tagGPSLatitudeRef, typeASCII, 2, []byte("N\x00")},
+		{tagGPSLatitude, typeRational, 3, rationals(55, 45, 21)},
+		{tagGPSLongitudeRef, typeASCII, 2, []byte("E\x00")},
+		{tagGPSLongitude, typeRational, 3, rationals(37, 37, 3)},
+	}
+	ifd0 := []ifdEntry{
+		{tagMake, typeASCII, 6, []byte("Canon\x00")},
+		{tagOrientation, typeShort, 1, short(uint16(orientation))},
+		{tagExifIFD, typeLong, 1, nil},
+		{tagGPSIFD, typeLong, 1, nil},
+	}
+	exifOffset := 8 + ifdSize(ifd0)
+	ifd0[2].value = long(exifOffset)
+	ifd0[3].value = long(exifOffset + ifdSize(exifIFD))
+
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8}
+	tiff = appendIFD(tiff, ifd0)
+	tiff = appendIFD(tiff, exifIFD)
+
+	return appendIFD(tiff, gpsIFD)
+}
+
+// testICC is start of ICC profile with its header, color space is "RGB " or "CMYK"
+func testICC(colorSpace string) []byte {
+	profile := make([]byte, 128)
+	binary.BigEndian.PutUint32(profile, 128)
+	copy(profile[12:], "mntr")
+	copy(profile[16:], colorSpace)
+	copy(profile[36:], "acsp")
+
+	return profile
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xff, marker}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+
+	return append(segment, payload...)
+}
+
+func iccSegment(profile []byte) []byte {
+	return jpegSegment(0xe2, append(append(append([]byte{}, iccHeader...), 1, 1), profile...))
+}
+
+func testImage(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 16), G: uint8(y * 16), B: 128, A: 255})
+		}
+	}
+
+	return img
+}
+
+// testJPEG is JPEG with segments inserted after SOI
+func testJPEG(t testing.TB, width, height int, segments ...[]byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(width, height), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	data := append([]byte{0xff, 0xd8}, bytes.Join(segments, nil)...)
+
+	return append(data, buf.Bytes()[2:]...)
+}
+
+// testPNG is PNG with chunks inserted after IHDR
+func testPNG(t testing.TB, width, height int, chunks ...[]byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(width, height)); err != nil {
+		t.Fatal(err)
+	}
+
+	// signature and IHDR
+	ihdr := len(pngSignature) + 12 + 13
+	data := append([]byte{}, buf.Bytes()[:ihdr]...)
+	data = append(data, bytes.Join(chunks, nil)...)
+
+	return append(data, buf.Bytes()[ihdr:]...)
+}
+
+func pngChunkOf(name string, payload []byte) []byte {
+	return appendPNGChunk(nil, name, payload)
+}
+
+// testWebP is extended WebP with VP8X flags and chunks, image data isn't real
+func testWebP(flags byte, chunks ...[]byte) []byte {
+	vp8x := []byte{flags, 0, 0, 0, 7, 0, 0, 3, 0, 0}
+
+	data := append([]byte("RIFF\x00\x00\x00\x00WEBP"), appendRIFFChunk(nil, "VP8X", vp8x)...)
+	data = append(data, bytes.Join(chunks, nil)...)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+
+	return data
+}
+
+// assertStripped checks that only orientation is left of EXIF and other metadata is gone
+func assertStripped(t *testing.T, name string, data []byte, orientation int, gone ...string) {
+	t.Helper()
+
+	for _, s := range append(gone, testSerial, "Canon") {
+		if bytes.Contains(data, []byte(s)) {
+			t.Errorf("%s: %q is kept", name, s)
+		}
+	}
+
+	if got := Orientation(data); got != orientation {
+		t.Errorf("%s: got orientation %d, want %d", name, got, orientation)
+	}
+
+	tiff := FindExif(data)
+	if orientation == 1 {
+		if tiff != nil {
+			t.Errorf("%s: EXIF is kept", name)
+		}
+		return
+	}
+
+	exif, err := ParseExif(tiff)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if exif.GPS != nil || exif.Make != "" {
+		t.Errorf("%s: got EXIF %+v, want orientation only", name, exif)
+	}
+}
+
+func TestTestExif(t *testing.T) {
+	exif, err := ParseExif(testExif(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fixture has what is stripped
+	if exif.Make != "Canon" || exif.Orientation != 6 || exif.GPS == nil || exif.GPS.Latitude < 55 || exif.GPS.Longitude < 37 {
+		t.Errorf("got %+v %+v", exif, exif.GPS)
+	}
+}
+
+func TestStripJPEG(t *testing.T) {
+	icc := iccSegment(testICC("RGB "))
+	data := testJPEG(t, 8, 4,
+		jpegSegment(0xe0, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")),
+		jpegSegment(0xe1, append(append([]byte{}, exifHeader...), testExif(6)...)),
+		jpegSegment(0xe1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>GPSLatitude</x:xmpmeta>")),
+		icc,
+		jpegSegment(0xed, []byte("Photoshop 3.0\x00IPTC")),
+		jpegSegment(0xfe, []byte("comment "+testSerial)),
+	)
+
+	for _, orientation := range []int{1, 6} {
+		stripped := Strip(data, orientation)
+
+		assertStripped(t, "jpeg", stripped, orientation, "GPSLatitude", "Photoshop", "comment")
+		if !bytes.Contains(stripped, icc) {
+			t.Errorf("orientation %d: ICC profile is dropped", orientation)
+		}
+		if !bytes.HasPrefix(stripped[2:], []byte{0xff, 0xe0}) && orientation == 1 {
+			t.Errorf("orientation %d: JFIF isn't the first segment", orientation)
+		}
+
+		img, err := jpeg.Decode(bytes.NewReader(stripped))
+		if err != nil {
+			t.Fatalf("orientation %d: %v", orientation, err)
+		}
+		if b := img.Bounds(); b.Dx() != 8 || b.Dy() != 4 {
+			t.Errorf("orientation %d: got %v", orientation, b)
+		}
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	iccp := pngChunkOf("iCCP", []byte("icc\x00\x00compressed profile"))
+	data := testPNG(t, 8, 4,
+		pngChunkOf("eXIf", testExif(3)),
+		iccp,
+		pngChunkOf("tEXt", []byte("Comment\x00"+testSerial)),
+		pngChunkOf("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00GPSLatitude")),
+		pngChunkOf("tIME", []byte{7, 232, 1, 2, 3, 4, 5}),
+	)
+
+	for _, orientation := range []int{1, 6} {
+		stripped := Strip(data, orientation)
+
+		assertStripped(t, "png", stripped, orientation, "GPSLatitude", "tIME")
+		if !bytes.Contains(stripped, iccp) {
+			t.Errorf("orientation %d: ICC profile is dropped", orientation)
+		}
+
+		// chunks are kept in order and CRCs are right
+		if _, err := png.Decode(bytes.NewReader(stripped)); err != nil {
+			t.Errorf("orientation %d: %v", orientation, err)
+		}
+	}
+}
+
+func TestStripWebP(t *testing.T) {
+	const (
+		flagICC = 0x20
+		flags   = flagICC | vp8xExif | vp8xXMP
+	)
+
+	iccp := appendRIFFChunk(nil, "ICCP", testICC("RGB "))
+	pixels := appendRIFFChunk(nil, "VP8L", []byte("odd image"))
+	data := testWebP(flags,
+		iccp,
+		pixels,
+		appendRIFFChunk(nil, "EXIF", append(append([]byte{}, exifHeader...), testExif(8)...)),
+		appendRIFFChunk(nil, "XMP ", []byte("<x:xmpmeta>GPSLatitude</x:xmpmeta>")),
+	)
+
+	for _, orientation := range []int{1, 6} {
+		stripped := Strip(data, orientation)
+
+		assertStripped(t, "webp", stripped, orientation, "GPSLatitude")
+		if !bytes.Contains(stripped, iccp) || !bytes.Contains(stripped, pixels) {
+			t.Errorf("orientation %d: ICC profile or image is dropped", orientation)
+		}
+		if size := binary.LittleEndian.Uint32(stripped[4:]); int(size) != len(stripped)-8 {
+			t.Errorf("orientation %d: RIFF size %d of %d bytes", orientation, size, len(stripped))
+		}
+
+		want := byte(flagICC)
+		if orientation > 1 {
+			want |= vp8xExif
+		}
+		if got := stripped[20]; got != want {
+			t.Errorf("orientation %d: got VP8X flags %#x, want %#x", orientation, got, want)
+		}
+	}
+}
+
+func TestStripKeepsOtherFiles(t *testing.T) {
+	jpegData := testJPEG(t, 8, 4, jpegSegment(0xe1, append(append([]byte{}, exifHeader...), testExif(6)...)))
+	simpleWebP := append([]byte("RIFF\x0e\x00\x00\x00WEBP"), appendRIFFChunk(nil, "VP8 ", []byte("image"))...)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"gif", []byte("GIF89a\x01\x00\x01\x00")},
+		{"text", []byte("hello")},
+		{"simple webp", simpleWebP},
+		// segment length past the end
+		{"truncated jpeg", jpegData[:40]},
+		{"bad segment", []byte{0xff, 0xd8, 0xff, 0xe1, 0x00, 0x01, 0x00}},
+	}
+
+	for _, tt := range tests {
+		if got := Strip(tt.data, 6); !bytes.Equal(got, tt.data) {
+			t.Errorf("%s: got %q, want it as is", tt.name, got)
+		}
+	}
+}
+
+func TestCopyProfile(t *testing.T) {
+	rgb := iccSegment(testICC("RGB "))
+	reencoded := testJPEG(t, 8, 4)
+
+	got := CopyProfile(testJPEG(t, 8, 4, rgb), reencoded)
+	if !bytes.Equal(got[2:2+len(rgb)], rgb) {
+		t.Errorf("ICC profile isn't copied after SOI")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(got)); err != nil {
+		t.Error(err)
+	}
+
+	// CMYK pixels are converted to RGB, their profile doesn't fit
+	cmyk := CopyProfile(testJPEG(t, 8, 4, iccSegment(testICC("CMYK"))), reencoded)
+	if !bytes.Equal(cmyk, reencoded) {
+		t.Errorf("CMYK profile is copied")
+	}
+
+	srgb := pngChunkOf("sRGB", []byte{0})
+	got = CopyProfile(testPNG(t, 8, 4, srgb, pngChunkOf("tEXt", []byte("a\x00b"))), testPNG(t, 8, 4))
+	if want := len(pngSignature) + 12 + 13; !bytes.Equal(got[want:want+len(srgb)], srgb) {
+		t.Errorf("sRGB isn't copied after IHDR")
+	}
+	if bytes.Contains(got, []byte("tEXt")) {
+		t.Errorf("text is copied")
+	}
+	if _, err := png.Decode(bytes.NewReader(got)); err != nil {
+		t.Error(err)
+	}
+
+	// other formats and mixed ones are left as is
+	if got := CopyProfile(testJPEG(t, 8, 4, rgb), testPNG(t, 8, 4)); !bytes.Equal(got, testPNG(t, 8, 4)) {
+		t.Errorf("JPEG profile is copied to PNG")
+	}
+}
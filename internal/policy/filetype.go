@@ -0,0 +1,119 @@
+package policy
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// SniffLen is how many first bytes Detect looks at
+const SniffLen = 512
+
+const svgType = "image/svg+xml"
+
+// Detect returns MIME type of file by its first bytes. It knows formats
+// http.DetectContentType doesn't: AVIF, HEIC, TIFF and SVG
+func Detect(head []byte) string {
+	if len(head) > SniffLen {
+		head = head[:SniffLen]
+	}
+
+	// ISO BMFF: size, "ftyp", major brand
+	if len(head) >= 12 && string(head[4:8]) == "ftyp" {
+		switch string(head[8:12]) {
+		case "avif", "avis":
+			return "image/avif"
+		case "heic", "heix", "heim", "heis", "hevc", "hevx":
+			return "image/heic"
+		case "mif1", "msf1":
+			return "image/heif"
+		}
+	}
+
+	if bytes.HasPrefix(head, []byte("II*\x00")) || bytes.HasPrefix(head, []byte("MM\x00*")) {
+		return "image/tiff"
+	}
+
+	detected := baseType(http.DetectContentType(head))
+
+	// SVG is XML or text starting with <svg or an XML prolog
+	if detected == "text/xml" || detected == "text/plain" {
+		if bytes.Contains(bytes.ToLower(head), []byte("<svg")) {
+			return svgType
+		}
+	}
+
+	return detected
+}
+
+// extensionTypes are types a file with the extension may have
+var extensionTypes = map[string][]string{
+	"jpg":  {"image/jpeg"},
+	"jpeg": {"image/jpeg"},
+	"jpe":  {"image/jpeg"},
+	"png":  {"image/png"},
+	"apng": {"image/png"},
+	"gif":  {"image/gif"},
+	"webp": {"image/webp"},
+	"avif": {"image/avif"},
+	"heic": {"image/heic", "image/heif"},
+	"heif": {"image/heic", "image/heif"},
+	"bmp":  {"image/bmp"},
+	"ico":  {"image/x-icon"},
+	"tif":  {"image/tiff"},
+	"tiff": {"image/tiff"},
+	"svg":  {svgType},
+	"pdf":  {"application/pdf"},
+	"zip":  {"application/zip"},
+	"gz":   {"application/x-gzip"},
+	"mp4":  {"video/mp4"},
+	"webm": {"video/webm"},
+	"mp3":  {"audio/mpeg"},
+	"wav":  {"audio/wave"},
+	"ogg":  {"application/ogg"},
+	"html": {"text/html"},
+	"htm":  {"text/html"},
+	"txt":  {"text/plain"},
+	"csv":  {"text/plain"},
+	"json": {"text/plain"},
+}
+
+// Extension is lower case extension of file name without dot
+func Extension(name string) string {
+	return strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+}
+
+// KnownExtension tells if types of extension of name are known
+func KnownExtension(name string) bool {
+	_, ok := extensionTypes[Extension(name)]
+	return ok
+}
+
+// MatchesExtension tells if contentType is expected for extension of name,
+// extensions it doesn't know match any type
+func MatchesExtension(name, contentType string) bool {
+	types, ok := extensionTypes[Extension(name)]
+	if !ok {
+		return true
+	}
+
+	contentType = baseType(contentType)
+	for _, t := range types {
+		if t == contentType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// baseType drops parameters like charset
+func baseType(contentType string) string {
+	if t, _, err := mime.ParseMediaType(contentType); err == nil {
+		return t
+	}
+
+	return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+}
@@ -0,0 +1,77 @@
+package policy
+
+import (
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want string
+	}{
+		{"jpeg", "\xff\xd8\xff\xe0\x00\x10JFIF\x00", "image/jpeg"},
+		{"png", "\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR", "image/png"},
+		{"gif", "GIF89a\x01\x00\x01\x00", "image/gif"},
+		{"webp", "RIFF\x1a\x00\x00\x00WEBPVP8L", "image/webp"},
+		{"avif", "\x00\x00\x00\x1cftypavif\x00\x00\x00\x00", "image/avif"},
+		{"avif sequence", "\x00\x00\x00\x1cftypavis\x00\x00\x00\x00", "image/avif"},
+		{"heic", "\x00\x00\x00\x18ftypheic\x00\x00\x00\x00", "image/heic"},
+		{"heif", "\x00\x00\x00\x18ftypmif1\x00\x00\x00\x00", "image/heif"},
+		{"mp4", "\x00\x00\x00\x10ftypmp42\x00\x00\x00\x00", "video/mp4"},
+		{"tiff little endian", "II*\x00\x08\x00\x00\x00", "image/tiff"},
+		{"tiff big endian", "MM\x00*\x00\x00\x00\x08", "image/tiff"},
+		{"svg", `<svg xmlns="http://www.w3.org/2000/svg"/>`, "image/svg+xml"},
+		{"svg with prolog", `<?xml version="1.0"?><SVG/>`, "image/svg+xml"},
+		{"xml", `<?xml version="1.0"?><feed/>`, "text/xml"},
+		{"html", "<html><svg/></html>", "text/html"},
+		{"pdf", "%PDF-1.7\n", "application/pdf"},
+		{"text", "hello", "text/plain"},
+		{"empty", "", "text/plain"},
+		{"binary", "\x00\x01\x02\x03", "application/octet-stream"},
+	}
+
+	for _, tt := range tests {
+		if got := Detect([]byte(tt.head)); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDetectLooksAtSniffLen(t *testing.T) {
+	head := make([]byte, SniffLen, SniffLen+8)
+	for i := range head {
+		head[i] = ' '
+	}
+	head = append(head, "<svg/>"...)
+
+	if got := Detect(head); got != "text/plain" {
+		t.Errorf("got %q, want text/plain as <svg is past SniffLen", got)
+	}
+}
+
+func TestMatchesExtension(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		want        bool
+	}{
+		{"a.jpg", "image/jpeg", true},
+		{"a.JPEG", "image/jpeg", true},
+		{"a.heic", "image/heif", true},
+		{"a.txt", "text/plain; charset=utf-8", true},
+		{"a.png", "image/jpeg", false},
+		{"a.svg", "text/xml", false},
+		{"evil.html", "image/png", false},
+		{"a.jpg.html", "image/jpeg", false},
+		// unknown extensions are left to policy
+		{"a.xyz", "image/png", true},
+		{"a", "image/png", true},
+	}
+
+	for _, tt := range tests {
+		if got := MatchesExtension(tt.name, tt.contentType); got != tt.want {
+			t.Errorf("%s as %s: got %v, want %v", tt.name, tt.contentType, got, tt.want)
+		}
+	}
+}
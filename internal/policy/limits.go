@@ -0,0 +1,110 @@
+package policy
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+
+	_ "golang.org/x/image/webp"
+
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/WildEgor/gImageResizer/internal/metadata"
+)
+
+// Limits exceeded by uploads
+const (
+	LimitFileSize   = "fileSize"
+	LimitWidth      = "width"
+	LimitHeight     = "height"
+	LimitResolution = "resolution"
+	LimitFrames     = "frames"
+)
+
+// LimitError tells which limit a file exceeds, resolution is in megapixels
+type LimitError struct {
+	Limit  string  `json:"limit"`
+	Max    float64 `json:"max"`
+	Actual float64 `json:"actual"`
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("[Policy] File %v %v exceeds %v", e.Limit, e.Actual, e.Max)
+}
+
+// Limits protect from decompression bombs, zero or negative ones are off
+type Limits struct {
+	MaxFileSize   int64
+	MaxWidth      int
+	MaxHeight     int
+	MaxResolution float64
+	MaxFrames     int
+}
+
+// NewLimits takes file size of any upload from AppConfig, image limits from UploadConfig
+func NewLimits(appConfig *configs.AppConfig, config *configs.UploadConfig) *Limits {
+	return &Limits{
+		MaxFileSize:   appConfig.MaxFileSize,
+		MaxWidth:      config.MaxWidth,
+		MaxHeight:     config.MaxHeight,
+		MaxResolution: config.MaxResolution,
+		MaxFrames:     config.MaxAnimationFrames,
+	}
+}
+
+func (l *Limits) CheckSize(size int64) error {
+	if l.MaxFileSize > 0 && size > l.MaxFileSize {
+		return &LimitError{Limit: LimitFileSize, Max: float64(l.MaxFileSize), Actual: float64(size)}
+	}
+
+	return nil
+}
+
+// CheckImage reads image header without decoding pixels, animated formats
+// are read to the end to count frames. Formats Go can't parse aren't checked
+func (l *Limits) CheckImage(src io.ReadSeeker) error {
+	config, format, err := image.DecodeConfig(src)
+	if err != nil {
+		return nil
+	}
+
+	if l.MaxWidth > 0 && config.Width > l.MaxWidth {
+		return &LimitError{Limit: LimitWidth, Max: float64(l.MaxWidth), Actual: float64(config.Width)}
+	}
+
+	if l.MaxHeight > 0 && config.Height > l.MaxHeight {
+		return &LimitError{Limit: LimitHeight, Max: float64(l.MaxHeight), Actual: float64(config.Height)}
+	}
+
+	megapixels := float64(config.Width) * float64(config.Height) / 1000000
+	if l.MaxResolution > 0 && megapixels > l.MaxResolution {
+		return &LimitError{Limit: LimitResolution, Max: l.MaxResolution, Actual: megapixels}
+	}
+
+	if l.MaxFrames <= 0 || (format != "gif" && format != "png" && format != "webp") {
+		return nil
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return err
+	}
+
+	frames := metadata.Frames(data)
+	if frames > l.MaxFrames {
+		return &LimitError{Limit: LimitFrames, Max: float64(l.MaxFrames), Actual: float64(frames)}
+	}
+
+	// imgproxy limits total resolution of all frames of animation
+	if frames > 1 && l.MaxResolution > 0 && megapixels*float64(frames) > l.MaxResolution {
+		return &LimitError{Limit: LimitResolution, Max: l.MaxResolution, Actual: megapixels * float64(frames)}
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package policy
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	log "github.com/sirupsen/logrus"
+)
+
+// Routes policies may be set for
+const (
+	RouteUpload  = "upload"
+	RoutePresign = "presign"
+	RouteTus     = "tus"
+)
+
+// Policies resolve policy of a request: tenant one, else route one, else default
+type Policies struct {
+	Default *Policy            `json:"default"`
+	Routes  map[string]*Policy `json:"routes"`
+	Tenants map[string]*Policy `json:"tenants"`
+}
+
+// NewPolicies makes default policy of UPLOAD_ALLOWED_TYPES and UPLOAD_ALLOWED_EXTENSIONS,
+// UPLOAD_POLICIES_FILE may override it and add route and tenant policies
+func NewPolicies(config *configs.UploadConfig) *Policies {
+	p := &Policies{
+		Default: &Policy{
+			Types:      config.AllowedTypes,
+			Extensions: config.AllowedExtensions,
+		},
+	}
+
+	if config.PoliciesFile != "" {
+		b, err := os.ReadFile(config.PoliciesFile)
+		if err != nil {
+			log.Error(err)
+			log.Fatal("[Policy] Failed read policies file")
+		}
+
+		if err := json.Unmarshal(b, p); err != nil {
+			log.Error(err)
+			log.Fatal("[Policy] Bad policies")
+		}
+
+		if p.Default == nil {
+			p.Default = &Policy{}
+		}
+	}
+
+	for route := range p.Routes {
+		switch route {
+		case RouteUpload, RoutePresign, RouteTus:
+		default:
+			log.Fatalf("[Policy] Unknown route %v", route)
+		}
+	}
+
+	return p
+}
+
+// For returns policy of route for tenant, tenant may be empty
+func (p *Policies) For(route, tenant string) *Policy {
+	if policy, ok := p.Tenants[tenant]; ok && tenant != "" {
+		return policy
+	}
+
+	if policy, ok := p.Routes[route]; ok {
+		return policy
+	}
+
+	return p.Default
+}
@@ -0,0 +1,79 @@
+package policy
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrTypeNotAllowed      = errors.New("[Policy] File type not allowed")
+	ErrExtensionNotAllowed = errors.New("[Policy] File extension not allowed")
+	ErrExtensionMismatch   = errors.New("[Policy] File extension doesn't match its content")
+)
+
+// Policy limits what files may be uploaded, empty lists allow anything
+type Policy struct {
+	// Types are MIME types like image/png or image/*
+	Types []string `json:"types"`
+	// Extensions without dot, e.g. jpg
+	Extensions []string `json:"extensions"`
+}
+
+// Check validates file name and its content type, which should be detected
+// by magic bytes. Files of known extensions must have matching content,
+// with extensions listed files of unknown ones are rejected as mismatched
+func (p *Policy) Check(name, contentType string) error {
+	if !p.AllowsExtension(name) {
+		return ErrExtensionNotAllowed
+	}
+
+	if len(p.Extensions) != 0 && !KnownExtension(name) {
+		return ErrExtensionMismatch
+	}
+
+	if !p.AllowsType(contentType) {
+		return ErrTypeNotAllowed
+	}
+
+	if !MatchesExtension(name, contentType) {
+		return ErrExtensionMismatch
+	}
+
+	return nil
+}
+
+func (p *Policy) AllowsExtension(name string) bool {
+	if len(p.Extensions) == 0 {
+		return true
+	}
+
+	ext := Extension(name)
+	for _, allowed := range p.Extensions {
+		if strings.EqualFold(strings.TrimPrefix(allowed, "."), ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowsType tells if contentType is listed, wildcards like image/* don't
+// match SVG as it may carry scripts
+func (p *Policy) AllowsType(contentType string) bool {
+	if len(p.Types) == 0 {
+		return true
+	}
+
+	contentType = baseType(contentType)
+	for _, allowed := range p.Types {
+		allowed = strings.ToLower(allowed)
+		if allowed == contentType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok && strings.HasPrefix(contentType, prefix+"/") && contentType != svgType {
+			return true
+		}
+	}
+
+	return false
+}
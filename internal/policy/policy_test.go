@@ -0,0 +1,94 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/WildEgor/gImageResizer/internal/configs"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	images := &Policy{Types: []string{"image/*"}}
+	listed := &Policy{Types: []string{"image/*", "image/svg+xml"}, Extensions: []string{"png", ".SVG", "xyz"}}
+
+	tests := []struct {
+		name        string
+		policy      *Policy
+		file        string
+		contentType string
+		want        error
+	}{
+		{"anything", &Policy{}, "a.xyz", "application/octet-stream", nil},
+		{"image", images, "a.png", "image/png", nil},
+		{"type", images, "a.pdf", "application/pdf", ErrTypeNotAllowed},
+		{"type with charset", &Policy{Types: []string{"text/plain"}}, "a.txt", "text/plain; charset=utf-8", nil},
+		{"svg by wildcard", images, "a.svg", "image/svg+xml", ErrTypeNotAllowed},
+		{"svg listed", listed, "a.svg", "image/svg+xml", nil},
+		{"mismatch", images, "a.jpg", "image/png", ErrExtensionMismatch},
+		{"html with png magic", images, "evil.html", "image/png", ErrExtensionMismatch},
+		{"extension", listed, "a.jpg", "image/jpeg", ErrExtensionNotAllowed},
+		{"no extension", listed, "a", "image/png", ErrExtensionNotAllowed},
+		{"extension case", listed, "a.PNG", "image/png", nil},
+		// listed extensions of unknown types can't be checked against content
+		{"unknown listed extension", listed, "evil.xyz", "image/png", ErrExtensionMismatch},
+		{"unknown extension without list", images, "a.xyz", "image/png", nil},
+	}
+
+	for _, tt := range tests {
+		if got := tt.policy.Check(tt.file, tt.contentType); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPoliciesFor(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policies.json")
+	err := os.WriteFile(file, []byte(`{
+		"routes": {"tus": {"types": ["video/mp4"]}},
+		"tenants": {"docs": {"types": ["application/pdf"]}}
+	}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policies := NewPolicies(&configs.UploadConfig{
+		AllowedTypes: []string{"image/*"},
+		PoliciesFile: file,
+	})
+
+	tests := []struct {
+		route  string
+		tenant string
+		want   []string
+	}{
+		// the file has no default, the one of the config is kept
+		{RouteUpload, "", []string{"image/*"}},
+		{RouteTus, "", []string{"video/mp4"}},
+		{RouteUpload, "docs", []string{"application/pdf"}},
+		{RouteTus, "docs", []string{"application/pdf"}},
+		{RouteTus, "other", []string{"video/mp4"}},
+	}
+
+	for _, tt := range tests {
+		got := policies.For(tt.route, tt.tenant).Types
+		if len(got) != len(tt.want) || len(got) != 0 && got[0] != tt.want[0] {
+			t.Errorf("%s of %q: got %v, want %v", tt.route, tt.tenant, got, tt.want)
+		}
+	}
+}
+
+func TestPoliciesForDefault(t *testing.T) {
+	policies := NewPolicies(&configs.UploadConfig{
+		AllowedTypes:      []string{"image/*"},
+		AllowedExtensions: []string{"png"},
+	})
+
+	got := policies.For(RoutePresign, "docs")
+	if err := got.Check("a.png", "image/png"); err != nil {
+		t.Errorf("got %v", err)
+	}
+	if err := got.Check("a.pdf", "application/pdf"); err != ErrExtensionNotAllowed {
+		t.Errorf("got %v, want %v", err, ErrExtensionNotAllowed)
+	}
+}
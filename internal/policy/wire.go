@@ -0,0 +1,10 @@
+package policy
+
+import (
+	"github.com/google/wire"
+)
+
+var PolicySet = wire.NewSet(
+	NewPolicies,
+	NewLimits,
+)
@@ -0,0 +1,143 @@
+package resizer
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// Blur is gaussian blur approximated by three box blurs
+func Blur(img image.Image, sigma float64) *image.NRGBA {
+	src := toNRGBA(img)
+	dst := image.NewNRGBA(src.Bounds())
+	tmp := image.NewNRGBA(src.Bounds())
+	copy(dst.Pix, src.Pix)
+
+	for _, r := range boxRadii(sigma, 3) {
+		boxBlur(dst, tmp, r, true)
+		boxBlur(tmp, dst, r, false)
+	}
+
+	return dst
+}
+
+// sharpen is unsharp mask with gaussian of sigma
+func sharpen(img image.Image, sigma float64) *image.NRGBA {
+	src := toNRGBA(img)
+	blurred := Blur(src, sigma)
+	dst := image.NewNRGBA(src.Bounds())
+
+	for i := range src.Pix {
+		// keep alpha as is
+		if i%4 == 3 {
+			dst.Pix[i] = src.Pix[i]
+			continue
+		}
+		v := 2*int(src.Pix[i]) - int(blurred.Pix[i])
+		dst.Pix[i] = clamp(v)
+	}
+
+	return dst
+}
+
+// boxRadii returns radii of n box blurs approximating gaussian of sigma
+func boxRadii(sigma float64, n int) []int {
+	wIdeal := math.Sqrt(12*sigma*sigma/float64(n) + 1)
+	wl := int(math.Floor(wIdeal))
+	if wl%2 == 0 {
+		wl--
+	}
+	wu := wl + 2
+
+	mIdeal := (12*sigma*sigma - float64(n*wl*wl) - float64(4*n*wl) - float64(3*n)) / float64(-4*wl-4)
+	m := int(math.Round(mIdeal))
+
+	radii := make([]int, n)
+	for i := range radii {
+		if i < m {
+			radii[i] = (wl - 1) / 2
+		} else {
+			radii[i] = (wu - 1) / 2
+		}
+	}
+
+	return radii
+}
+
+// boxBlur averages pixels in 2r+1 window along one axis with running sums
+func boxBlur(src, dst *image.NRGBA, r int, horizontal bool) {
+	if r < 1 {
+		copy(dst.Pix, src.Pix)
+		return
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	lines, length := h, w
+	if !horizontal {
+		lines, length = w, h
+	}
+
+	offset := func(line, i int) int {
+		if horizontal {
+			return line*src.Stride + i*4
+		}
+		return i*src.Stride + line*4
+	}
+
+	window := 2*r + 1
+	for line := 0; line < lines; line++ {
+		var sum [4]int
+		// edges are extended
+		for i := -r; i <= r; i++ {
+			o := offset(line, clampIdx(i, length))
+			for c := 0; c < 4; c++ {
+				sum[c] += int(src.Pix[o+c])
+			}
+		}
+
+		for i := 0; i < length; i++ {
+			o := offset(line, i)
+			for c := 0; c < 4; c++ {
+				dst.Pix[o+c] = uint8(sum[c] / window)
+			}
+
+			in := offset(line, clampIdx(i+r+1, length))
+			out := offset(line, clampIdx(i-r, length))
+			for c := 0; c < 4; c++ {
+				sum[c] += int(src.Pix[in+c]) - int(src.Pix[out+c])
+			}
+		}
+	}
+}
+
+func toNRGBA(img image.Image) *image.NRGBA {
+	if n, ok := img.(*image.NRGBA); ok && n.Rect.Min == (image.Point{}) {
+		return n
+	}
+
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+
+	return dst
+}
+
+func clampIdx(i, length int) int {
+	if i < 0 {
+		return 0
+	}
+	if i >= length {
+		return length - 1
+	}
+	return i
+}
+
+func clamp(v int) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
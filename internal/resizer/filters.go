@@ -0,0 +1,35 @@
+package resizer
+
+import (
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// Filters are resampling filters selectable by RESIZER_FILTER
+var Filters = map[string]draw.Interpolator{
+	"lanczos":    Lanczos,
+	"catmullrom": draw.CatmullRom,
+	"bilinear":   draw.BiLinear,
+	"nearest":    draw.NearestNeighbor,
+}
+
+// Lanczos is Lanczos3 resampling kernel, sharper than CatmullRom but slower
+var Lanczos = &draw.Kernel{
+	Support: 3,
+	At: func(t float64) float64 {
+		if t == 0 {
+			return 1
+		}
+		if t >= 3 {
+			return 0
+		}
+		return sinc(t) * sinc(t/3)
+	},
+}
+
+func sinc(x float64) float64 {
+	x *= math.Pi
+
+	return math.Sin(x) / x
+}
@@ -0,0 +1,82 @@
+package resizer
+
+import (
+	"bytes"
+	"image"
+
+	"github.com/WildEgor/gImageResizer/internal/metadata"
+)
+
+// sanitizeQuality of JPEG re-encoded to bake in orientation
+const sanitizeQuality = 90
+
+// Sanitize strips privacy-sensitive metadata from JPEG, PNG and WebP and bakes
+// EXIF orientation into pixels. Color profiles are kept. Rotated JPEG and PNG are
+// re-encoded, WebP can't be, so it keeps orientation as the only EXIF field, as well
+// as images Go can't decode. Other files are returned as is
+func Sanitize(data []byte) ([]byte, error) {
+	orientation := metadata.Orientation(data)
+	if orientation == 1 {
+		return metadata.Strip(data, 1), nil
+	}
+
+	// decoded image is already oriented
+	img, format, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		return metadata.Strip(data, orientation), nil
+	}
+
+	switch format {
+	case "jpeg", "png":
+		result, err := Encode(img, format, sanitizeQuality)
+		if err != nil {
+			return nil, err
+		}
+		return metadata.CopyProfile(data, result.Bytes), nil
+	}
+
+	return metadata.Strip(data, orientation), nil
+}
+
+// Orient transforms img shown with EXIF orientation 1..8 to normal one
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	src := toNRGBA(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+
+	dw, dh := w, h
+	// 5..8 swap sides
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // flip horizontal
+				dx, dy = w-1-x, y
+			case 3: // rotate 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // flip vertical
+				dx, dy = x, h-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotate 90 counterclockwise
+				dx, dy = y, w-1-x
+			}
+
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+
+	return dst
+}
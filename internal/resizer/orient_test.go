@@ -0,0 +1,189 @@
+package resizer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/WildEgor/gImageResizer/internal/metadata"
+)
+
+var (
+	red  = color.NRGBA{R: 255, A: 255}
+	blue = color.NRGBA{B: 255, A: 255}
+)
+
+// halves is width x height image, red on the left and blue on the right
+func halves(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			c := red
+			if x >= width/2 {
+				c = blue
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	return img
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xff, marker}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+
+	return append(segment, payload...)
+}
+
+func pngChunk(name string, payload []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(append(chunk, name...), payload...)
+
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// iccSegment is APP2 with the first chunk of RGB profile
+func iccSegment() []byte {
+	profile := make([]byte, 128)
+	copy(profile[16:], "RGB ")
+	copy(profile[36:], "acsp")
+
+	return jpegSegment(0xe2, append([]byte("ICC_PROFILE\x00\x01\x01"), profile...))
+}
+
+// rotatedJPEG is JPEG of halves shown rotated by EXIF orientation 6,
+// with ICC profile, XMP with location and comment with serial number
+func rotatedJPEG(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, halves(16, 8), &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	data := metadata.Strip(buf.Bytes(), 6)
+
+	segments := bytes.Join([][]byte{
+		iccSegment(),
+		jpegSegment(0xe1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>GPSLatitude</x:xmpmeta>")),
+		jpegSegment(0xfe, []byte("SERIAL-12345")),
+	}, nil)
+
+	return append(append(append([]byte{}, data[:2]...), segments...), data[2:]...)
+}
+
+// rotatedPNG is PNG of halves with EXIF orientation 6, sRGB and text chunks
+func rotatedPNG(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, halves(16, 8)); err != nil {
+		t.Fatal(err)
+	}
+	data := metadata.Strip(buf.Bytes(), 6)
+
+	// after signature and IHDR
+	ihdr := 8 + 12 + 13
+	chunks := append(pngChunk("sRGB", []byte{0}), pngChunk("tEXt", []byte("Comment\x00SERIAL-12345"))...)
+
+	return append(append(append([]byte{}, data[:ihdr]...), chunks...), data[ihdr:]...)
+}
+
+// isRed tells if c is close to red, JPEG is lossy
+func isRed(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r > 0xc000 && g < 0x4000 && b < 0x4000
+}
+
+func TestSanitizeRotated(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		profile []byte
+	}{
+		{"jpeg", rotatedJPEG(t), iccSegment()},
+		{"png", rotatedPNG(t), pngChunk("sRGB", []byte{0})},
+	}
+
+	for _, tt := range tests {
+		sanitized, err := Sanitize(tt.data)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		for _, s := range []string{"GPSLatitude", "SERIAL-12345", "Exif\x00\x00", "eXIf"} {
+			if bytes.Contains(sanitized, []byte(s)) {
+				t.Errorf("%s: %q is kept", tt.name, s)
+			}
+		}
+		if !bytes.Contains(sanitized, tt.profile) {
+			t.Errorf("%s: color profile is dropped", tt.name)
+		}
+		if got := metadata.Orientation(sanitized); got != 1 {
+			t.Errorf("%s: got orientation %d, want 1", tt.name, got)
+		}
+
+		// rotated 90 clockwise: the left half is on top
+		img, _, err := image.Decode(bytes.NewReader(sanitized))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if b := img.Bounds(); b.Dx() != 8 || b.Dy() != 16 {
+			t.Fatalf("%s: got %v, want 8x16", tt.name, b)
+		}
+		if !isRed(img.At(4, 3)) || isRed(img.At(4, 12)) {
+			t.Errorf("%s: got %v on top and %v at the bottom", tt.name, img.At(4, 3), img.At(4, 12))
+		}
+	}
+}
+
+func TestSanitizeNotRotated(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, halves(16, 8), nil); err != nil {
+		t.Fatal(err)
+	}
+	scan := buf.Bytes()[2:]
+	data := append([]byte{0xff, 0xd8}, jpegSegment(0xfe, []byte("SERIAL-12345"))...)
+	data = append(append(data, iccSegment()...), scan...)
+
+	sanitized, err := Sanitize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// metadata is cut out, image isn't re-encoded
+	want := append(append([]byte{0xff, 0xd8}, iccSegment()...), scan...)
+	if !bytes.Equal(sanitized, want) {
+		t.Errorf("got %d bytes, want %d without comment", len(sanitized), len(want))
+	}
+}
+
+func TestSanitizeWebPKeepsOrientation(t *testing.T) {
+	// extended WebP with EXIF and XMP flags, image data Go can't decode
+	riff := func(name string, chunk []byte) []byte {
+		return append(binary.LittleEndian.AppendUint32([]byte(name), uint32(len(chunk))), chunk...)
+	}
+	exif := metadata.FindExif(rotatedJPEG(t))
+	data := append([]byte("RIFF\x00\x00\x00\x00WEBP"), riff("VP8X", []byte{0x0c, 0, 0, 0, 7, 0, 0, 7, 0, 0})...)
+	data = append(data, riff("VP8L", []byte("notimage"))...)
+	data = append(data, riff("EXIF", exif)...)
+	data = append(data, riff("XMP ", []byte("GPSLatitude"))...)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+
+	sanitized, err := Sanitize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(sanitized, []byte("GPSLatitude")) {
+		t.Errorf("XMP is kept")
+	}
+	if got := metadata.Orientation(sanitized); got != 6 {
+		t.Errorf("got orientation %d, want 6 kept as EXIF", got)
+	}
+}
@@ -0,0 +1,206 @@
+package resizer
+
+import (
+	"encoding/base64"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// blurHashComponents along longer side, 4x3 for landscape images
+	blurHashComponents = 4
+	// blurHashSize is max side of image BlurHash is computed from
+	blurHashSize = 32
+	// lqipSize is max side of LQIP image
+	lqipSize    = 16
+	lqipQuality = 40
+)
+
+// Object metadata keys of placeholders
+const (
+	MetaBlurHash = "blurhash"
+	MetaLQIP     = "lqip"
+)
+
+// Placeholders of an image clients paint before it's loaded
+type Placeholders struct {
+	// BlurHash, see https://blurha.sh
+	BlurHash string
+	// LQIP is tiny blurred JPEG as data URI
+	LQIP string
+}
+
+// Placeholders computes BlurHash and LQIP of img, both fit S3 object metadata
+func (r *Resizer) Placeholders(img image.Image) (*Placeholders, error) {
+	small := thumbnail(img, blurHashSize)
+
+	xComp, yComp := blurHashComponents, blurHashComponents
+	if b := small.Bounds(); b.Dx() >= b.Dy() {
+		yComp = atLeastOne(int(math.Round(float64(blurHashComponents*b.Dy()) / float64(b.Dx()))))
+	} else {
+		xComp = atLeastOne(int(math.Round(float64(blurHashComponents*b.Dx()) / float64(b.Dy()))))
+	}
+
+	lqip, err := Encode(Blur(thumbnail(small, lqipSize), 1), "jpg", lqipQuality)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Placeholders{
+		BlurHash: BlurHash(small, xComp, yComp),
+		LQIP:     "data:" + lqip.ContentType + ";base64," + base64.StdEncoding.EncodeToString(lqip.Bytes),
+	}, nil
+}
+
+// Metadata is object metadata of placeholders
+func (p *Placeholders) Metadata() map[string]string {
+	return map[string]string{
+		MetaBlurHash: p.BlurHash,
+		MetaLQIP:     p.LQIP,
+	}
+}
+
+// PlaceholdersOf reads placeholders from object metadata, nil if there are none
+func PlaceholdersOf(metadata map[string]string) *Placeholders {
+	if metadata[MetaBlurHash] == "" || metadata[MetaLQIP] == "" {
+		return nil
+	}
+
+	return &Placeholders{
+		BlurHash: metadata[MetaBlurHash],
+		LQIP:     metadata[MetaLQIP],
+	}
+}
+
+// thumbnail downscales img to fit size x size
+func thumbnail(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= size && b.Dy() <= size {
+		return img
+	}
+
+	w, h := size, size
+	if b.Dx() >= b.Dy() {
+		h = atLeastOne(b.Dy() * size / b.Dx())
+	} else {
+		w = atLeastOne(b.Dx() * size / b.Dy())
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+
+	return dst
+}
+
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// BlurHash encodes img with xComp x yComp components, 1..9 each.
+// Transparent pixels count as their color, alpha is ignored
+func BlurHash(img image.Image, xComp, yComp int) string {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// linear RGB of pixels
+	pixels := make([][3]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			pixels[y*w+x] = [3]float64{srgbToLinear(c.R), srgbToLinear(c.G), srgbToLinear(c.B)}
+		}
+	}
+
+	factors := make([][3]float64, 0, xComp*yComp)
+	for j := 0; j < yComp; j++ {
+		for i := 0; i < xComp; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				cy := math.Cos(math.Pi * float64(j) * float64(y) / float64(h))
+				for x := 0; x < w; x++ {
+					basis := normalisation * math.Cos(math.Pi*float64(i)*float64(x)/float64(w)) * cy
+					p := pixels[y*w+x]
+					f[0] += basis * p[0]
+					f[1] += basis * p[1]
+					f[2] += basis * p[2]
+				}
+			}
+
+			scale := 1 / float64(w*h)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	encode83(&hash, (xComp-1)+(yComp-1)*9, 1)
+
+	dc, ac := factors[0], factors[1:]
+
+	maxValue := 1.0
+	if len(ac) > 0 {
+		actualMax := 0.0
+		for _, f := range ac {
+			actualMax = math.Max(actualMax, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		encode83(&hash, quantisedMax, 1)
+	} else {
+		encode83(&hash, 0, 1)
+	}
+
+	encode83(&hash, linearToSrgb(dc[0])<<16+linearToSrgb(dc[1])<<8+linearToSrgb(dc[2]), 4)
+
+	for _, f := range ac {
+		quant := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+		}
+		encode83(&hash, quant(f[0])*19*19+quant(f[1])*19+quant(f[2]), 2)
+	}
+
+	return hash.String()
+}
+
+func encode83(sb *strings.Builder, value, length int) {
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		sb.WriteByte(base83[digit])
+	}
+}
+
+func srgbToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSrgb(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+func atLeastOne(v int) int {
+	if v < 1 {
+		return 1
+	}
+
+	return v
+}
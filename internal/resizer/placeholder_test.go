@@ -0,0 +1,145 @@
+package resizer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"testing"
+)
+
+func uniform(width, height int, c color.Color) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+
+	return img
+}
+
+// decode83 is the inverse of encode83
+func decode83(s string) int {
+	v := 0
+	for _, c := range s {
+		v = v*83 + strings.IndexRune(base83, c)
+	}
+
+	return v
+}
+
+func TestBlurHash(t *testing.T) {
+	tests := []struct {
+		name  string
+		img   image.Image
+		xComp int
+		yComp int
+		// sizes is (xComp-1)+(yComp-1)*9, dc is sRGB of the average
+		sizes string
+		dc    string
+	}{
+		{"red", uniform(8, 6, red), 4, 3, "L", "TI:j"},
+		{"blue", uniform(8, 6, blue), 4, 3, "L", "0036"},
+		{"portrait", uniform(6, 8, red), 3, 4, "T", "TI:j"},
+	}
+
+	for _, tt := range tests {
+		hash := BlurHash(tt.img, tt.xComp, tt.yComp)
+		if len(hash) != 4+2*tt.xComp*tt.yComp {
+			t.Errorf("%s: got %s of %d chars, want %d", tt.name, hash, len(hash), 4+2*tt.xComp*tt.yComp)
+			continue
+		}
+
+		if hash[:1] != tt.sizes || hash[2:6] != tt.dc {
+			t.Errorf("%s: got %s, want sizes %s and average %s", tt.name, hash, tt.sizes, tt.dc)
+		}
+	}
+
+	// without AC components max AC is 0
+	if hash := BlurHash(uniform(8, 6, red), 1, 1); hash != "00TI:j" {
+		t.Errorf("got %s, want 00TI:j", hash)
+	}
+}
+
+func TestBlurHashAverage(t *testing.T) {
+	hash := BlurHash(halves(32, 16), 4, 3)
+	if len(hash) != 4+2*4*3 {
+		t.Fatalf("got %s of %d chars", hash, len(hash))
+	}
+
+	// DC is the average in linear RGB, half of red and half of blue
+	dc := decode83(hash[2:6])
+	r, g, b := dc>>16, dc>>8&0xff, dc&0xff
+	if r < 180 || r > 195 || g != 0 || b < 180 || b > 195 {
+		t.Errorf("got average %d,%d,%d, want about 188,0,188", r, g, b)
+	}
+
+	// the first horizontal component tells red is on the left
+	mirrored := image.NewNRGBA(image.Rect(0, 0, 32, 16))
+	draw.Draw(mirrored, image.Rect(0, 0, 16, 16), image.NewUniform(blue), image.Point{}, draw.Src)
+	draw.Draw(mirrored, image.Rect(16, 0, 32, 16), image.NewUniform(red), image.Point{}, draw.Src)
+	if other := BlurHash(mirrored, 4, 3); other[:6] != hash[:6] || other[6:8] == hash[6:8] {
+		t.Errorf("got %s for mirrored %s, want the same average and other components", other, hash)
+	}
+}
+
+func TestPlaceholders(t *testing.T) {
+	r := newTestResizer()
+
+	tests := []struct {
+		name   string
+		img    image.Image
+		sizes  byte
+		width  int
+		height int
+	}{
+		// sizes flag is (xComp-1)+(yComp-1)*9
+		{"landscape", halves(400, 200), base83[3+1*9], 16, 8},
+		{"portrait", halves(200, 400), base83[1+3*9], 8, 16},
+		{"square", halves(40, 40), base83[3+3*9], 16, 16},
+		{"thin", halves(1, 100), base83[0+3*9], 1, 16},
+		{"tiny", halves(4, 2), base83[3+1*9], 4, 2},
+	}
+
+	for _, tt := range tests {
+		p, err := r.Placeholders(tt.img)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if p.BlurHash == "" || p.BlurHash[0] != tt.sizes {
+			t.Errorf("%s: got BlurHash %s, want sizes %c", tt.name, p.BlurHash, tt.sizes)
+		}
+
+		data, ok := strings.CutPrefix(p.LQIP, "data:image/jpeg;base64,")
+		if !ok {
+			t.Fatalf("%s: got LQIP %.40s", tt.name, p.LQIP)
+		}
+		jpg, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(jpg))
+		if err != nil || format != "jpeg" || cfg.Width != tt.width || cfg.Height != tt.height {
+			t.Errorf("%s: got LQIP %s %dx%d, %v, want jpeg %dx%d", tt.name, format, cfg.Width, cfg.Height, err, tt.width, tt.height)
+		}
+
+		// both fit S3 user metadata, 2KB in total
+		if len(p.BlurHash)+len(p.LQIP) > 2048 {
+			t.Errorf("%s: got %d bytes of placeholders", tt.name, len(p.BlurHash)+len(p.LQIP))
+		}
+	}
+}
+
+func TestPlaceholdersOf(t *testing.T) {
+	p := &Placeholders{BlurHash: "L0TI:j", LQIP: "data:image/jpeg;base64,AA=="}
+
+	if got := PlaceholdersOf(p.Metadata()); got == nil || *got != *p {
+		t.Errorf("got %+v, want %+v", got, p)
+	}
+
+	for _, metadata := range []map[string]string{nil, {MetaBlurHash: "L0TI:j"}, {MetaLQIP: p.LQIP}} {
+		if got := PlaceholdersOf(metadata); got != nil {
+			t.Errorf("%v: got %+v, want nil", metadata, got)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package resizer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/WildEgor/gImageResizer/internal/imgproxy"
+	"github.com/WildEgor/gImageResizer/internal/metadata"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrUnsupportedImage  = errors.New("[Resizer] Unsupported image")
+	ErrUnsupportedFormat = errors.New("[Resizer] Unsupported output format")
+)
+
+const defaultQuality = 80
+
+// Resizer processes images in-process with the same options imgproxy gets.
+// Decodes JPEG, PNG, GIF (first frame) and WebP, encodes JPEG, PNG and GIF.
+// Watermarks aren't supported
+type Resizer struct {
+	filter draw.Interpolator
+}
+
+func NewResizer(config *configs.ResizerConfig) *Resizer {
+	filter, ok := Filters[config.Filter]
+	if !ok {
+		log.Fatalf("[Resizer] Unknown filter %v", config.Filter)
+	}
+
+	return &Resizer{
+		filter: filter,
+	}
+}
+
+// Result is processed image
+type Result struct {
+	Bytes       []byte
+	ContentType string
+	Format      string
+	Width       int
+	Height      int
+}
+
+// Process decodes src, applies opts and encodes result to opts.Format,
+// or to the source format when it isn't set. Formats Encode doesn't
+// support, webp and avif, fall back to jpg, or png if image has alpha.
+// Result.Format and ContentType tell what is returned
+func (r *Resizer) Process(src io.Reader, opts *imgproxy.Options) (*Result, error) {
+	img, format, err := Decode(src)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.ProcessImage(img, format, opts)
+}
+
+// Decode reads image and its format name, EXIF orientation is applied
+// like imgproxy does, so results are never sideways
+func Decode(src io.Reader) (image.Image, string, error) {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, "", err
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrUnsupportedImage
+	}
+
+	return Orient(img, metadata.Orientation(data)), format, nil
+}
+
+// ProcessImage is Process of already decoded image, so that one source
+// can be rendered with several options
+func (r *Resizer) ProcessImage(img image.Image, format string, opts *imgproxy.Options) (*Result, error) {
+	img = r.Transform(img, opts)
+
+	return Encode(img, outputFormat(format, opts.Format, img), opts.Quality)
+}
+
+// Transform applies crop, resize, extend, blur and sharpen of opts to img
+func (r *Resizer) Transform(img image.Image, opts *imgproxy.Options) image.Image {
+	if opts.Crop != nil {
+		img = crop(img, opts.Crop.Width, opts.Crop.Height, opts.Crop.Gravity)
+	}
+
+	img = r.resize(img, opts)
+
+	if opts.Extend && opts.Width > 0 && opts.Height > 0 {
+		img = extend(img, opts.Width, opts.Height, opts.Gravity, background(opts.Background))
+	}
+
+	if opts.Blur > 0 {
+		img = Blur(img, opts.Blur)
+	}
+
+	if opts.Sharpen > 0 {
+		img = sharpen(img, opts.Sharpen)
+	}
+
+	return img
+}
+
+// Encode writes img in one of jpg, png or gif formats
+func Encode(img image.Image, format string, quality int) (*Result, error) {
+	if quality <= 0 {
+		quality = defaultQuality
+	}
+
+	var buf bytes.Buffer
+	var contentType string
+
+	switch format {
+	case "jpg", "jpeg":
+		format, contentType = "jpg", "image/jpeg"
+		// JPEG has no alpha, flatten on white
+		if !isOpaque(img) {
+			img = extend(img, img.Bounds().Dx(), img.Bounds().Dy(), "", color.White)
+		}
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
+		}
+	case "png":
+		contentType = "image/png"
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+	case "gif":
+		contentType = "image/gif"
+		if err := gif.Encode(&buf, img, nil); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+
+	return &Result{
+		Bytes:       buf.Bytes(),
+		ContentType: contentType,
+		Format:      format,
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+	}, nil
+}
+
+// outputFormat picks encoder, formats we can't encode fall back
+// to png for images with alpha and to jpg otherwise
+func outputFormat(source, requested string, img image.Image) string {
+	format := requested
+	if format == "" {
+		format = source
+	}
+
+	switch format {
+	case "jpg", "jpeg", "png", "gif":
+		return format
+	}
+
+	if isOpaque(img) {
+		return "jpg"
+	}
+
+	return "png"
+}
+
+func (r *Resizer) resize(img image.Image, opts *imgproxy.Options) image.Image {
+	b := img.Bounds()
+	w, h := float64(b.Dx()), float64(b.Dy())
+	tw, th := float64(opts.Width), float64(opts.Height)
+
+	if tw == 0 && th == 0 {
+		return img
+	}
+
+	resizeType := opts.ResizeType
+	if resizeType == imgproxy.ResizeAuto {
+		resizeType = imgproxy.ResizeFit
+		if tw > 0 && th > 0 && (w >= h) == (tw >= th) {
+			resizeType = imgproxy.ResizeFill
+		}
+	}
+
+	if resizeType == imgproxy.ResizeForce {
+		dw, dh := tw, th
+		if dw == 0 {
+			dw = w
+		}
+		if dh == 0 {
+			dh = h
+		}
+		return r.scale(img, int(dw), int(dh))
+	}
+
+	wScale, hScale := tw/w, th/h
+	if tw == 0 {
+		wScale = hScale
+	}
+	if th == 0 {
+		hScale = wScale
+	}
+
+	fill := resizeType == imgproxy.ResizeFill || resizeType == imgproxy.ResizeFillDown
+
+	scale := math.Min(wScale, hScale)
+	if fill {
+		scale = math.Max(wScale, hScale)
+	}
+	if !opts.Enlarge && scale > 1 {
+		scale = 1
+	}
+
+	dw := int(math.Max(1, math.Round(w*scale)))
+	dh := int(math.Max(1, math.Round(h*scale)))
+	if dw != b.Dx() || dh != b.Dy() {
+		img = r.scale(img, dw, dh)
+	}
+
+	if fill {
+		cw, ch := dw, dh
+		if tw > 0 && int(tw) < cw {
+			cw = int(tw)
+		}
+		if th > 0 && int(th) < ch {
+			ch = int(th)
+		}
+		img = crop(img, cw, ch, opts.Gravity)
+	}
+
+	return img
+}
+
+func (r *Resizer) scale(img image.Image, w, h int) image.Image {
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	r.filter.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	return dst
+}
+
+// crop cuts w x h area positioned by gravity, zero size keeps source size
+func crop(img image.Image, w, h int, gravity imgproxy.Gravity) image.Image {
+	b := img.Bounds()
+	if w <= 0 || w > b.Dx() {
+		w = b.Dx()
+	}
+	if h <= 0 || h > b.Dy() {
+		h = b.Dy()
+	}
+	if w == b.Dx() && h == b.Dy() {
+		return img
+	}
+
+	x, y := position(b.Dx()-w, b.Dy()-h, gravity)
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), img, b.Min.Add(image.Pt(x, y)), draw.Src)
+
+	return dst
+}
+
+// extend places img on w x h canvas filled with bg
+func extend(img image.Image, w, h int, gravity imgproxy.Gravity, bg color.Color) image.Image {
+	b := img.Bounds()
+	if w < b.Dx() {
+		w = b.Dx()
+	}
+	if h < b.Dy() {
+		h = b.Dy()
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	x, y := position(w-b.Dx(), h-b.Dy(), gravity)
+	draw.Draw(dst, image.Rect(x, y, x+b.Dx(), y+b.Dy()), img, b.Min, draw.Over)
+
+	return dst
+}
+
+// position offsets an area inside free space dx x dy by gravity
+func position(dx, dy int, gravity imgproxy.Gravity) (int, int) {
+	x, y := dx/2, dy/2
+
+	switch gravity {
+	case imgproxy.GravityNorth:
+		y = 0
+	case imgproxy.GravitySouth:
+		y = dy
+	case imgproxy.GravityEast:
+		x = dx
+	case imgproxy.GravityWest:
+		x = 0
+	case imgproxy.GravityNorthEast:
+		x, y = dx, 0
+	case imgproxy.GravityNorthWest:
+		x, y = 0, 0
+	case imgproxy.GravitySouthEast:
+		x, y = dx, dy
+	case imgproxy.GravitySouthWest:
+		x, y = 0, dy
+	}
+
+	return x, y
+}
+
+// background parses hex color like "ffffff" or "ffffff80", transparent by default
+func background(hex string) color.Color {
+	var c color.NRGBA
+
+	hex = strings.TrimPrefix(hex, "#")
+	switch len(hex) {
+	case 6:
+		if _, err := fmt.Sscanf(hex, "%02x%02x%02x", &c.R, &c.G, &c.B); err != nil {
+			return color.Transparent
+		}
+		c.A = 0xff
+	case 8:
+		if _, err := fmt.Sscanf(hex, "%02x%02x%02x%02x", &c.R, &c.G, &c.B, &c.A); err != nil {
+			return color.Transparent
+		}
+	default:
+		return color.Transparent
+	}
+
+	return c
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+
+	return false
+}
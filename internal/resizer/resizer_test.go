@@ -0,0 +1,196 @@
+package resizer
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/WildEgor/gImageResizer/internal/configs"
+	"github.com/WildEgor/gImageResizer/internal/imgproxy"
+)
+
+var green = color.NRGBA{G: 255, A: 255}
+
+func newTestResizer() *Resizer {
+	return NewResizer(&configs.ResizerConfig{Filter: "nearest"})
+}
+
+func TestTransformGeometry(t *testing.T) {
+	r := newTestResizer()
+
+	tests := []struct {
+		name   string
+		opts   imgproxy.Options
+		width  int
+		height int
+	}{
+		{"fit", imgproxy.Options{ResizeType: imgproxy.ResizeFit, Width: 100, Height: 100}, 100, 50},
+		{"fill", imgproxy.Options{ResizeType: imgproxy.ResizeFill, Width: 100, Height: 100}, 100, 100},
+		{"fill-down", imgproxy.Options{ResizeType: imgproxy.ResizeFillDown, Width: 100, Height: 100}, 100, 100},
+		{"force", imgproxy.Options{ResizeType: imgproxy.ResizeForce, Width: 100, Height: 100}, 100, 100},
+		{"force width", imgproxy.Options{ResizeType: imgproxy.ResizeForce, Width: 100}, 100, 200},
+		// auto fills when orientations match and fits otherwise
+		{"auto landscape", imgproxy.Options{ResizeType: imgproxy.ResizeAuto, Width: 100, Height: 80}, 100, 80},
+		{"auto portrait", imgproxy.Options{ResizeType: imgproxy.ResizeAuto, Width: 50, Height: 100}, 50, 25},
+		{"width", imgproxy.Options{ResizeType: imgproxy.ResizeFit, Width: 100}, 100, 50},
+		{"height", imgproxy.Options{ResizeType: imgproxy.ResizeFit, Height: 50}, 100, 50},
+		{"none", imgproxy.Options{ResizeType: imgproxy.ResizeFit}, 400, 200},
+		{"no enlarge", imgproxy.Options{ResizeType: imgproxy.ResizeFit, Width: 800, Height: 800}, 400, 200},
+		{"enlarge", imgproxy.Options{ResizeType: imgproxy.ResizeFit, Width: 800, Height: 800, Enlarge: true}, 800, 400},
+		{"fill no enlarge", imgproxy.Options{ResizeType: imgproxy.ResizeFill, Width: 800, Height: 100}, 400, 100},
+		{"extend", imgproxy.Options{ResizeType: imgproxy.ResizeFit, Width: 500, Height: 500, Extend: true}, 500, 500},
+		{"crop", imgproxy.Options{Crop: &imgproxy.Crop{Width: 100, Height: 300}}, 100, 200},
+		{"crop and fit", imgproxy.Options{ResizeType: imgproxy.ResizeFit, Width: 50, Crop: &imgproxy.Crop{Width: 200, Height: 200}}, 50, 50},
+		{"crop width", imgproxy.Options{Crop: &imgproxy.Crop{Width: 100}}, 100, 200},
+	}
+
+	for _, tt := range tests {
+		opts := tt.opts
+		b := r.Transform(halves(400, 200), &opts).Bounds()
+		if b.Dx() != tt.width || b.Dy() != tt.height {
+			t.Errorf("%s: got %dx%d, want %dx%d", tt.name, b.Dx(), b.Dy(), tt.width, tt.height)
+		}
+	}
+}
+
+func TestTransformGravity(t *testing.T) {
+	r := newTestResizer()
+
+	tests := []struct {
+		name string
+		opts imgproxy.Options
+		// left is the color of the left half of result, right of the right one
+		left, right color.Color
+	}{
+		{"fill center", imgproxy.Options{ResizeType: imgproxy.ResizeFill, Width: 100, Height: 100}, red, blue},
+		{"fill west", imgproxy.Options{ResizeType: imgproxy.ResizeFill, Width: 100, Height: 100, Gravity: imgproxy.GravityWest}, red, red},
+		{"fill east", imgproxy.Options{ResizeType: imgproxy.ResizeFill, Width: 100, Height: 100, Gravity: imgproxy.GravityEast}, blue, blue},
+		{"crop north west", imgproxy.Options{Crop: &imgproxy.Crop{Width: 100, Height: 100, Gravity: imgproxy.GravityNorthWest}}, red, red},
+		{"crop south east", imgproxy.Options{Crop: &imgproxy.Crop{Width: 100, Height: 100, Gravity: imgproxy.GravitySouthEast}}, blue, blue},
+	}
+
+	for _, tt := range tests {
+		opts := tt.opts
+		img := r.Transform(halves(400, 200), &opts)
+		b := img.Bounds()
+		if !sameColor(img.At(b.Min.X, b.Min.Y+b.Dy()/2), tt.left) || !sameColor(img.At(b.Max.X-1, b.Min.Y+b.Dy()/2), tt.right) {
+			t.Errorf("%s: got %v on the left and %v on the right, want %v and %v", tt.name,
+				img.At(b.Min.X, b.Min.Y+b.Dy()/2), img.At(b.Max.X-1, b.Min.Y+b.Dy()/2), tt.left, tt.right)
+		}
+	}
+}
+
+func TestTransformExtend(t *testing.T) {
+	r := newTestResizer()
+
+	tests := []struct {
+		name       string
+		gravity    imgproxy.Gravity
+		background string
+		// top and bottom are colors at the middle of top and bottom rows
+		top, bottom color.Color
+	}{
+		{"center", "", "00ff00", green, green},
+		{"north", imgproxy.GravityNorth, "00ff00", blue, green},
+		{"south", imgproxy.GravitySouth, "#00ff00", green, blue},
+		{"transparent", imgproxy.GravityNorth, "", blue, color.NRGBA{}},
+		{"bad background", imgproxy.GravityNorth, "green", blue, color.NRGBA{}},
+	}
+
+	for _, tt := range tests {
+		opts := imgproxy.Options{Width: 400, Height: 400, Extend: true, Gravity: tt.gravity, Background: tt.background}
+		img := r.Transform(halves(400, 200), &opts)
+		if b := img.Bounds(); b.Dx() != 400 || b.Dy() != 400 {
+			t.Fatalf("%s: got %v, want 400x400", tt.name, b)
+		}
+
+		if !sameColor(img.At(300, 0), tt.top) || !sameColor(img.At(300, 399), tt.bottom) {
+			t.Errorf("%s: got %v on top and %v at the bottom, want %v and %v", tt.name, img.At(300, 0), img.At(300, 399), tt.top, tt.bottom)
+		}
+	}
+}
+
+func TestProcessFormats(t *testing.T) {
+	r := newTestResizer()
+
+	transparent := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	opaque := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	for i := 3; i < len(opaque.Pix); i += 4 {
+		opaque.Pix[i] = 0xff
+	}
+
+	tests := []struct {
+		name        string
+		img         image.Image
+		source      string
+		format      string
+		want        string
+		contentType string
+	}{
+		{"source format", transparent, "png", "", "png", "image/png"},
+		{"requested format", opaque, "png", "gif", "gif", "image/gif"},
+		{"jpeg", opaque, "png", "jpeg", "jpg", "image/jpeg"},
+		// Encode can't write webp and avif
+		{"webp", opaque, "png", "webp", "jpg", "image/jpeg"},
+		{"webp with alpha", transparent, "png", "webp", "png", "image/png"},
+		{"avif", opaque, "jpeg", "avif", "jpg", "image/jpeg"},
+		{"avif with alpha", transparent, "png", "avif", "png", "image/png"},
+		{"webp source", opaque, "webp", "", "jpg", "image/jpeg"},
+		{"webp source with alpha", transparent, "webp", "", "png", "image/png"},
+	}
+
+	for _, tt := range tests {
+		res, err := r.ProcessImage(tt.img, tt.source, &imgproxy.Options{Format: tt.format, Width: 10})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if res.Format != tt.want || res.ContentType != tt.contentType {
+			t.Errorf("%s: got %s %s, want %s %s", tt.name, res.Format, res.ContentType, tt.want, tt.contentType)
+		}
+
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(res.Bytes))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if "image/"+format != tt.contentType || cfg.Width != 10 || cfg.Height != 5 || res.Width != 10 || res.Height != 5 {
+			t.Errorf("%s: got %s %dx%d, result says %dx%d, want 10x5", tt.name, format, cfg.Width, cfg.Height, res.Width, res.Height)
+		}
+	}
+}
+
+func TestProcess(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, halves(40, 20)); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := newTestResizer().Process(&buf, &imgproxy.Options{ResizeType: imgproxy.ResizeFit, Width: 20, Format: "webp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Format != "jpg" || res.Width != 20 || res.Height != 10 {
+		t.Errorf("got %s %dx%d, want jpg 20x10", res.Format, res.Width, res.Height)
+	}
+
+	if _, err := newTestResizer().Process(bytes.NewReader([]byte("not image")), &imgproxy.Options{}); err != ErrUnsupportedImage {
+		t.Errorf("got %v, want %v", err, ErrUnsupportedImage)
+	}
+
+	if _, err := Encode(halves(4, 4), "webp", 0); err != ErrUnsupportedFormat {
+		t.Errorf("got %v, want %v", err, ErrUnsupportedFormat)
+	}
+}
+
+// sameColor compares colors ignoring rounding of resampling
+func sameColor(a, b color.Color) bool {
+	ar, ag, ab, aa := a.RGBA()
+	br, bg, bb, ba := b.RGBA()
+	near := func(x, y uint32) bool {
+		return x-y < 0x800 || y-x < 0x800
+	}
+
+	return near(ar, br) && near(ag, bg) && near(ab, bb) && near(aa, ba)
+}
@@ -0,0 +1,9 @@
+package resizer
+
+import "strings"
+
+// VariantKey is storage key of preset variant of the file: _<preset>/<key>.
+// Keys starting with "_" are internal and never collide with uploads
+func VariantKey(key, preset string) string {
+	return "_" + strings.TrimPrefix(preset, "_") + "/" + key
+}
@@ -0,0 +1,9 @@
+package resizer
+
+import (
+	"github.com/google/wire"
+)
+
+var ResizerSet = wire.NewSet(
+	NewResizer,
+)
@@ -9,17 +9,38 @@ import (
 )
 
 type HTTPRouter struct {
-	saveFilesHandler    *handlers.SaveFilesHandler
-	downloadFileHandler *handlers.DownloadFileHandler
+	saveFilesHandler       *handlers.SaveFilesHandler
+	downloadFileHandler    *handlers.DownloadFileHandler
+	tusHandler             *handlers.TusHandler
+	presignUploadHandler   *handlers.PresignUploadHandler
+	finalizeUploadHandler  *handlers.FinalizeUploadHandler
+	jobsHandler            *handlers.JobsHandler
+	fileMetaHandler        *handlers.FileMetaHandler
+	deleteFileHandler      *handlers.DeleteFileHandler
+	presignDownloadHandler *handlers.PresignDownloadHandler
 }
 
 func NewHTTPRouter(
 	saveFilesHandler *handlers.SaveFilesHandler,
 	downloadFileHandler *handlers.DownloadFileHandler,
+	tusHandler *handlers.TusHandler,
+	presignUploadHandler *handlers.PresignUploadHandler,
+	finalizeUploadHandler *handlers.FinalizeUploadHandler,
+	jobsHandler *handlers.JobsHandler,
+	fileMetaHandler *handlers.FileMetaHandler,
+	deleteFileHandler *handlers.DeleteFileHandler,
+	presignDownloadHandler *handlers.PresignDownloadHandler,
 ) *HTTPRouter {
 	return &HTTPRouter{
-		saveFilesHandler:    saveFilesHandler,
-		downloadFileHandler: downloadFileHandler,
+		saveFilesHandler:       saveFilesHandler,
+		downloadFileHandler:    downloadFileHandler,
+		tusHandler:             tusHandler,
+		presignUploadHandler:   presignUploadHandler,
+		finalizeUploadHandler:  finalizeUploadHandler,
+		jobsHandler:            jobsHandler,
+		fileMetaHandler:        fileMetaHandler,
+		deleteFileHandler:      deleteFileHandler,
+		presignDownloadHandler: presignDownloadHandler,
 	}
 }
 
@@ -29,8 +50,33 @@ func (r *HTTPRouter) SetupRoutes(app *fiber.App) error {
 
 	upload := v1.Group("/upload")
 
+	upload.Get("/", r.downloadFileHandler.List)
 	upload.Post("/", r.saveFilesHandler.Handle)
+	upload.Post("/presign", r.presignUploadHandler.Handle)
+	upload.Post("/finalize", r.finalizeUploadHandler.Handle)
+	upload.Post("/delete", r.deleteFileHandler.Batch)
+	upload.Get("/:key/meta", r.fileMetaHandler.Handle)
+	upload.Get("/:key/presign", r.presignDownloadHandler.Handle)
+	// before GET, fiber serves HEAD with GET handlers too
+	upload.Head("/:key", r.downloadFileHandler.Head)
 	upload.Get("/:key", r.downloadFileHandler.Handle)
+	upload.Delete("/:key", r.deleteFileHandler.Handle)
+
+	// Resumable uploads, see https://tus.io/protocols/resumable-upload
+	tus := v1.Group("/tus", r.tusHandler.Resumable)
+
+	tus.Options("/", r.tusHandler.Options)
+	tus.Post("/", r.tusHandler.Create)
+	tus.Head("/:id", r.tusHandler.Head)
+	tus.Patch("/:id", r.tusHandler.Patch)
+	tus.Get("/:id", r.tusHandler.Get)
+	tus.Delete("/:id", r.tusHandler.Terminate)
+
+	jobs := v1.Group("/jobs")
+
+	jobs.Get("/dead", r.jobsHandler.Dead)
+	jobs.Get("/:id", r.jobsHandler.Get)
+	jobs.Post("/:id/retry", r.jobsHandler.Retry)
 
 	return nil
 }
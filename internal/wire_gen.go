@@ -8,8 +8,13 @@ package app
 
 import (
 	"github.com/WildEgor/gImageResizer/internal/adapters"
+	"github.com/WildEgor/gImageResizer/internal/cas"
 	"github.com/WildEgor/gImageResizer/internal/configs"
 	"github.com/WildEgor/gImageResizer/internal/handlers/http"
+	"github.com/WildEgor/gImageResizer/internal/imgproxy"
+	"github.com/WildEgor/gImageResizer/internal/jobs"
+	"github.com/WildEgor/gImageResizer/internal/policy"
+	"github.com/WildEgor/gImageResizer/internal/resizer"
 	"github.com/WildEgor/gImageResizer/internal/routers"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/wire"
@@ -19,13 +24,33 @@ import (
 
 func NewServer() (*fiber.App, error) {
 	appConfig := configs.NewAppConfig()
+	uploadConfig := configs.NewUploadConfig()
 	s3Config := configs.NewS3Config()
-	s3Adapter := adapters.NewS3Adapter(s3Config)
-	saveFilesHandler := handlers.NewSaveFilesHandler(appConfig, s3Adapter)
+	resizerConfig := configs.NewResizerConfig()
+	storageConfig := configs.NewStorageConfig()
+	is3Adapter := adapters.NewStorageAdapter(storageConfig, s3Config)
+	jobsConfig := configs.NewJobsConfig()
+	iJobStore := jobs.NewJobStore(jobsConfig)
 	imgProxyConfig := configs.NewImgProxyConfig()
-	downloadFileHandler := handlers.NewDownloadFileHandler(imgProxyConfig, appConfig, s3Adapter)
-	httpRouter := routers.NewHTTPRouter(saveFilesHandler, downloadFileHandler)
-	app := NewApp(appConfig, httpRouter)
+	presets := imgproxy.NewPresets(imgProxyConfig)
+	resizerResizer := resizer.NewResizer(resizerConfig)
+	limits := policy.NewLimits(appConfig, uploadConfig)
+	tasks := jobs.NewTasks(s3Config, resizerConfig, is3Adapter, presets, resizerResizer, limits)
+	queue := jobs.NewQueue(jobsConfig, iJobStore, tasks)
+	policies := policy.NewPolicies(uploadConfig)
+	store := cas.NewStore(uploadConfig, is3Adapter)
+	saveFilesHandler := handlers.NewSaveFilesHandler(appConfig, uploadConfig, s3Config, resizerConfig, is3Adapter, queue, resizerResizer, policies, limits, store)
+	downloadFileHandler := handlers.NewDownloadFileHandler(imgProxyConfig, appConfig, storageConfig, s3Config, resizerConfig, is3Adapter, presets, resizerResizer, limits, store)
+	tusHandler := handlers.NewTusHandler(appConfig, s3Config, resizerConfig, uploadConfig, is3Adapter, queue, policies, limits)
+	presignUploadHandler := handlers.NewPresignUploadHandler(s3Config, uploadConfig, is3Adapter, policies, limits)
+	finalizeUploadHandler := handlers.NewFinalizeUploadHandler(appConfig, resizerConfig, uploadConfig, is3Adapter, queue, policies, limits)
+	jobsHandler := handlers.NewJobsHandler(queue)
+	fileMetaHandler := handlers.NewFileMetaHandler(tasks, store)
+	deleteFileHandler := handlers.NewDeleteFileHandler(is3Adapter, tasks, store)
+	presignDownloadHandler := handlers.NewPresignDownloadHandler(s3Config, storageConfig, is3Adapter, store)
+	httpRouter := routers.NewHTTPRouter(saveFilesHandler, downloadFileHandler, tusHandler, presignUploadHandler, finalizeUploadHandler, jobsHandler, fileMetaHandler, deleteFileHandler, presignDownloadHandler)
+	purger := handlers.NewPurger(presignUploadHandler, tusHandler)
+	app := NewApp(appConfig, httpRouter, queue, purger)
 	return app, nil
 }
 